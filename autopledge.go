@@ -0,0 +1,186 @@
+package sealing
+
+import (
+	"context"
+	"time"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/sector-storage/stores"
+	"github.com/filecoin-project/specs-actors/actors/abi/big"
+)
+
+type AutoPledgeConfig struct {
+	// Sectors is the number of sectors to keep in the sealing pipeline
+	//  (Packing through CommitWait), including pledges being filled
+	Sectors int
+	// Interval between pipeline checks
+	Interval time.Duration
+
+	// No new sectors are pledged when the worker balance is below
+	//  MinWorkerBalance, or free local storage is below MinFreeSpace
+	MinWorkerBalance big.Int
+	MinFreeSpace     uint64
+}
+
+// localStorage is implemented by sector managers which can report local
+// storage usage, like sectorstorage.Manager
+type localStorage interface {
+	StorageLocal(ctx context.Context) (map[stores.ID]string, error)
+	FsStat(ctx context.Context, id stores.ID) (stores.FsStat, error)
+}
+
+// StartAutoPledge starts a loop which pledges CC sectors to keep the sealing
+// pipeline full
+func (m *Sealing) StartAutoPledge(cfg AutoPledgeConfig) error {
+	if cfg.Sectors <= 0 {
+		return xerrors.Errorf("auto-pledge sector count must be positive, got %d", cfg.Sectors)
+	}
+	if cfg.Interval <= 0 {
+		return xerrors.Errorf("auto-pledge interval must be positive, got %s", cfg.Interval)
+	}
+	if cfg.MinWorkerBalance.Int == nil {
+		cfg.MinWorkerBalance = big.Zero()
+	}
+
+	m.autoPledgeLk.Lock()
+	defer m.autoPledgeLk.Unlock()
+
+	if m.autoPledgeStop != nil {
+		return xerrors.New("auto-pledge already running")
+	}
+
+	done, ok := m.startWork()
+	if !ok {
+		return xerrors.New("sealing is stopping")
+	}
+
+	stop := make(chan struct{})
+	m.autoPledgeStop = stop
+
+	go func() {
+		defer done()
+		m.autoPledgeLoop(cfg, stop)
+	}()
+
+	return nil
+}
+
+func (m *Sealing) StopAutoPledge() {
+	m.autoPledgeLk.Lock()
+	defer m.autoPledgeLk.Unlock()
+
+	if m.autoPledgeStop != nil {
+		close(m.autoPledgeStop)
+		m.autoPledgeStop = nil
+	}
+}
+
+func (m *Sealing) autoPledgeLoop(cfg AutoPledgeConfig, stop <-chan struct{}) {
+	var inflight []*PledgeHandle
+
+	t := time.NewTicker(cfg.Interval)
+	defer t.Stop()
+
+	for {
+		inflight = m.autoPledgeTick(m.lifeCtx, cfg, inflight)
+
+		select {
+		case <-t.C:
+		case <-stop:
+			return
+		case <-m.stopping:
+			return
+		}
+	}
+}
+
+func (m *Sealing) autoPledgeTick(ctx context.Context, cfg AutoPledgeConfig, inflight []*PledgeHandle) []*PledgeHandle {
+	// pledges which are done are in the state machine, and counted there
+	filling := inflight[:0]
+	for _, h := range inflight {
+		select {
+		case <-h.Done():
+		default:
+			filling = append(filling, h)
+		}
+	}
+
+	sectors, err := m.ListSectors()
+	if err != nil {
+		log.Errorf("auto-pledge: listing sectors: %+v", err)
+		return filling
+	}
+
+	sealing := countSealing(sectors) + len(filling)
+	if sealing >= cfg.Sectors {
+		return filling
+	}
+
+	if err := m.checkPledgeResources(ctx, cfg); err != nil {
+		log.Warnf("auto-pledge: not pledging new sectors: %+v", err)
+		return filling
+	}
+
+	log.Infof("auto-pledge: %d sectors sealing, pledging %d", sealing, cfg.Sectors-sealing)
+
+	for ; sealing < cfg.Sectors; sealing++ {
+		h, err := m.PledgeSectorAsync(ctx)
+		if err != nil {
+			log.Errorf("auto-pledge: %+v", err)
+			break
+		}
+		filling = append(filling, h)
+	}
+
+	return filling
+}
+
+func (m *Sealing) checkPledgeResources(ctx context.Context, cfg AutoPledgeConfig) error {
+	bal, err := m.api.WalletBalance(ctx, m.worker)
+	if err != nil {
+		return xerrors.Errorf("getting worker balance: %w", err)
+	}
+	if bal.LessThan(cfg.MinWorkerBalance) {
+		return xerrors.Errorf("worker balance too low: %s < %s", bal, cfg.MinWorkerBalance)
+	}
+
+	if cfg.MinFreeSpace == 0 {
+		return nil
+	}
+
+	ls, ok := m.sealer.(localStorage)
+	if !ok {
+		return xerrors.New("sector manager can't report free local storage, MinFreeSpace can't be checked")
+	}
+
+	paths, err := ls.StorageLocal(ctx)
+	if err != nil {
+		return xerrors.Errorf("getting local storage: %w", err)
+	}
+
+	var avail uint64
+	for id := range paths {
+		st, err := ls.FsStat(ctx, id)
+		if err != nil {
+			return xerrors.Errorf("getting stat for storage %s: %w", id, err)
+		}
+		avail += st.Available
+	}
+
+	if avail < cfg.MinFreeSpace {
+		return xerrors.Errorf("not enough free local storage: %d < %d", avail, cfg.MinFreeSpace)
+	}
+
+	return nil
+}
+
+func countSealing(sectors []SectorInfo) int {
+	var n int
+	for _, sector := range sectors {
+		if _, ok := sealingStates[sector.State]; ok {
+			n++
+		}
+	}
+	return n
+}
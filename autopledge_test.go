@@ -0,0 +1,117 @@
+package sealing_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/specs-actors/actors/abi/big"
+
+	sealing "github.com/filecoin-project/storage-fsm"
+	"github.com/filecoin-project/storage-fsm/mock"
+)
+
+func (h *harness) sectorCount() int {
+	sectors, err := h.m.ListSectors()
+	require.NoError(h.t, err)
+	return len(sectors)
+}
+
+// waitSectorCount waits for the number of sectors to reach n, and checks that
+// it stays there for a few auto-pledge intervals
+func (h *harness) waitSectorCount(n int) {
+	deadline := time.Now().Add(10 * time.Second)
+	for h.sectorCount() < n {
+		if time.Now().After(deadline) {
+			h.t.Fatalf("waiting for %d sectors, have %d", n, h.sectorCount())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	require.Equal(h.t, n, h.sectorCount())
+}
+
+func TestAutoPledgeConfig(t *testing.T) {
+	h, stop := newHarness(t)
+	defer stop()
+
+	require.Error(t, h.m.StartAutoPledge(sealing.AutoPledgeConfig{Sectors: 0, Interval: time.Second}))
+	require.Error(t, h.m.StartAutoPledge(sealing.AutoPledgeConfig{Sectors: 1, Interval: 0}))
+
+	// sectors are never pledged with the balance check failing
+	cfg := sealing.AutoPledgeConfig{Sectors: 1, Interval: time.Hour, MinWorkerBalance: big.NewInt(1)}
+	require.NoError(t, h.m.StartAutoPledge(cfg))
+	require.Error(t, h.m.StartAutoPledge(cfg))
+
+	h.m.StopAutoPledge()
+	h.m.StopAutoPledge()
+	require.NoError(t, h.m.StartAutoPledge(cfg))
+	h.m.StopAutoPledge()
+}
+
+func TestAutoPledge(t *testing.T) {
+	h, stop := newHarness(t)
+	defer stop()
+
+	// keep sectors in the pipeline long enough to be counted
+	h.sm.Inject(mock.StepPreCommit1, mock.Fault{Delay: 300 * time.Millisecond})
+
+	require.NoError(t, h.m.StartAutoPledge(sealing.AutoPledgeConfig{Sectors: 2, Interval: 10 * time.Millisecond}))
+
+	// the pipeline is filled, and not overfilled on later ticks
+	h.waitSectorCount(2)
+
+	// sectors which leave the pipeline are replaced
+	h.waitState(0, sealing.Proving)
+	h.waitState(1, sealing.Proving)
+	h.waitSectorCount(4)
+
+	// nothing is pledged after stopping
+	h.m.StopAutoPledge()
+	h.waitState(2, sealing.Proving)
+	h.waitState(3, sealing.Proving)
+	h.waitSectorCount(4)
+}
+
+func TestAutoPledgeBalance(t *testing.T) {
+	h, stop := newHarness(t)
+	defer stop()
+
+	h.sm.Inject(mock.StepPreCommit1, mock.Fault{Delay: 300 * time.Millisecond})
+	h.chain.SetBalance(h.maddr, big.NewInt(10))
+
+	require.NoError(t, h.m.StartAutoPledge(sealing.AutoPledgeConfig{
+		Sectors:          1,
+		Interval:         10 * time.Millisecond,
+		MinWorkerBalance: big.NewInt(100),
+	}))
+	defer h.m.StopAutoPledge()
+
+	h.waitSectorCount(0)
+
+	// the balance is checked again on the next tick
+	h.chain.SetBalance(h.maddr, big.NewInt(100))
+	h.waitSectorCount(1)
+}
+
+func TestAutoPledgeFreeSpace(t *testing.T) {
+	h, stop := newHarness(t)
+	defer stop()
+
+	h.sm.Inject(mock.StepPreCommit1, mock.Fault{Delay: 300 * time.Millisecond})
+	h.sm.SetAvailable(testSectorSize)
+
+	require.NoError(t, h.m.StartAutoPledge(sealing.AutoPledgeConfig{
+		Sectors:      1,
+		Interval:     10 * time.Millisecond,
+		MinFreeSpace: 4 * testSectorSize,
+	}))
+	defer h.m.StopAutoPledge()
+
+	h.waitSectorCount(0)
+
+	h.sm.SetAvailable(4 * testSectorSize)
+	h.waitSectorCount(1)
+}
@@ -0,0 +1,331 @@
+package sealing
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	"golang.org/x/xerrors"
+)
+
+// BlobStorePrefix is the datastore namespace of DatastoreBlobStore, when New
+// creates it
+const BlobStorePrefix = "/blobs"
+
+// BlobStore keeps large sealing artifacts, PreCommit1 output and seal proofs,
+// out of sector records, which are rewritten on every state change. Sectors
+// only store a reference, the CID of the blob. Blobs of a sector are deleted
+// when it reaches Proving, or is aborted. A BlobStore belongs to one Sealing
+// instance, blobs its sectors don't reference can be deleted when it starts
+type BlobStore interface {
+	Put(ctx context.Context, data []byte) (cid.Cid, error)
+	Get(ctx context.Context, ref cid.Cid) ([]byte, error)
+	// Delete doesn't fail if the blob doesn't exist
+	Delete(ctx context.Context, ref cid.Cid) error
+}
+
+// BlobLister is implemented by blob stores which can list the blobs they
+// keep. Run deletes blobs no sector references from them, these are left by
+// failed deletes, and by crashes between storing a blob and recording it
+type BlobLister interface {
+	List(ctx context.Context) ([]cid.Cid, error)
+}
+
+// blobRef returns the reference of a blob
+func blobRef(data []byte) (cid.Cid, error) {
+	return cid.Prefix{
+		Version:  1,
+		Codec:    cid.Raw,
+		MhType:   0x12, // sha2-256
+		MhLength: -1,
+	}.Sum(data)
+}
+
+// checkBlob verifies that data is the blob ref refers to
+func checkBlob(ref cid.Cid, data []byte) error {
+	c, err := ref.Prefix().Sum(data)
+	if err != nil {
+		return xerrors.Errorf("hashing blob %s: %w", ref, err)
+	}
+	if !c.Equals(ref) {
+		return xerrors.Errorf("blob %s is corrupted, data hashes to %s", ref, c)
+	}
+	return nil
+}
+
+var _ BlobStore = &FSBlobStore{}
+var _ BlobLister = &FSBlobStore{}
+
+// FSBlobStore is the default BlobStore, it keeps each blob in a file named
+// by its reference
+type FSBlobStore struct {
+	dir string
+}
+
+// NewFSBlobStore creates a blob store in dir, creating the directory if it
+// doesn't exist
+func NewFSBlobStore(dir string) (*FSBlobStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, xerrors.Errorf("creating blob directory: %w", err)
+	}
+	return &FSBlobStore{dir: dir}, nil
+}
+
+func (bs *FSBlobStore) path(ref cid.Cid) string {
+	return filepath.Join(bs.dir, ref.String())
+}
+
+func (bs *FSBlobStore) Put(ctx context.Context, data []byte) (cid.Cid, error) {
+	ref, err := blobRef(data)
+	if err != nil {
+		return cid.Undef, err
+	}
+
+	if _, err := os.Stat(bs.path(ref)); err == nil {
+		return ref, nil
+	}
+
+	// write a temporary file first, so that a crash doesn't leave a partial blob
+	f, err := ioutil.TempFile(bs.dir, ".tmp-")
+	if err != nil {
+		return cid.Undef, xerrors.Errorf("creating blob file: %w", err)
+	}
+	defer os.Remove(f.Name()) // nolint: errcheck
+
+	if _, err := f.Write(data); err != nil {
+		_ = f.Close()
+		return cid.Undef, xerrors.Errorf("writing blob %s: %w", ref, err)
+	}
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		return cid.Undef, xerrors.Errorf("syncing blob %s: %w", ref, err)
+	}
+	if err := f.Close(); err != nil {
+		return cid.Undef, xerrors.Errorf("closing blob %s: %w", ref, err)
+	}
+
+	if err := os.Rename(f.Name(), bs.path(ref)); err != nil {
+		return cid.Undef, xerrors.Errorf("moving blob %s in place: %w", ref, err)
+	}
+	return ref, nil
+}
+
+func (bs *FSBlobStore) Get(ctx context.Context, ref cid.Cid) ([]byte, error) {
+	data, err := ioutil.ReadFile(bs.path(ref))
+	if err != nil {
+		return nil, xerrors.Errorf("reading blob %s: %w", ref, err)
+	}
+	if err := checkBlob(ref, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// List skips temporary files of blobs being written
+func (bs *FSBlobStore) List(ctx context.Context) ([]cid.Cid, error) {
+	files, err := ioutil.ReadDir(bs.dir)
+	if err != nil {
+		return nil, xerrors.Errorf("reading blob directory: %w", err)
+	}
+
+	var out []cid.Cid
+	for _, f := range files {
+		if f.IsDir() || strings.HasPrefix(f.Name(), ".tmp-") {
+			continue
+		}
+
+		ref, err := cid.Decode(f.Name())
+		if err != nil {
+			log.Warnf("skipping %s in blob directory: %+v", f.Name(), err)
+			continue
+		}
+		out = append(out, ref)
+	}
+	return out, nil
+}
+
+func (bs *FSBlobStore) Delete(ctx context.Context, ref cid.Cid) error {
+	if err := os.Remove(bs.path(ref)); err != nil && !os.IsNotExist(err) {
+		return xerrors.Errorf("removing blob %s: %w", ref, err)
+	}
+	return nil
+}
+
+var _ BlobStore = &DatastoreBlobStore{}
+var _ BlobLister = &DatastoreBlobStore{}
+
+// DatastoreBlobStore keeps blobs in a datastore. It's used by New when no
+// BlobStore is given, blobs are still written only once, but live in the same
+// datastore as sector records
+type DatastoreBlobStore struct {
+	ds datastore.Datastore
+}
+
+func NewDatastoreBlobStore(ds datastore.Datastore) *DatastoreBlobStore {
+	return &DatastoreBlobStore{ds: ds}
+}
+
+func (bs *DatastoreBlobStore) Put(ctx context.Context, data []byte) (cid.Cid, error) {
+	ref, err := blobRef(data)
+	if err != nil {
+		return cid.Undef, err
+	}
+
+	if err := bs.ds.Put(datastore.NewKey(ref.String()), data); err != nil {
+		return cid.Undef, xerrors.Errorf("storing blob %s: %w", ref, err)
+	}
+	return ref, nil
+}
+
+func (bs *DatastoreBlobStore) Get(ctx context.Context, ref cid.Cid) ([]byte, error) {
+	data, err := bs.ds.Get(datastore.NewKey(ref.String()))
+	if err != nil {
+		return nil, xerrors.Errorf("getting blob %s: %w", ref, err)
+	}
+	if err := checkBlob(ref, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func (bs *DatastoreBlobStore) Delete(ctx context.Context, ref cid.Cid) error {
+	if err := bs.ds.Delete(datastore.NewKey(ref.String())); err != nil && err != datastore.ErrNotFound {
+		return xerrors.Errorf("deleting blob %s: %w", ref, err)
+	}
+	return nil
+}
+
+func (bs *DatastoreBlobStore) List(ctx context.Context) ([]cid.Cid, error) {
+	res, err := bs.ds.Query(query.Query{KeysOnly: true})
+	if err != nil {
+		return nil, xerrors.Errorf("querying blobs: %w", err)
+	}
+	defer res.Close() // nolint: errcheck
+
+	var out []cid.Cid
+	for r := range res.Next() {
+		if r.Error != nil {
+			return nil, xerrors.Errorf("reading blobs: %w", r.Error)
+		}
+
+		ref, err := cid.Decode(datastore.RawKey(r.Key).BaseNamespace())
+		if err != nil {
+			return nil, xerrors.Errorf("parsing blob key %s: %w", r.Key, err)
+		}
+		out = append(out, ref)
+	}
+	return out, nil
+}
+
+// refOnlyBlobs computes references without storing blobs. DecodeSectorInfo
+// uses it to migrate records with inline blobs for reading
+type refOnlyBlobs struct{}
+
+func (refOnlyBlobs) Put(ctx context.Context, data []byte) (cid.Cid, error) {
+	return blobRef(data)
+}
+
+func (refOnlyBlobs) Get(ctx context.Context, ref cid.Cid) ([]byte, error) {
+	return nil, xerrors.Errorf("blob %s isn't stored", ref)
+}
+
+func (refOnlyBlobs) Delete(ctx context.Context, ref cid.Cid) error {
+	return nil
+}
+
+// loadBlob gets a blob of a sector, nil refs are empty blobs
+func (m *Sealing) loadBlob(ctx context.Context, ref *cid.Cid) ([]byte, error) {
+	if ref == nil {
+		return nil, nil
+	}
+	return m.blobs.Get(ctx, *ref)
+}
+
+// deleteBlobs deletes blobs a sector references. The sector doesn't need them
+// anymore, so failures are only logged, a blob which wasn't deleted takes up
+// space until sweepBlobs runs
+func (m *Sealing) deleteBlobs(ctx context.Context, sector SectorInfo) {
+	for _, ref := range sector.blobRefs() {
+		if err := m.blobs.Delete(ctx, ref); err != nil {
+			log.Errorf("deleting blob %s of sector %d: %+v", ref, sector.SectorNumber, err)
+		}
+	}
+}
+
+// sweepBlobs deletes blobs no stored sector references, if the blob store
+// can list them. It runs before sectors are restarted, when no handler can be
+// between storing a blob and recording it
+func (m *Sealing) sweepBlobs(ctx context.Context) error {
+	bl, ok := m.blobs.(BlobLister)
+	if !ok {
+		return nil
+	}
+
+	sectors, err := storedSectors(m.ds)
+	if err != nil {
+		return err
+	}
+
+	refs := map[cid.Cid]struct{}{}
+	for _, sector := range sectors {
+		for _, ref := range sector.blobRefs() {
+			refs[ref] = struct{}{}
+		}
+	}
+
+	stored, err := bl.List(ctx)
+	if err != nil {
+		return xerrors.Errorf("listing blobs: %w", err)
+	}
+
+	swept := 0
+	for _, ref := range stored {
+		if _, ok := refs[ref]; ok {
+			continue
+		}
+		if err := m.blobs.Delete(ctx, ref); err != nil {
+			return err
+		}
+		swept++
+	}
+	if swept > 0 {
+		log.Infof("deleted %d unreferenced blobs", swept)
+	}
+	return nil
+}
+
+// replaceBlob stores a blob, and deletes the blob it replaces, if any
+func (m *Sealing) replaceBlob(ctx context.Context, old *cid.Cid, data []byte) (cid.Cid, error) {
+	ref, err := m.blobs.Put(ctx, data)
+	if err != nil {
+		return cid.Undef, err
+	}
+
+	if old != nil && !old.Equals(ref) {
+		if err := m.blobs.Delete(ctx, *old); err != nil {
+			log.Warnf("deleting replaced blob %s: %+v", *old, err)
+		}
+	}
+	return ref, nil
+}
+
+// hasBlobs is true if a sector references any blobs
+func (t *SectorInfo) hasBlobs() bool {
+	return t.PreCommit1OutRef != nil || t.ProofRef != nil
+}
+
+// blobRefs returns references of blobs the sector has
+func (t *SectorInfo) blobRefs() []cid.Cid {
+	var out []cid.Cid
+	for _, ref := range []*cid.Cid{t.PreCommit1OutRef, t.ProofRef} {
+		if ref != nil {
+			out = append(out, *ref)
+		}
+	}
+	return out
+}
@@ -0,0 +1,117 @@
+package sealing
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/namespace"
+	"github.com/stretchr/testify/require"
+)
+
+// dsBlobs keeps blobs next to sector records in ds
+func dsBlobs(ds datastore.Datastore) BlobStore {
+	return NewDatastoreBlobStore(namespace.Wrap(ds, datastore.NewKey(BlobStorePrefix)))
+}
+
+func testBlobStore(t *testing.T, bs BlobStore) {
+	ctx := context.Background()
+
+	ref, err := bs.Put(ctx, []byte("pc1 output"))
+	require.NoError(t, err)
+
+	// blobs are addressed by content
+	again, err := bs.Put(ctx, []byte("pc1 output"))
+	require.NoError(t, err)
+	require.Equal(t, ref, again)
+
+	data, err := bs.Get(ctx, ref)
+	require.NoError(t, err)
+	require.Equal(t, []byte("pc1 output"), data)
+
+	listed, err := bs.(BlobLister).List(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []cid.Cid{ref}, listed)
+
+	require.NoError(t, bs.Delete(ctx, ref))
+	require.NoError(t, bs.Delete(ctx, ref))
+	_, err = bs.Get(ctx, ref)
+	require.Error(t, err)
+
+	listed, err = bs.(BlobLister).List(ctx)
+	require.NoError(t, err)
+	require.Empty(t, listed)
+}
+
+func TestFSBlobStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "blobs")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir) // nolint: errcheck
+
+	bs, err := NewFSBlobStore(filepath.Join(dir, "blobs"))
+	require.NoError(t, err)
+	testBlobStore(t, bs)
+
+	ref, err := bs.Put(context.Background(), []byte("proof"))
+	require.NoError(t, err)
+
+	// no temporary files are left behind
+	files, err := ioutil.ReadDir(filepath.Join(dir, "blobs"))
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+	require.Equal(t, ref.String(), files[0].Name())
+
+	require.NoError(t, ioutil.WriteFile(bs.path(ref), []byte("prooF"), 0644))
+	_, err = bs.Get(context.Background(), ref)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "corrupted")
+}
+
+func TestDatastoreBlobStore(t *testing.T) {
+	testBlobStore(t, NewDatastoreBlobStore(datastore.NewMapDatastore()))
+}
+
+func TestSweepBlobs(t *testing.T) {
+	ctx := context.Background()
+	ds := datastore.NewMapDatastore()
+	bs := dsBlobs(ds)
+
+	used, err := bs.Put(ctx, []byte("pc1 output"))
+	require.NoError(t, err)
+	leaked, err := bs.Put(ctx, []byte("old proof"))
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	require.NoError(t, (&SectorInfo{Version: SectorInfoVersion, State: PreCommit2, SectorNumber: 1, PreCommit1OutRef: &used}).MarshalCBOR(&buf))
+	require.NoError(t, ds.Put(sectorKey(1), buf.Bytes()))
+
+	m := &Sealing{ds: ds, blobs: bs}
+	require.NoError(t, m.sweepBlobs(ctx))
+
+	_, err = bs.Get(ctx, used)
+	require.NoError(t, err)
+	_, err = bs.Get(ctx, leaked)
+	require.Error(t, err)
+
+	// stores which can't list blobs aren't swept
+	m.blobs = refOnlyBlobs{}
+	require.NoError(t, m.sweepBlobs(ctx))
+}
+
+func TestNewDefaultBlobs(t *testing.T) {
+	ds := datastore.NewMapDatastore()
+	m := New(nil, nil, testMaddr(t), testMaddr(t), ds, nil, nil, nil, nil, nil, nil)
+
+	ref, err := m.blobs.Put(context.Background(), []byte("proof"))
+	require.NoError(t, err)
+
+	// blobs are kept next to sector records
+	data, err := dsBlobs(ds).Get(context.Background(), ref)
+	require.NoError(t, err)
+	require.Equal(t, []byte("proof"), data)
+}
@@ -177,7 +177,23 @@ func (t *SectorInfo) MarshalCBOR(w io.Writer) error {
 		_, err := w.Write(cbg.CborNull)
 		return err
 	}
-	if _, err := w.Write([]byte{179}); err != nil {
+	if _, err := w.Write([]byte{184, 24}); err != nil {
+		return err
+	}
+
+	// t.Version (uint64) (uint64)
+	if len("Version") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"Version\" was too long")
+	}
+
+	if _, err := w.Write(cbg.CborEncodeMajorType(cbg.MajTextString, uint64(len("Version")))); err != nil {
+		return err
+	}
+	if _, err := w.Write([]byte("Version")); err != nil {
+		return err
+	}
+
+	if _, err := w.Write(cbg.CborEncodeMajorType(cbg.MajUnsignedInt, uint64(t.Version))); err != nil {
 		return err
 	}
 
@@ -220,22 +236,6 @@ func (t *SectorInfo) MarshalCBOR(w io.Writer) error {
 		return err
 	}
 
-	// t.Nonce (uint64) (uint64)
-	if len("Nonce") > cbg.MaxLength {
-		return xerrors.Errorf("Value in field \"Nonce\" was too long")
-	}
-
-	if _, err := w.Write(cbg.CborEncodeMajorType(cbg.MajTextString, uint64(len("Nonce")))); err != nil {
-		return err
-	}
-	if _, err := w.Write([]byte("Nonce")); err != nil {
-		return err
-	}
-
-	if _, err := w.Write(cbg.CborEncodeMajorType(cbg.MajUnsignedInt, uint64(t.Nonce))); err != nil {
-		return err
-	}
-
 	// t.SectorType (abi.RegisteredProof) (int64)
 	if len("SectorType") > cbg.MaxLength {
 		return xerrors.Errorf("Value in field \"SectorType\" was too long")
@@ -258,6 +258,38 @@ func (t *SectorInfo) MarshalCBOR(w io.Writer) error {
 		}
 	}
 
+	// t.Priority (uint64) (uint64)
+	if len("Priority") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"Priority\" was too long")
+	}
+
+	if _, err := w.Write(cbg.CborEncodeMajorType(cbg.MajTextString, uint64(len("Priority")))); err != nil {
+		return err
+	}
+	if _, err := w.Write([]byte("Priority")); err != nil {
+		return err
+	}
+
+	if _, err := w.Write(cbg.CborEncodeMajorType(cbg.MajUnsignedInt, uint64(t.Priority))); err != nil {
+		return err
+	}
+
+	// t.Queued (bool) (bool)
+	if len("Queued") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"Queued\" was too long")
+	}
+
+	if _, err := w.Write(cbg.CborEncodeMajorType(cbg.MajTextString, uint64(len("Queued")))); err != nil {
+		return err
+	}
+	if _, err := w.Write([]byte("Queued")); err != nil {
+		return err
+	}
+
+	if err := cbg.WriteBool(w, t.Queued); err != nil {
+		return err
+	}
+
 	// t.Pieces ([]sealing.Piece) (slice)
 	if len("Pieces") > cbg.MaxLength {
 		return xerrors.Errorf("Value in field \"Pieces\" was too long")
@@ -328,29 +360,50 @@ func (t *SectorInfo) MarshalCBOR(w io.Writer) error {
 		}
 	}
 
-	// t.PreCommit1Out (storage.PreCommit1Out) (slice)
-	if len("PreCommit1Out") > cbg.MaxLength {
-		return xerrors.Errorf("Value in field \"PreCommit1Out\" was too long")
+	// t.TicketDeadline (abi.ChainEpoch) (int64)
+	if len("TicketDeadline") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"TicketDeadline\" was too long")
 	}
 
-	if _, err := w.Write(cbg.CborEncodeMajorType(cbg.MajTextString, uint64(len("PreCommit1Out")))); err != nil {
+	if _, err := w.Write(cbg.CborEncodeMajorType(cbg.MajTextString, uint64(len("TicketDeadline")))); err != nil {
 		return err
 	}
-	if _, err := w.Write([]byte("PreCommit1Out")); err != nil {
+	if _, err := w.Write([]byte("TicketDeadline")); err != nil {
 		return err
 	}
 
-	if len(t.PreCommit1Out) > cbg.ByteArrayMaxLen {
-		return xerrors.Errorf("Byte array in field t.PreCommit1Out was too long")
+	if t.TicketDeadline >= 0 {
+		if _, err := w.Write(cbg.CborEncodeMajorType(cbg.MajUnsignedInt, uint64(t.TicketDeadline))); err != nil {
+			return err
+		}
+	} else {
+		if _, err := w.Write(cbg.CborEncodeMajorType(cbg.MajNegativeInt, uint64(-t.TicketDeadline)-1)); err != nil {
+			return err
+		}
+	}
+
+	// t.PreCommit1OutRef (cid.Cid) (struct)
+	if len("PreCommit1OutRef") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"PreCommit1OutRef\" was too long")
 	}
 
-	if _, err := w.Write(cbg.CborEncodeMajorType(cbg.MajByteString, uint64(len(t.PreCommit1Out)))); err != nil {
+	if _, err := w.Write(cbg.CborEncodeMajorType(cbg.MajTextString, uint64(len("PreCommit1OutRef")))); err != nil {
 		return err
 	}
-	if _, err := w.Write(t.PreCommit1Out); err != nil {
+	if _, err := w.Write([]byte("PreCommit1OutRef")); err != nil {
 		return err
 	}
 
+	if t.PreCommit1OutRef == nil {
+		if _, err := w.Write(cbg.CborNull); err != nil {
+			return err
+		}
+	} else {
+		if err := cbg.WriteCid(w, *t.PreCommit1OutRef); err != nil {
+			return xerrors.Errorf("failed to write cid field t.PreCommit1OutRef: %w", err)
+		}
+	}
+
 	// t.CommD (cid.Cid) (struct)
 	if len("CommD") > cbg.MaxLength {
 		return xerrors.Errorf("Value in field \"CommD\" was too long")
@@ -395,27 +448,26 @@ func (t *SectorInfo) MarshalCBOR(w io.Writer) error {
 		}
 	}
 
-	// t.Proof ([]uint8) (slice)
-	if len("Proof") > cbg.MaxLength {
-		return xerrors.Errorf("Value in field \"Proof\" was too long")
+	// t.ProofRef (cid.Cid) (struct)
+	if len("ProofRef") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"ProofRef\" was too long")
 	}
 
-	if _, err := w.Write(cbg.CborEncodeMajorType(cbg.MajTextString, uint64(len("Proof")))); err != nil {
+	if _, err := w.Write(cbg.CborEncodeMajorType(cbg.MajTextString, uint64(len("ProofRef")))); err != nil {
 		return err
 	}
-	if _, err := w.Write([]byte("Proof")); err != nil {
+	if _, err := w.Write([]byte("ProofRef")); err != nil {
 		return err
 	}
 
-	if len(t.Proof) > cbg.ByteArrayMaxLen {
-		return xerrors.Errorf("Byte array in field t.Proof was too long")
-	}
-
-	if _, err := w.Write(cbg.CborEncodeMajorType(cbg.MajByteString, uint64(len(t.Proof)))); err != nil {
-		return err
-	}
-	if _, err := w.Write(t.Proof); err != nil {
-		return err
+	if t.ProofRef == nil {
+		if _, err := w.Write(cbg.CborNull); err != nil {
+			return err
+		}
+	} else {
+		if err := cbg.WriteCid(w, *t.ProofRef); err != nil {
+			return xerrors.Errorf("failed to write cid field t.ProofRef: %w", err)
+		}
 	}
 
 	// t.PreCommitMessage (cid.Cid) (struct)
@@ -545,6 +597,47 @@ func (t *SectorInfo) MarshalCBOR(w io.Writer) error {
 		}
 	}
 
+	// t.Paused (bool) (bool)
+	if len("Paused") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"Paused\" was too long")
+	}
+
+	if _, err := w.Write(cbg.CborEncodeMajorType(cbg.MajTextString, uint64(len("Paused")))); err != nil {
+		return err
+	}
+	if _, err := w.Write([]byte("Paused")); err != nil {
+		return err
+	}
+
+	if err := cbg.WriteBool(w, t.Paused); err != nil {
+		return err
+	}
+
+	// t.Overrides ([]sealing.StateOverride) (slice)
+	if len("Overrides") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"Overrides\" was too long")
+	}
+
+	if _, err := w.Write(cbg.CborEncodeMajorType(cbg.MajTextString, uint64(len("Overrides")))); err != nil {
+		return err
+	}
+	if _, err := w.Write([]byte("Overrides")); err != nil {
+		return err
+	}
+
+	if len(t.Overrides) > cbg.MaxLength {
+		return xerrors.Errorf("Slice value in field t.Overrides was too long")
+	}
+
+	if _, err := w.Write(cbg.CborEncodeMajorType(cbg.MajArray, uint64(len(t.Overrides)))); err != nil {
+		return err
+	}
+	for _, v := range t.Overrides {
+		if err := v.MarshalCBOR(w); err != nil {
+			return err
+		}
+	}
+
 	// t.LastErr (string) (string)
 	if len("LastErr") > cbg.MaxLength {
 		return xerrors.Errorf("Value in field \"LastErr\" was too long")
@@ -625,34 +718,34 @@ func (t *SectorInfo) UnmarshalCBOR(r io.Reader) error {
 		}
 
 		switch name {
-		// t.State (sealing.SectorState) (string)
-		case "State":
+		// t.Version (uint64) (uint64)
+		case "Version":
 
 			{
-				sval, err := cbg.ReadString(br)
+
+				maj, extra, err = cbg.CborReadHeader(br)
 				if err != nil {
 					return err
 				}
+				if maj != cbg.MajUnsignedInt {
+					return fmt.Errorf("wrong type for uint64 field")
+				}
+				t.Version = uint64(extra)
 
-				t.State = SectorState(sval)
 			}
-			// t.SectorNumber (abi.SectorNumber) (uint64)
-		case "SectorNumber":
+			// t.State (sealing.SectorState) (string)
+		case "State":
 
 			{
-
-				maj, extra, err = cbg.CborReadHeader(br)
+				sval, err := cbg.ReadString(br)
 				if err != nil {
 					return err
 				}
-				if maj != cbg.MajUnsignedInt {
-					return fmt.Errorf("wrong type for uint64 field")
-				}
-				t.SectorNumber = abi.SectorNumber(extra)
 
+				t.State = SectorState(sval)
 			}
-			// t.Nonce (uint64) (uint64)
-		case "Nonce":
+			// t.SectorNumber (abi.SectorNumber) (uint64)
+		case "SectorNumber":
 
 			{
 
@@ -663,7 +756,7 @@ func (t *SectorInfo) UnmarshalCBOR(r io.Reader) error {
 				if maj != cbg.MajUnsignedInt {
 					return fmt.Errorf("wrong type for uint64 field")
 				}
-				t.Nonce = uint64(extra)
+				t.SectorNumber = abi.SectorNumber(extra)
 
 			}
 			// t.SectorType (abi.RegisteredProof) (int64)
@@ -692,6 +785,39 @@ func (t *SectorInfo) UnmarshalCBOR(r io.Reader) error {
 
 				t.SectorType = abi.RegisteredProof(extraI)
 			}
+			// t.Priority (uint64) (uint64)
+		case "Priority":
+
+			{
+
+				maj, extra, err = cbg.CborReadHeader(br)
+				if err != nil {
+					return err
+				}
+				if maj != cbg.MajUnsignedInt {
+					return fmt.Errorf("wrong type for uint64 field")
+				}
+				t.Priority = uint64(extra)
+
+			}
+			// t.Queued (bool) (bool)
+		case "Queued":
+
+			maj, extra, err = cbg.CborReadHeader(br)
+			if err != nil {
+				return err
+			}
+			if maj != cbg.MajOther {
+				return fmt.Errorf("booleans must be major type 7")
+			}
+			switch extra {
+			case 20:
+				t.Queued = false
+			case 21:
+				t.Queued = true
+			default:
+				return fmt.Errorf("booleans are either major type 7, value 20 or 21 (got %d)", extra)
+			}
 			// t.Pieces ([]sealing.Piece) (slice)
 		case "Pieces":
 
@@ -764,23 +890,56 @@ func (t *SectorInfo) UnmarshalCBOR(r io.Reader) error {
 
 				t.TicketEpoch = abi.ChainEpoch(extraI)
 			}
-			// t.PreCommit1Out (storage.PreCommit1Out) (slice)
-		case "PreCommit1Out":
+			// t.TicketDeadline (abi.ChainEpoch) (int64)
+		case "TicketDeadline":
+			{
+				maj, extra, err := cbg.CborReadHeader(br)
+				var extraI int64
+				if err != nil {
+					return err
+				}
+				switch maj {
+				case cbg.MajUnsignedInt:
+					extraI = int64(extra)
+					if extraI < 0 {
+						return fmt.Errorf("int64 positive overflow")
+					}
+				case cbg.MajNegativeInt:
+					extraI = int64(extra)
+					if extraI < 0 {
+						return fmt.Errorf("int64 negative oveflow")
+					}
+					extraI = -1 - extraI
+				default:
+					return fmt.Errorf("wrong type for int64 field: %d", maj)
+				}
 
-			maj, extra, err = cbg.CborReadHeader(br)
-			if err != nil {
-				return err
+				t.TicketDeadline = abi.ChainEpoch(extraI)
 			}
+			// t.PreCommit1OutRef (cid.Cid) (struct)
+		case "PreCommit1OutRef":
+
+			{
+
+				pb, err := br.PeekByte()
+				if err != nil {
+					return err
+				}
+				if pb == cbg.CborNull[0] {
+					var nbuf [1]byte
+					if _, err := br.Read(nbuf[:]); err != nil {
+						return err
+					}
+				} else {
+
+					c, err := cbg.ReadCid(br)
+					if err != nil {
+						return xerrors.Errorf("failed to read cid field t.PreCommit1OutRef: %w", err)
+					}
+
+					t.PreCommit1OutRef = &c
+				}
 
-			if extra > cbg.ByteArrayMaxLen {
-				return fmt.Errorf("t.PreCommit1Out: byte array too large (%d)", extra)
-			}
-			if maj != cbg.MajByteString {
-				return fmt.Errorf("expected byte array")
-			}
-			t.PreCommit1Out = make([]byte, extra)
-			if _, err := io.ReadFull(br, t.PreCommit1Out); err != nil {
-				return err
 			}
 			// t.CommD (cid.Cid) (struct)
 		case "CommD":
@@ -832,23 +991,30 @@ func (t *SectorInfo) UnmarshalCBOR(r io.Reader) error {
 				}
 
 			}
-			// t.Proof ([]uint8) (slice)
-		case "Proof":
+			// t.ProofRef (cid.Cid) (struct)
+		case "ProofRef":
 
-			maj, extra, err = cbg.CborReadHeader(br)
-			if err != nil {
-				return err
-			}
+			{
+
+				pb, err := br.PeekByte()
+				if err != nil {
+					return err
+				}
+				if pb == cbg.CborNull[0] {
+					var nbuf [1]byte
+					if _, err := br.Read(nbuf[:]); err != nil {
+						return err
+					}
+				} else {
+
+					c, err := cbg.ReadCid(br)
+					if err != nil {
+						return xerrors.Errorf("failed to read cid field t.ProofRef: %w", err)
+					}
+
+					t.ProofRef = &c
+				}
 
-			if extra > cbg.ByteArrayMaxLen {
-				return fmt.Errorf("t.Proof: byte array too large (%d)", extra)
-			}
-			if maj != cbg.MajByteString {
-				return fmt.Errorf("expected byte array")
-			}
-			t.Proof = make([]byte, extra)
-			if _, err := io.ReadFull(br, t.Proof); err != nil {
-				return err
 			}
 			// t.PreCommitMessage (cid.Cid) (struct)
 		case "PreCommitMessage":
@@ -984,6 +1150,52 @@ func (t *SectorInfo) UnmarshalCBOR(r io.Reader) error {
 				}
 
 			}
+			// t.Paused (bool) (bool)
+		case "Paused":
+
+			maj, extra, err = cbg.CborReadHeader(br)
+			if err != nil {
+				return err
+			}
+			if maj != cbg.MajOther {
+				return fmt.Errorf("booleans must be major type 7")
+			}
+			switch extra {
+			case 20:
+				t.Paused = false
+			case 21:
+				t.Paused = true
+			default:
+				return fmt.Errorf("booleans are either major type 7, value 20 or 21 (got %d)", extra)
+			}
+			// t.Overrides ([]sealing.StateOverride) (slice)
+		case "Overrides":
+
+			maj, extra, err = cbg.CborReadHeader(br)
+			if err != nil {
+				return err
+			}
+
+			if extra > cbg.MaxLength {
+				return fmt.Errorf("t.Overrides: array too large (%d)", extra)
+			}
+
+			if maj != cbg.MajArray {
+				return fmt.Errorf("expected cbor array")
+			}
+			if extra > 0 {
+				t.Overrides = make([]StateOverride, extra)
+			}
+			for i := 0; i < int(extra); i++ {
+
+				var v StateOverride
+				if err := v.UnmarshalCBOR(br); err != nil {
+					return err
+				}
+
+				t.Overrides[i] = v
+			}
+
 			// t.LastErr (string) (string)
 		case "LastErr":
 
@@ -1035,7 +1247,7 @@ func (t *Log) MarshalCBOR(w io.Writer) error {
 		_, err := w.Write(cbg.CborNull)
 		return err
 	}
-	if _, err := w.Write([]byte{164}); err != nil {
+	if _, err := w.Write([]byte{171}); err != nil {
 		return err
 	}
 
@@ -1123,28 +1335,180 @@ func (t *Log) MarshalCBOR(w io.Writer) error {
 	if _, err := w.Write([]byte(t.Kind)); err != nil {
 		return err
 	}
-	return nil
-}
 
-func (t *Log) UnmarshalCBOR(r io.Reader) error {
-	br := cbg.GetPeeker(r)
+	// t.Batch (uint64) (uint64)
+	if len("Batch") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"Batch\" was too long")
+	}
 
-	maj, extra, err := cbg.CborReadHeader(br)
-	if err != nil {
+	if _, err := w.Write(cbg.CborEncodeMajorType(cbg.MajTextString, uint64(len("Batch")))); err != nil {
 		return err
 	}
-	if maj != cbg.MajMap {
-		return fmt.Errorf("cbor input should be of type map")
+	if _, err := w.Write([]byte("Batch")); err != nil {
+		return err
 	}
 
-	if extra > cbg.MaxLength {
-		return fmt.Errorf("Log: map struct too large (%d)", extra)
+	if _, err := w.Write(cbg.CborEncodeMajorType(cbg.MajUnsignedInt, uint64(t.Batch))); err != nil {
+		return err
 	}
 
-	var name string
-	n := extra
-
-	for i := uint64(0); i < n; i++ {
+	// t.Event (string) (string)
+	if len("Event") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"Event\" was too long")
+	}
+
+	if _, err := w.Write(cbg.CborEncodeMajorType(cbg.MajTextString, uint64(len("Event")))); err != nil {
+		return err
+	}
+	if _, err := w.Write([]byte("Event")); err != nil {
+		return err
+	}
+
+	if len(t.Event) > cbg.MaxLength {
+		return xerrors.Errorf("Value in field t.Event was too long")
+	}
+
+	if _, err := w.Write(cbg.CborEncodeMajorType(cbg.MajTextString, uint64(len(t.Event)))); err != nil {
+		return err
+	}
+	if _, err := w.Write([]byte(t.Event)); err != nil {
+		return err
+	}
+
+	// t.From (sealing.SectorState) (string)
+	if len("From") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"From\" was too long")
+	}
+
+	if _, err := w.Write(cbg.CborEncodeMajorType(cbg.MajTextString, uint64(len("From")))); err != nil {
+		return err
+	}
+	if _, err := w.Write([]byte("From")); err != nil {
+		return err
+	}
+
+	if len(t.From) > cbg.MaxLength {
+		return xerrors.Errorf("Value in field t.From was too long")
+	}
+
+	if _, err := w.Write(cbg.CborEncodeMajorType(cbg.MajTextString, uint64(len(t.From)))); err != nil {
+		return err
+	}
+	if _, err := w.Write([]byte(t.From)); err != nil {
+		return err
+	}
+
+	// t.To (sealing.SectorState) (string)
+	if len("To") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"To\" was too long")
+	}
+
+	if _, err := w.Write(cbg.CborEncodeMajorType(cbg.MajTextString, uint64(len("To")))); err != nil {
+		return err
+	}
+	if _, err := w.Write([]byte("To")); err != nil {
+		return err
+	}
+
+	if len(t.To) > cbg.MaxLength {
+		return xerrors.Errorf("Value in field t.To was too long")
+	}
+
+	if _, err := w.Write(cbg.CborEncodeMajorType(cbg.MajTextString, uint64(len(t.To)))); err != nil {
+		return err
+	}
+	if _, err := w.Write([]byte(t.To)); err != nil {
+		return err
+	}
+
+	// t.Error (string) (string)
+	if len("Error") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"Error\" was too long")
+	}
+
+	if _, err := w.Write(cbg.CborEncodeMajorType(cbg.MajTextString, uint64(len("Error")))); err != nil {
+		return err
+	}
+	if _, err := w.Write([]byte("Error")); err != nil {
+		return err
+	}
+
+	if len(t.Error) > cbg.MaxLength {
+		return xerrors.Errorf("Value in field t.Error was too long")
+	}
+
+	if _, err := w.Write(cbg.CborEncodeMajorType(cbg.MajTextString, uint64(len(t.Error)))); err != nil {
+		return err
+	}
+	if _, err := w.Write([]byte(t.Error)); err != nil {
+		return err
+	}
+
+	// t.MessageCid (cid.Cid) (struct)
+	if len("MessageCid") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"MessageCid\" was too long")
+	}
+
+	if _, err := w.Write(cbg.CborEncodeMajorType(cbg.MajTextString, uint64(len("MessageCid")))); err != nil {
+		return err
+	}
+	if _, err := w.Write([]byte("MessageCid")); err != nil {
+		return err
+	}
+
+	if t.MessageCid == nil {
+		if _, err := w.Write(cbg.CborNull); err != nil {
+			return err
+		}
+	} else {
+		if err := cbg.WriteCid(w, *t.MessageCid); err != nil {
+			return xerrors.Errorf("failed to write cid field t.MessageCid: %w", err)
+		}
+	}
+
+	// t.Epoch (abi.ChainEpoch) (int64)
+	if len("Epoch") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"Epoch\" was too long")
+	}
+
+	if _, err := w.Write(cbg.CborEncodeMajorType(cbg.MajTextString, uint64(len("Epoch")))); err != nil {
+		return err
+	}
+	if _, err := w.Write([]byte("Epoch")); err != nil {
+		return err
+	}
+
+	if t.Epoch >= 0 {
+		if _, err := w.Write(cbg.CborEncodeMajorType(cbg.MajUnsignedInt, uint64(t.Epoch))); err != nil {
+			return err
+		}
+	} else {
+		if _, err := w.Write(cbg.CborEncodeMajorType(cbg.MajNegativeInt, uint64(-t.Epoch)-1)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *Log) UnmarshalCBOR(r io.Reader) error {
+	br := cbg.GetPeeker(r)
+
+	maj, extra, err := cbg.CborReadHeader(br)
+	if err != nil {
+		return err
+	}
+	if maj != cbg.MajMap {
+		return fmt.Errorf("cbor input should be of type map")
+	}
+
+	if extra > cbg.MaxLength {
+		return fmt.Errorf("Log: map struct too large (%d)", extra)
+	}
+
+	var name string
+	n := extra
+
+	for i := uint64(0); i < n; i++ {
 
 		{
 			sval, err := cbg.ReadString(br)
@@ -1204,6 +1568,298 @@ func (t *Log) UnmarshalCBOR(r io.Reader) error {
 
 				t.Kind = string(sval)
 			}
+			// t.Batch (uint64) (uint64)
+		case "Batch":
+
+			{
+
+				maj, extra, err = cbg.CborReadHeader(br)
+				if err != nil {
+					return err
+				}
+				if maj != cbg.MajUnsignedInt {
+					return fmt.Errorf("wrong type for uint64 field")
+				}
+				t.Batch = uint64(extra)
+
+			}
+			// t.Event (string) (string)
+		case "Event":
+
+			{
+				sval, err := cbg.ReadString(br)
+				if err != nil {
+					return err
+				}
+
+				t.Event = string(sval)
+			}
+			// t.From (sealing.SectorState) (string)
+		case "From":
+
+			{
+				sval, err := cbg.ReadString(br)
+				if err != nil {
+					return err
+				}
+
+				t.From = SectorState(sval)
+			}
+			// t.To (sealing.SectorState) (string)
+		case "To":
+
+			{
+				sval, err := cbg.ReadString(br)
+				if err != nil {
+					return err
+				}
+
+				t.To = SectorState(sval)
+			}
+			// t.Error (string) (string)
+		case "Error":
+
+			{
+				sval, err := cbg.ReadString(br)
+				if err != nil {
+					return err
+				}
+
+				t.Error = string(sval)
+			}
+			// t.MessageCid (cid.Cid) (struct)
+		case "MessageCid":
+
+			{
+
+				pb, err := br.PeekByte()
+				if err != nil {
+					return err
+				}
+				if pb == cbg.CborNull[0] {
+					var nbuf [1]byte
+					if _, err := br.Read(nbuf[:]); err != nil {
+						return err
+					}
+				} else {
+
+					c, err := cbg.ReadCid(br)
+					if err != nil {
+						return xerrors.Errorf("failed to read cid field t.MessageCid: %w", err)
+					}
+
+					t.MessageCid = &c
+				}
+
+			}
+			// t.Epoch (abi.ChainEpoch) (int64)
+		case "Epoch":
+			{
+				maj, extra, err := cbg.CborReadHeader(br)
+				var extraI int64
+				if err != nil {
+					return err
+				}
+				switch maj {
+				case cbg.MajUnsignedInt:
+					extraI = int64(extra)
+					if extraI < 0 {
+						return fmt.Errorf("int64 positive overflow")
+					}
+				case cbg.MajNegativeInt:
+					extraI = int64(extra)
+					if extraI < 0 {
+						return fmt.Errorf("int64 negative oveflow")
+					}
+					extraI = -1 - extraI
+				default:
+					return fmt.Errorf("wrong type for int64 field: %d", maj)
+				}
+
+				t.Epoch = abi.ChainEpoch(extraI)
+			}
+
+		default:
+			return fmt.Errorf("unknown struct field %d: '%s'", i, name)
+		}
+	}
+
+	return nil
+}
+func (t *StateOverride) MarshalCBOR(w io.Writer) error {
+	if t == nil {
+		_, err := w.Write(cbg.CborNull)
+		return err
+	}
+	if _, err := w.Write([]byte{164}); err != nil {
+		return err
+	}
+
+	// t.Timestamp (uint64) (uint64)
+	if len("Timestamp") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"Timestamp\" was too long")
+	}
+
+	if _, err := w.Write(cbg.CborEncodeMajorType(cbg.MajTextString, uint64(len("Timestamp")))); err != nil {
+		return err
+	}
+	if _, err := w.Write([]byte("Timestamp")); err != nil {
+		return err
+	}
+
+	if _, err := w.Write(cbg.CborEncodeMajorType(cbg.MajUnsignedInt, uint64(t.Timestamp))); err != nil {
+		return err
+	}
+
+	// t.From (sealing.SectorState) (string)
+	if len("From") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"From\" was too long")
+	}
+
+	if _, err := w.Write(cbg.CborEncodeMajorType(cbg.MajTextString, uint64(len("From")))); err != nil {
+		return err
+	}
+	if _, err := w.Write([]byte("From")); err != nil {
+		return err
+	}
+
+	if len(t.From) > cbg.MaxLength {
+		return xerrors.Errorf("Value in field t.From was too long")
+	}
+
+	if _, err := w.Write(cbg.CborEncodeMajorType(cbg.MajTextString, uint64(len(t.From)))); err != nil {
+		return err
+	}
+	if _, err := w.Write([]byte(t.From)); err != nil {
+		return err
+	}
+
+	// t.To (sealing.SectorState) (string)
+	if len("To") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"To\" was too long")
+	}
+
+	if _, err := w.Write(cbg.CborEncodeMajorType(cbg.MajTextString, uint64(len("To")))); err != nil {
+		return err
+	}
+	if _, err := w.Write([]byte("To")); err != nil {
+		return err
+	}
+
+	if len(t.To) > cbg.MaxLength {
+		return xerrors.Errorf("Value in field t.To was too long")
+	}
+
+	if _, err := w.Write(cbg.CborEncodeMajorType(cbg.MajTextString, uint64(len(t.To)))); err != nil {
+		return err
+	}
+	if _, err := w.Write([]byte(t.To)); err != nil {
+		return err
+	}
+
+	// t.Reason (string) (string)
+	if len("Reason") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"Reason\" was too long")
+	}
+
+	if _, err := w.Write(cbg.CborEncodeMajorType(cbg.MajTextString, uint64(len("Reason")))); err != nil {
+		return err
+	}
+	if _, err := w.Write([]byte("Reason")); err != nil {
+		return err
+	}
+
+	if len(t.Reason) > cbg.MaxLength {
+		return xerrors.Errorf("Value in field t.Reason was too long")
+	}
+
+	if _, err := w.Write(cbg.CborEncodeMajorType(cbg.MajTextString, uint64(len(t.Reason)))); err != nil {
+		return err
+	}
+	if _, err := w.Write([]byte(t.Reason)); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (t *StateOverride) UnmarshalCBOR(r io.Reader) error {
+	br := cbg.GetPeeker(r)
+
+	maj, extra, err := cbg.CborReadHeader(br)
+	if err != nil {
+		return err
+	}
+	if maj != cbg.MajMap {
+		return fmt.Errorf("cbor input should be of type map")
+	}
+
+	if extra > cbg.MaxLength {
+		return fmt.Errorf("StateOverride: map struct too large (%d)", extra)
+	}
+
+	var name string
+	n := extra
+
+	for i := uint64(0); i < n; i++ {
+
+		{
+			sval, err := cbg.ReadString(br)
+			if err != nil {
+				return err
+			}
+
+			name = string(sval)
+		}
+
+		switch name {
+		// t.Timestamp (uint64) (uint64)
+		case "Timestamp":
+
+			{
+
+				maj, extra, err = cbg.CborReadHeader(br)
+				if err != nil {
+					return err
+				}
+				if maj != cbg.MajUnsignedInt {
+					return fmt.Errorf("wrong type for uint64 field")
+				}
+				t.Timestamp = uint64(extra)
+
+			}
+			// t.From (sealing.SectorState) (string)
+		case "From":
+
+			{
+				sval, err := cbg.ReadString(br)
+				if err != nil {
+					return err
+				}
+
+				t.From = SectorState(sval)
+			}
+			// t.To (sealing.SectorState) (string)
+		case "To":
+
+			{
+				sval, err := cbg.ReadString(br)
+				if err != nil {
+					return err
+				}
+
+				t.To = SectorState(sval)
+			}
+			// t.Reason (string) (string)
+		case "Reason":
+
+			{
+				sval, err := cbg.ReadString(br)
+				if err != nil {
+					return err
+				}
+
+				t.Reason = string(sval)
+			}
 
 		default:
 			return fmt.Errorf("unknown struct field %d: '%s'", i, name)
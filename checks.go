@@ -155,3 +155,55 @@ func (m *Sealing) checkCommit(ctx context.Context, si SectorInfo, proof []byte)
 
 	return nil
 }
+
+// checkForceState validates that the sector can be forced into the given
+//  state, that is the state has a planner, and fields its handler needs are set
+func checkForceState(si SectorInfo, state SectorState) error {
+	if _, ok := fsmPlanners[state]; !ok || state == UndefinedSectorState {
+		return xerrors.Errorf("sector state %q can't be forced, it has no planner", state)
+	}
+
+	switch state {
+	case Aborting, AbortFailed, Aborted:
+		// aborting releases deals and removes sector files, see AbortSector
+		return xerrors.Errorf("sector state %s can't be forced, use AbortSector", state)
+	}
+
+	var missing []string
+	need := func(set bool, field string) {
+		if !set {
+			missing = append(missing, field)
+		}
+	}
+
+	switch state {
+	case PreCommit1, SealFailed:
+		need(len(si.Pieces) > 0, "Pieces")
+	case PreCommit2:
+		need(si.PreCommit1OutRef != nil, "PreCommit1OutRef")
+		need(len(si.TicketValue) > 0, "TicketValue")
+	case PreCommitting, PreCommitFailed:
+		need(si.CommD != nil, "CommD")
+		need(si.CommR != nil, "CommR")
+	case WaitSeed:
+		need(si.CommD != nil, "CommD")
+		need(si.CommR != nil, "CommR")
+		need(si.PreCommitMessage != nil, "PreCommitMessage")
+	case Committing, ComputeProofFailed, CommitFailed:
+		need(si.CommD != nil, "CommD")
+		need(si.CommR != nil, "CommR")
+		need(si.SeedEpoch != 0, "SeedEpoch")
+	case CommitWait:
+		need(si.CommitMessage != nil, "CommitMessage")
+	case FinalizeSector, Proving, Faulty:
+		need(si.CommR != nil, "CommR")
+	case FaultReported:
+		need(si.FaultReportMsg != nil, "FaultReportMsg")
+	}
+
+	if len(missing) > 0 {
+		return xerrors.Errorf("sector %d is missing fields required in state %s: %v", si.SectorNumber, state, missing)
+	}
+
+	return nil
+}
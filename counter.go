@@ -0,0 +1,200 @@
+package sealing
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/specs-actors/actors/abi"
+)
+
+const (
+	SectorCounterKey    = "/sector-counter/next"
+	BurnedSectorsPrefix = "/sector-counter/burned"
+)
+
+// SectorNumberBurner is implemented by sector counters which keep track of
+// numbers that were allocated, but will never be used
+type SectorNumberBurner interface {
+	Burn(num abi.SectorNumber, reason string) error
+}
+
+var _ SectorIDCounter = &StoredCounter{}
+var _ SectorNumberBurner = &StoredCounter{}
+
+// counterCommitRetries is how many times Reserve retries a transaction which
+// failed to commit, e.g. because another writer updated the counter
+const counterCommitRetries = 20
+
+// counterCommitBackoff is the longest wait before the first retry, it grows
+// with each attempt
+const counterCommitBackoff = time.Millisecond
+
+// StoredCounter is a SectorIDCounter backed by the datastore Sealing keeps
+// sector state in. It never hands out a number which already has state in
+// the SectorStorePrefix namespace.
+//
+// With a datastore.TxnDatastore, numbers are reserved in a transaction, so
+// several processes can share the counter. Other datastores must have a
+// single writer: Reserve is only atomic between callers in this process
+// sharing the same StoredCounter
+type StoredCounter struct {
+	ds datastore.Datastore
+
+	lk sync.Mutex
+}
+
+func NewStoredCounter(ds datastore.Datastore) *StoredCounter {
+	return &StoredCounter{ds: ds}
+}
+
+func (sc *StoredCounter) Next() (abi.SectorNumber, error) {
+	return sc.Reserve(1)
+}
+
+// Reserve allocates n consecutive sector numbers, returning the first one
+func (sc *StoredCounter) Reserve(n uint64) (abi.SectorNumber, error) {
+	if n == 0 {
+		return 0, xerrors.Errorf("can't reserve 0 sector numbers")
+	}
+
+	sc.lk.Lock()
+	defer sc.lk.Unlock()
+
+	tds, ok := sc.ds.(datastore.TxnDatastore)
+	if !ok {
+		return reserveNumbers(sc.ds, n)
+	}
+
+	for attempt := 1; ; attempt++ {
+		txn, err := tds.NewTransaction(false)
+		if err != nil {
+			return 0, xerrors.Errorf("starting sector counter transaction: %w", err)
+		}
+
+		first, err := reserveNumbers(txn, n)
+		if err != nil {
+			txn.Discard()
+			return 0, err
+		}
+
+		err = txn.Commit()
+		if err == nil {
+			return first, nil
+		}
+		if attempt >= counterCommitRetries {
+			return 0, xerrors.Errorf("committing sector counter (%d attempts): %w", attempt, err)
+		}
+		log.Debugf("committing sector counter failed, retrying: %+v", err)
+
+		// back off a random amount so that conflicting writers don't retry in
+		// lockstep
+		time.Sleep(time.Duration(rand.Int63n(int64(attempt) * int64(counterCommitBackoff))))
+	}
+}
+
+// counterRW is the datastore, or the transaction in it, the counter is
+// updated in
+type counterRW interface {
+	datastore.Read
+	datastore.Write
+}
+
+func reserveNumbers(rw counterRW, n uint64) (abi.SectorNumber, error) {
+	first, err := loadCounter(rw)
+	if err != nil {
+		return 0, err
+	}
+
+	// move the range past numbers which already have sector state
+	for num := first; num < first+abi.SectorNumber(n); num++ {
+		used, err := rw.Has(sectorKey(num))
+		if err != nil {
+			return 0, xerrors.Errorf("checking if sector %d exists: %w", num, err)
+		}
+		if used {
+			log.Warnf("sector counter at %d, but sector %d exists, skipping", first, num)
+			first = num + 1
+		}
+	}
+
+	if err := storeCounter(rw, first+abi.SectorNumber(n)); err != nil {
+		return 0, err
+	}
+
+	return first, nil
+}
+
+// Burn records a sector number which was allocated, but will never be used,
+// e.g. because initializing the sector failed
+func (sc *StoredCounter) Burn(num abi.SectorNumber, reason string) error {
+	return sc.ds.Put(burnedKey(num), []byte(reason))
+}
+
+// Burned returns burned sector numbers with the reason they were burned
+func (sc *StoredCounter) Burned() (map[abi.SectorNumber]string, error) {
+	res, err := sc.ds.Query(query.Query{Prefix: BurnedSectorsPrefix})
+	if err != nil {
+		return nil, xerrors.Errorf("querying burned sectors: %w", err)
+	}
+	defer res.Close() // nolint:errcheck
+
+	out := map[abi.SectorNumber]string{}
+	for r := range res.Next() {
+		if r.Error != nil {
+			return nil, xerrors.Errorf("iterating burned sectors: %w", r.Error)
+		}
+
+		num, err := strconv.ParseUint(datastore.NewKey(r.Key).BaseNamespace(), 10, 64)
+		if err != nil {
+			return nil, xerrors.Errorf("parsing burned sector key %s: %w", r.Key, err)
+		}
+
+		out[abi.SectorNumber(num)] = string(r.Value)
+	}
+
+	return out, nil
+}
+
+func loadCounter(rw counterRW) (abi.SectorNumber, error) {
+	b, err := rw.Get(datastore.NewKey(SectorCounterKey))
+	switch err {
+	case nil:
+	case datastore.ErrNotFound:
+		return 0, nil
+	default:
+		return 0, xerrors.Errorf("getting sector counter: %w", err)
+	}
+
+	next, n := binary.Uvarint(b)
+	if n <= 0 {
+		return 0, xerrors.Errorf("could not parse sector counter value")
+	}
+
+	return abi.SectorNumber(next), nil
+}
+
+func storeCounter(rw counterRW, next abi.SectorNumber) error {
+	buf := make([]byte, binary.MaxVarintLen64)
+	size := binary.PutUvarint(buf, uint64(next))
+
+	if err := rw.Put(datastore.NewKey(SectorCounterKey), buf[:size]); err != nil {
+		return xerrors.Errorf("storing sector counter: %w", err)
+	}
+	return nil
+}
+
+func sectorKey(num abi.SectorNumber) datastore.Key {
+	return datastore.NewKey(SectorStorePrefix).ChildString(fmt.Sprint(uint64(num)))
+}
+
+func burnedKey(num abi.SectorNumber) datastore.Key {
+	return datastore.NewKey(BurnedSectorsPrefix).ChildString(fmt.Sprint(uint64(num)))
+}
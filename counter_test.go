@@ -0,0 +1,118 @@
+package sealing
+
+import (
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/ipfs/go-datastore"
+	badger "github.com/ipfs/go-ds-badger2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/specs-actors/actors/abi"
+)
+
+func TestStoredCounter(t *testing.T) {
+	ds := datastore.NewMapDatastore()
+	sc := NewStoredCounter(ds)
+
+	n, err := sc.Next()
+	require.NoError(t, err)
+	require.Equal(t, abi.SectorNumber(0), n)
+
+	first, err := sc.Reserve(3)
+	require.NoError(t, err)
+	require.Equal(t, abi.SectorNumber(1), first)
+
+	// state is persisted
+	n, err = NewStoredCounter(ds).Next()
+	require.NoError(t, err)
+	require.Equal(t, abi.SectorNumber(4), n)
+
+	_, err = sc.Reserve(0)
+	require.Error(t, err)
+}
+
+func TestStoredCounterSkipsExisting(t *testing.T) {
+	ds := datastore.NewMapDatastore()
+	sc := NewStoredCounter(ds)
+
+	require.NoError(t, ds.Put(sectorKey(0), []byte{}))
+	require.NoError(t, ds.Put(sectorKey(3), []byte{}))
+
+	n, err := sc.Next()
+	require.NoError(t, err)
+	require.Equal(t, abi.SectorNumber(1), n)
+
+	first, err := sc.Reserve(2)
+	require.NoError(t, err)
+	require.Equal(t, abi.SectorNumber(4), first)
+
+	n, err = sc.Next()
+	require.NoError(t, err)
+	require.Equal(t, abi.SectorNumber(6), n)
+}
+
+func TestStoredCounterTxn(t *testing.T) {
+	dir, err := ioutil.TempDir("", "counter")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir) // nolint: errcheck
+
+	ds, err := badger.NewDatastore(dir, &badger.DefaultOptions)
+	require.NoError(t, err)
+	defer ds.Close() // nolint: errcheck
+
+	// separate counters don't share a lock, like counters in separate
+	// processes, only the transaction keeps them from handing out the same
+	// numbers
+	const counters, reserves = 4, 25
+
+	var lk sync.Mutex
+	seen := map[abi.SectorNumber]struct{}{}
+
+	var wg sync.WaitGroup
+	for i := 0; i < counters; i++ {
+		wg.Add(1)
+		go func(sc *StoredCounter) {
+			defer wg.Done()
+			for j := 0; j < reserves; j++ {
+				first, err := sc.Reserve(2)
+				if !assert.NoError(t, err) {
+					return
+				}
+
+				lk.Lock()
+				for _, num := range []abi.SectorNumber{first, first + 1} {
+					if _, dup := seen[num]; dup {
+						t.Errorf("sector number %d reserved twice", num)
+					}
+					seen[num] = struct{}{}
+				}
+				lk.Unlock()
+			}
+		}(NewStoredCounter(ds))
+	}
+	wg.Wait()
+
+	require.Len(t, seen, 2*counters*reserves)
+
+	n, err := NewStoredCounter(ds).Next()
+	require.NoError(t, err)
+	require.Equal(t, abi.SectorNumber(2*counters*reserves), n)
+}
+
+func TestStoredCounterBurn(t *testing.T) {
+	sc := NewStoredCounter(datastore.NewMapDatastore())
+
+	require.NoError(t, sc.Burn(2, "no space"))
+	require.NoError(t, sc.Burn(10, "disk error"))
+
+	burned, err := sc.Burned()
+	require.NoError(t, err)
+	require.Equal(t, map[abi.SectorNumber]string{
+		2:  "no space",
+		10: "disk error",
+	}, burned)
+}
@@ -0,0 +1,417 @@
+package sealing
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	cbg "github.com/whyrusleeping/cbor-gen"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/specs-actors/actors/abi"
+)
+
+// SectorExportVersion is the version of the format written by ExportSectors.
+// Version 1 exports have no blobs, they can still be read
+const SectorExportVersion = 2
+
+// An export starts with sectorExportMagic, followed by the format version and
+// the sector count as CBOR unsigned ints. Each sector is a CBOR byte string
+// holding the SectorInfo encoding. Sectors are followed by the blob count, and
+// the blobs they reference, each a CBOR byte string. The export ends with a
+// CBOR byte string holding the sha256 of everything before it
+var sectorExportMagic = []byte("storage-fsm sectors\n")
+
+// maxExportRecordSize limits the size of a single exported sector or blob
+const maxExportRecordSize = 32 << 20
+
+// SectorExport is the content of a sector export
+type SectorExport struct {
+	Sectors []SectorInfo
+	// Blobs holds the blobs sectors reference, by reference
+	Blobs map[cid.Cid][]byte
+}
+
+// NewSectorExport collects the blobs sectors reference from bs
+func NewSectorExport(ctx context.Context, sectors []SectorInfo, bs BlobStore) (*SectorExport, error) {
+	exp := &SectorExport{Sectors: sectors, Blobs: map[cid.Cid][]byte{}}
+	for _, si := range sectors {
+		for _, ref := range si.blobRefs() {
+			data, err := bs.Get(ctx, ref)
+			if err != nil {
+				return nil, xerrors.Errorf("getting blob of sector %d: %w", si.SectorNumber, err)
+			}
+			exp.Blobs[ref] = data
+		}
+	}
+	return exp, nil
+}
+
+// ImportOptions control ImportSectors
+type ImportOptions struct {
+	// DryRun only reads and checks the export, nothing is written
+	DryRun bool
+}
+
+// ImportConflict is a sector in an export whose number is already used
+type ImportConflict struct {
+	SectorNumber abi.SectorNumber
+	Existing     SectorState
+	Imported     SectorState
+}
+
+func (c ImportConflict) String() string {
+	return fmt.Sprintf("sector %d: existing in state %s, imported in state %s", c.SectorNumber, c.Existing, c.Imported)
+}
+
+// ImportResult lists sectors read from an export. Sectors are only imported
+// if there are no conflicts, and DryRun isn't set
+type ImportResult struct {
+	Sectors   []abi.SectorNumber
+	Conflicts []ImportConflict
+	Imported  bool
+}
+
+// WriteSectorExport writes sectors and their blobs in the export format
+func WriteSectorExport(w io.Writer, exp *SectorExport) error {
+	h := sha256.New()
+	hw := io.MultiWriter(w, h)
+
+	if _, err := hw.Write(sectorExportMagic); err != nil {
+		return xerrors.Errorf("writing header: %w", err)
+	}
+	if err := cbg.CborWriteHeader(hw, cbg.MajUnsignedInt, SectorExportVersion); err != nil {
+		return xerrors.Errorf("writing header: %w", err)
+	}
+	if err := cbg.CborWriteHeader(hw, cbg.MajUnsignedInt, uint64(len(exp.Sectors))); err != nil {
+		return xerrors.Errorf("writing header: %w", err)
+	}
+
+	var buf bytes.Buffer
+	for _, si := range exp.Sectors {
+		buf.Reset()
+		if err := si.MarshalCBOR(&buf); err != nil {
+			return xerrors.Errorf("encoding sector %d: %w", si.SectorNumber, err)
+		}
+		if err := writeByteString(hw, buf.Bytes()); err != nil {
+			return xerrors.Errorf("writing sector %d: %w", si.SectorNumber, err)
+		}
+	}
+
+	refs := make([]cid.Cid, 0, len(exp.Blobs))
+	for ref := range exp.Blobs {
+		refs = append(refs, ref)
+	}
+	sort.Slice(refs, func(i, j int) bool { return refs[i].KeyString() < refs[j].KeyString() })
+
+	if err := cbg.CborWriteHeader(hw, cbg.MajUnsignedInt, uint64(len(refs))); err != nil {
+		return xerrors.Errorf("writing blob count: %w", err)
+	}
+	for _, ref := range refs {
+		if err := checkBlob(ref, exp.Blobs[ref]); err != nil {
+			return err
+		}
+		if err := writeByteString(hw, exp.Blobs[ref]); err != nil {
+			return xerrors.Errorf("writing blob %s: %w", ref, err)
+		}
+	}
+
+	if err := writeByteString(w, h.Sum(nil)); err != nil {
+		return xerrors.Errorf("writing checksum: %w", err)
+	}
+	return nil
+}
+
+// ReadSectorExport reads sectors and blobs written by WriteSectorExport, and
+// checks the export checksum
+func ReadSectorExport(r io.Reader) (*SectorExport, error) {
+	br := bufio.NewReader(r)
+	h := sha256.New()
+	hr := io.TeeReader(br, h)
+
+	magic := make([]byte, len(sectorExportMagic))
+	if _, err := io.ReadFull(hr, magic); err != nil {
+		return nil, xerrors.Errorf("reading header: %w", err)
+	}
+	if !bytes.Equal(magic, sectorExportMagic) {
+		return nil, xerrors.New("not a sector export")
+	}
+
+	version, err := readUint(hr)
+	if err != nil {
+		return nil, xerrors.Errorf("reading version: %w", err)
+	}
+	if version < 1 || version > SectorExportVersion {
+		return nil, xerrors.Errorf("unsupported sector export version %d, expected at most %d", version, SectorExportVersion)
+	}
+
+	count, err := readUint(hr)
+	if err != nil {
+		return nil, xerrors.Errorf("reading sector count: %w", err)
+	}
+
+	exp := &SectorExport{Blobs: map[cid.Cid][]byte{}}
+	for i := uint64(0); i < count; i++ {
+		rec, err := cbg.ReadByteArray(hr, maxExportRecordSize)
+		if err != nil {
+			return nil, xerrors.Errorf("reading sector %d of %d: %w", i, count, err)
+		}
+
+		si, err := DecodeSectorInfo(rec)
+		if err != nil {
+			return nil, xerrors.Errorf("decoding sector %d of %d: %w", i, count, err)
+		}
+		exp.Sectors = append(exp.Sectors, si)
+	}
+
+	if version >= 2 {
+		blobs, err := readUint(hr)
+		if err != nil {
+			return nil, xerrors.Errorf("reading blob count: %w", err)
+		}
+
+		for i := uint64(0); i < blobs; i++ {
+			data, err := cbg.ReadByteArray(hr, maxExportRecordSize)
+			if err != nil {
+				return nil, xerrors.Errorf("reading blob %d of %d: %w", i, blobs, err)
+			}
+
+			ref, err := blobRef(data)
+			if err != nil {
+				return nil, err
+			}
+			exp.Blobs[ref] = data
+		}
+	}
+
+	sum, err := cbg.ReadByteArray(br, sha256.Size)
+	if err != nil {
+		return nil, xerrors.Errorf("reading checksum: %w", err)
+	}
+	if !bytes.Equal(sum, h.Sum(nil)) {
+		return nil, xerrors.New("sector export checksum mismatch")
+	}
+
+	if _, err := br.ReadByte(); err != io.EOF {
+		return nil, xerrors.New("unexpected data after sector export checksum")
+	}
+
+	return exp, nil
+}
+
+func writeByteString(w io.Writer, b []byte) error {
+	if err := cbg.CborWriteHeader(w, cbg.MajByteString, uint64(len(b))); err != nil {
+		return err
+	}
+	_, err := w.Write(b)
+	return err
+}
+
+func readUint(r io.Reader) (uint64, error) {
+	maj, v, err := cbg.CborReadHeader(r)
+	if err != nil {
+		return 0, err
+	}
+	if maj != cbg.MajUnsignedInt {
+		return 0, xerrors.Errorf("expected unsigned int, got major type %d", maj)
+	}
+	return v, nil
+}
+
+// ExportSectors writes all sectors stored in ds, and their blobs in bs, to w.
+// ds and bs are the datastore and blob store passed to New, and sealing using
+// them should be stopped
+func ExportSectors(ds datastore.Datastore, bs BlobStore, w io.Writer) error {
+	sectors, err := storedSectors(ds)
+	if err != nil {
+		return err
+	}
+
+	exp, err := NewSectorExport(context.TODO(), sectors, bs)
+	if err != nil {
+		return err
+	}
+	return WriteSectorExport(w, exp)
+}
+
+// ImportSectors stores sectors read from r in ds, and their blobs in bs. ds
+// and bs are the datastore and blob store passed to New, and sealing using
+// them should be stopped; imported sectors are restarted by Run. Nothing is
+// stored if any sector number is already used
+func ImportSectors(ds datastore.Datastore, bs BlobStore, r io.Reader, opts ImportOptions) (*ImportResult, error) {
+	existing, err := storedSectors(ds)
+	if err != nil {
+		return nil, err
+	}
+
+	return importSectors(context.TODO(), r, opts, existing, bs, func(si SectorInfo) error {
+		var buf bytes.Buffer
+		if err := si.MarshalCBOR(&buf); err != nil {
+			return err
+		}
+		return ds.Put(sectorKey(si.SectorNumber), buf.Bytes())
+	})
+}
+
+// exportAttempts bounds how often ExportSectors reads sectors again, when
+// blobs they reference were deleted while it was collecting them
+const exportAttempts = 5
+
+var exportRetryWait = 50 * time.Millisecond
+
+// ExportSectors writes all sectors tracked by sealing, and their blobs, to w
+func (m *Sealing) ExportSectors(w io.Writer) error {
+	for attempt := 1; ; attempt++ {
+		sectors, err := m.ListSectors()
+		if err != nil {
+			return xerrors.Errorf("listing sectors: %w", err)
+		}
+
+		sort.Slice(sectors, func(i, j int) bool { return sectors[i].SectorNumber < sectors[j].SectorNumber })
+
+		exp, err := NewSectorExport(context.TODO(), sectors, m.blobs)
+		if err == nil {
+			return WriteSectorExport(w, exp)
+		}
+		if attempt == exportAttempts {
+			return err
+		}
+
+		// handlers delete blobs a sector no longer needs before its record
+		// drops the references, read the sectors again once it's updated
+		log.Debugf("exporting sectors, attempt %d: %+v", attempt, err)
+		time.Sleep(exportRetryWait)
+	}
+}
+
+// ImportSectors starts tracking sectors read from r, and restarts them, so it
+// should be called after Run; before that, import into the datastore with
+// ImportSectors. Nothing is imported if any sector number is already used
+func (m *Sealing) ImportSectors(ctx context.Context, r io.Reader, opts ImportOptions) (*ImportResult, error) {
+	existing, err := m.ListSectors()
+	if err != nil {
+		return nil, xerrors.Errorf("listing sectors: %w", err)
+	}
+
+	res, err := importSectors(ctx, r, opts, existing, m.blobs, func(si SectorInfo) error {
+		return m.sectors.Begin(uint64(si.SectorNumber), &si)
+	})
+	if err != nil || !res.Imported {
+		return res, err
+	}
+
+	for _, num := range res.Sectors {
+		if err := ctx.Err(); err != nil {
+			return res, xerrors.Errorf("restarting imported sectors: %w", err)
+		}
+		if err := m.sectors.Send(uint64(num), SectorRestart{}); err != nil {
+			return res, xerrors.Errorf("restarting imported sector %d: %w", num, err)
+		}
+	}
+
+	return res, nil
+}
+
+func importSectors(ctx context.Context, r io.Reader, opts ImportOptions, existing []SectorInfo, bs BlobStore, put func(SectorInfo) error) (*ImportResult, error) {
+	exp, err := ReadSectorExport(r)
+	if err != nil {
+		return nil, xerrors.Errorf("reading sector export: %w", err)
+	}
+	sectors := exp.Sectors
+
+	states := map[abi.SectorNumber]SectorState{}
+	for _, si := range existing {
+		states[si.SectorNumber] = si.State
+	}
+
+	res := &ImportResult{}
+	seen := map[abi.SectorNumber]struct{}{}
+	for _, si := range sectors {
+		if _, ok := seen[si.SectorNumber]; ok {
+			return nil, xerrors.Errorf("sector %d is in the export more than once", si.SectorNumber)
+		}
+		seen[si.SectorNumber] = struct{}{}
+
+		// blobs missing from version 1 exports can already be in the store
+		for _, ref := range si.blobRefs() {
+			if _, ok := exp.Blobs[ref]; ok {
+				continue
+			}
+			if _, err := bs.Get(ctx, ref); err != nil {
+				return nil, xerrors.Errorf("blob %s of sector %d isn't in the export or the blob store: %w", ref, si.SectorNumber, err)
+			}
+		}
+
+		res.Sectors = append(res.Sectors, si.SectorNumber)
+		if st, ok := states[si.SectorNumber]; ok {
+			res.Conflicts = append(res.Conflicts, ImportConflict{
+				SectorNumber: si.SectorNumber,
+				Existing:     st,
+				Imported:     si.State,
+			})
+		}
+	}
+
+	if opts.DryRun {
+		return res, nil
+	}
+	if len(res.Conflicts) > 0 {
+		return res, xerrors.Errorf("%d imported sectors conflict with existing sectors", len(res.Conflicts))
+	}
+
+	// blobs go first, sectors must not reference blobs which aren't stored
+	for _, si := range sectors {
+		for _, ref := range si.blobRefs() {
+			data, ok := exp.Blobs[ref]
+			if !ok {
+				continue
+			}
+			if _, err := bs.Put(ctx, data); err != nil {
+				return res, xerrors.Errorf("importing blob %s of sector %d: %w", ref, si.SectorNumber, err)
+			}
+		}
+	}
+
+	for _, si := range sectors {
+		if err := put(si); err != nil {
+			return res, xerrors.Errorf("importing sector %d: %w", si.SectorNumber, err)
+		}
+	}
+	res.Imported = true
+
+	return res, nil
+}
+
+// storedSectors reads sectors from the datastore passed to New, ordered by
+// sector number
+func storedSectors(ds datastore.Datastore) ([]SectorInfo, error) {
+	res, err := ds.Query(query.Query{Prefix: SectorStorePrefix})
+	if err != nil {
+		return nil, xerrors.Errorf("querying sectors: %w", err)
+	}
+	defer res.Close() // nolint: errcheck
+
+	var out []SectorInfo
+	for r := range res.Next() {
+		if r.Error != nil {
+			return nil, xerrors.Errorf("reading sectors: %w", r.Error)
+		}
+
+		si, err := DecodeSectorInfo(r.Value)
+		if err != nil {
+			return nil, xerrors.Errorf("decoding sector %s: %w", r.Key, err)
+		}
+		out = append(out, si)
+	}
+
+	sort.Slice(out, func(i, j int) bool { return out[i].SectorNumber < out[j].SectorNumber })
+	return out, nil
+}
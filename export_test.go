@@ -0,0 +1,225 @@
+package sealing
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"io"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/stretchr/testify/require"
+	cbg "github.com/whyrusleeping/cbor-gen"
+
+	"github.com/filecoin-project/specs-actors/actors/abi"
+	"github.com/filecoin-project/specs-actors/actors/builtin"
+)
+
+var exportTestPC1Out, exportTestProof = []byte("pc1 output"), []byte("proof")
+
+func exportTestSectors() []SectorInfo {
+	deal := abi.DealID(3)
+	return []SectorInfo{
+		{
+			Version:          SectorInfoVersion,
+			State:            Proving,
+			SectorNumber:     1,
+			Pieces:           []Piece{{DealID: &deal, Size: 1016, CommP: builtin.AccountActorCodeID}},
+			TicketValue:      abi.SealRandomness{1, 2, 3},
+			TicketEpoch:      10,
+			PreCommit1OutRef: mustBlobRef(exportTestPC1Out),
+			ProofRef:         mustBlobRef(exportTestProof),
+			SeedValue:        abi.InteractiveSealRandomness{7},
+			Log:              []Log{{Timestamp: 1, Event: "SectorStart", To: Packing}},
+		},
+		{
+			Version:      SectorInfoVersion,
+			State:        WaitSeed,
+			SectorNumber: 2,
+
+			// decoded empty byte fields aren't nil
+			TicketValue: abi.SealRandomness{},
+			SeedValue:   abi.InteractiveSealRandomness{},
+		},
+	}
+}
+
+func exportTestExport() *SectorExport {
+	return &SectorExport{
+		Sectors: exportTestSectors(),
+		Blobs: map[cid.Cid][]byte{
+			*mustBlobRef(exportTestPC1Out): exportTestPC1Out,
+			*mustBlobRef(exportTestProof):  exportTestProof,
+		},
+	}
+}
+
+// exportTestStore stores exportTestSectors and their blobs
+func exportTestStore(t *testing.T) (datastore.Datastore, BlobStore) {
+	ds := datastore.NewMapDatastore()
+	for _, si := range exportTestSectors() {
+		var buf bytes.Buffer
+		require.NoError(t, si.MarshalCBOR(&buf))
+		require.NoError(t, ds.Put(sectorKey(si.SectorNumber), buf.Bytes()))
+	}
+
+	bs := dsBlobs(ds)
+	for _, data := range exportTestExport().Blobs {
+		_, err := bs.Put(context.Background(), data)
+		require.NoError(t, err)
+	}
+	return ds, bs
+}
+
+// writeV1Export writes sectors in the version 1 format, without blobs
+func writeV1Export(t *testing.T, w io.Writer, sectors []SectorInfo) {
+	h := sha256.New()
+	hw := io.MultiWriter(w, h)
+
+	_, err := hw.Write(sectorExportMagic)
+	require.NoError(t, err)
+	require.NoError(t, cbg.CborWriteHeader(hw, cbg.MajUnsignedInt, 1))
+	require.NoError(t, cbg.CborWriteHeader(hw, cbg.MajUnsignedInt, uint64(len(sectors))))
+	for _, si := range sectors {
+		var buf bytes.Buffer
+		require.NoError(t, si.MarshalCBOR(&buf))
+		require.NoError(t, writeByteString(hw, buf.Bytes()))
+	}
+	require.NoError(t, writeByteString(w, h.Sum(nil)))
+}
+
+func TestSectorExportRoundtrip(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, WriteSectorExport(&buf, exportTestExport()))
+
+	exp, err := ReadSectorExport(bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+	require.Equal(t, exportTestExport(), exp)
+
+	empty := bytes.Buffer{}
+	require.NoError(t, WriteSectorExport(&empty, &SectorExport{}))
+	exp, err = ReadSectorExport(&empty)
+	require.NoError(t, err)
+	require.Empty(t, exp.Sectors)
+	require.Empty(t, exp.Blobs)
+
+	// blobs must match their reference
+	corrupt := exportTestExport()
+	corrupt.Blobs[*mustBlobRef(exportTestProof)] = []byte("not the proof")
+	require.Error(t, WriteSectorExport(&buf, corrupt))
+}
+
+func TestNewSectorExport(t *testing.T) {
+	ds, bs := exportTestStore(t)
+	sectors, err := storedSectors(ds)
+	require.NoError(t, err)
+
+	exp, err := NewSectorExport(context.Background(), sectors, bs)
+	require.NoError(t, err)
+	require.Equal(t, exportTestExport(), exp)
+
+	require.NoError(t, bs.Delete(context.Background(), *mustBlobRef(exportTestProof)))
+	_, err = NewSectorExport(context.Background(), sectors, bs)
+	require.Error(t, err)
+}
+
+func TestSectorExportCorrupted(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, WriteSectorExport(&buf, exportTestExport()))
+	b := buf.Bytes()
+
+	flipped := append([]byte{}, b...)
+	flipped[len(flipped)-40] ^= 0xff
+	_, err := ReadSectorExport(bytes.NewReader(flipped))
+	require.Error(t, err)
+
+	_, err = ReadSectorExport(bytes.NewReader(b[:len(b)-1]))
+	require.Error(t, err)
+
+	_, err = ReadSectorExport(bytes.NewReader(append(append([]byte{}, b...), 0)))
+	require.Error(t, err)
+
+	versioned := append([]byte{}, b...)
+	versioned[len(sectorExportMagic)] = 3 // CBOR uint 3
+	_, err = ReadSectorExport(bytes.NewReader(versioned))
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "unsupported sector export version 3")
+
+	_, err = ReadSectorExport(bytes.NewReader([]byte("not an export at all")))
+	require.Error(t, err)
+}
+
+func TestImportSectorsDatastore(t *testing.T) {
+	src, srcBlobs := exportTestStore(t)
+
+	var export bytes.Buffer
+	require.NoError(t, ExportSectors(src, srcBlobs, &export))
+
+	dst := datastore.NewMapDatastore()
+	dstBlobs := dsBlobs(dst)
+	res, err := ImportSectors(dst, dstBlobs, bytes.NewReader(export.Bytes()), ImportOptions{DryRun: true})
+	require.NoError(t, err)
+	require.Equal(t, []abi.SectorNumber{1, 2}, res.Sectors)
+	require.Empty(t, res.Conflicts)
+	require.False(t, res.Imported)
+
+	stored, err := storedSectors(dst)
+	require.NoError(t, err)
+	require.Empty(t, stored)
+	_, err = dstBlobs.Get(context.Background(), *mustBlobRef(exportTestProof))
+	require.Error(t, err)
+
+	res, err = ImportSectors(dst, dstBlobs, bytes.NewReader(export.Bytes()), ImportOptions{})
+	require.NoError(t, err)
+	require.True(t, res.Imported)
+
+	stored, err = storedSectors(dst)
+	require.NoError(t, err)
+	require.Equal(t, exportTestSectors(), stored)
+	for ref, data := range exportTestExport().Blobs {
+		imported, err := dstBlobs.Get(context.Background(), ref)
+		require.NoError(t, err)
+		require.Equal(t, data, imported)
+	}
+
+	res, err = ImportSectors(dst, dstBlobs, bytes.NewReader(export.Bytes()), ImportOptions{DryRun: true})
+	require.NoError(t, err)
+	require.Equal(t, []ImportConflict{
+		{SectorNumber: 1, Existing: Proving, Imported: Proving},
+		{SectorNumber: 2, Existing: WaitSeed, Imported: WaitSeed},
+	}, res.Conflicts)
+
+	_, err = ImportSectors(dst, dstBlobs, bytes.NewReader(export.Bytes()), ImportOptions{})
+	require.Error(t, err)
+
+	// imported sector numbers aren't allocated again
+	sc := NewStoredCounter(dst)
+	next, err := sc.Next()
+	require.NoError(t, err)
+	require.Equal(t, abi.SectorNumber(0), next)
+	next, err = sc.Next()
+	require.NoError(t, err)
+	require.Equal(t, abi.SectorNumber(3), next)
+}
+
+func TestImportSectorsV1(t *testing.T) {
+	var export bytes.Buffer
+	writeV1Export(t, &export, exportTestSectors())
+
+	exp, err := ReadSectorExport(bytes.NewReader(export.Bytes()))
+	require.NoError(t, err)
+	require.Equal(t, exportTestSectors(), exp.Sectors)
+	require.Empty(t, exp.Blobs)
+
+	// the blobs sector 1 references aren't anywhere
+	dst := datastore.NewMapDatastore()
+	_, err = ImportSectors(dst, dsBlobs(dst), bytes.NewReader(export.Bytes()), ImportOptions{DryRun: true})
+	require.Error(t, err)
+
+	// they are in the blob store already
+	_, bs := exportTestStore(t)
+	res, err := ImportSectors(dst, bs, bytes.NewReader(export.Bytes()), ImportOptions{})
+	require.NoError(t, err)
+	require.True(t, res.Imported)
+}
@@ -6,6 +6,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"reflect"
+	"sort"
 	"time"
 
 	"golang.org/x/xerrors"
@@ -15,13 +16,27 @@ import (
 )
 
 func (m *Sealing) Plan(events []statemachine.Event, user interface{}) (interface{}, uint64, error) {
-	next, err := m.plan(events, user.(*SectorInfo))
+	state := user.(*SectorInfo)
+	next, err := m.plan(events, state)
 	if err != nil || next == nil {
 		return nil, uint64(len(events)), err
 	}
 
+	// Plan runs before the new state is stored, so the handler can be
+	// cancelled by anyone who sees the new state, even if it didn't start yet
+	cctx, release := m.trackHandler(state.SectorNumber)
+
 	return func(ctx statemachine.Context, si SectorInfo) error {
-		err := next(ctx, si)
+		defer release()
+
+		hctx, done, ok := m.handlerContext(cctx, &ctx)
+		if !ok {
+			log.Warnf("sealing stopping, not running handler for sector %d (%s)", si.SectorNumber, si.State)
+			return nil
+		}
+		defer done()
+
+		err := next(hctx, si)
 		if err != nil {
 			log.Errorf("unhandled sector error (%d): %+v", si.SectorNumber, err)
 			return nil
@@ -31,16 +46,21 @@ func (m *Sealing) Plan(events []statemachine.Event, user interface{}) (interface
 	}, uint64(len(events)), nil // TODO: This processed event count is not very correct
 }
 
-var fsmPlanners = map[SectorState]func(events []statemachine.Event, state *SectorInfo) error{
+var fsmPlanners = map[SectorState]planner{
 	UndefinedSectorState: planOne(on(SectorStart{}, Packing)),
 	Packing:              planOne(on(SectorPacked{}, PreCommit1)),
 	PreCommit1: planOne(
+		on(SectorQueued{}, PreCommit1),
+		on(SectorStageStarted{}, PreCommit1),
 		on(SectorPreCommit1{}, PreCommit2),
 		on(SectorSealPreCommitFailed{}, SealFailed),
 		on(SectorPackingFailed{}, PackingFailed),
 	),
 	PreCommit2: planOne(
+		on(SectorQueued{}, PreCommit2),
+		on(SectorStageStarted{}, PreCommit2),
 		on(SectorPreCommit2{}, PreCommitting),
+		on(SectorTicketExpiring{}, PreCommit1),
 		on(SectorSealPreCommitFailed{}, SealFailed),
 		on(SectorPackingFailed{}, PackingFailed),
 	),
@@ -53,7 +73,15 @@ var fsmPlanners = map[SectorState]func(events []statemachine.Event, state *Secto
 		on(SectorSeedReady{}, Committing),
 		on(SectorChainPreCommitFailed{}, PreCommitFailed),
 	),
-	Committing: planCommitting,
+	Committing: planCustom(planCommitting,
+		on(SectorQueued{}, Committing),
+		on(SectorStageStarted{}, Committing),
+		on(SectorCommitted{}, CommitWait),
+		on(SectorSeedReady{}, Committing),
+		on(SectorComputeProofFailed{}, ComputeProofFailed),
+		on(SectorSealPreCommitFailed{}, CommitFailed),
+		on(SectorCommitFailed{}, CommitFailed),
+	),
 	CommitWait: planOne(
 		on(SectorProving{}, FinalizeSector),
 		on(SectorCommitFailed{}, CommitFailed),
@@ -64,6 +92,7 @@ var fsmPlanners = map[SectorState]func(events []statemachine.Event, state *Secto
 	),
 
 	Proving: planOne(
+		on(SectorBlobsRemoved{}, Proving),
 		on(SectorFaultReported{}, FaultReported),
 		on(SectorFaulty{}, Faulty),
 	),
@@ -71,6 +100,7 @@ var fsmPlanners = map[SectorState]func(events []statemachine.Event, state *Secto
 	SealFailed: planOne(
 		on(SectorRetrySeal{}, PreCommit1),
 	),
+	PackingFailed: planOne(), // TODO: re-pack the sector
 	PreCommitFailed: planOne(
 		on(SectorRetryPreCommit{}, PreCommitting),
 		on(SectorRetryWaitSeed{}, WaitSeed),
@@ -89,13 +119,40 @@ var fsmPlanners = map[SectorState]func(events []statemachine.Event, state *Secto
 	Faulty: planOne(
 		on(SectorFaultReported{}, FaultReported),
 	),
-	FaultedFinal: final,
+	FaultReported: planOne(
+		on(SectorFaultedFinal{}, FaultedFinal),
+	),
+	FaultedFinal: planCustom(final),
+
+	// recovered sectors which can't be proven end up here, they still get
+	// global events, like SectorRestart on every Run
+	FailedUnrecoverable: planOne(),
+
+	Aborting: planCustom(planAborting,
+		on(SectorAborted{}, Aborted),
+		on(SectorAbortFailed{}, AbortFailed),
+	),
+	AbortFailed: planCustom(planAborting,
+		on(SectorRetryAbort{}, Aborting),
+	),
+	Aborted: planCustom(planAborting),
 }
 
-func (m *Sealing) plan(events []statemachine.Event, state *SectorInfo) (func(statemachine.Context, SectorInfo) error, error) {
+func (m *Sealing) plan(events []statemachine.Event, state *SectorInfo) (func(Context, SectorInfo) error, error) {
 	/////
 	// First process all events
 
+	// records are written in the current schema, old ones are migrated in Run
+	state.Version = SectorInfoVersion
+
+	wasPaused := state.Paused
+
+	logStart := len(state.Log)
+	batch := uint64(1)
+	if logStart > 0 {
+		batch = state.Log[logStart-1].Batch + 1
+	}
+
 	for _, event := range events {
 		e, err := json.Marshal(event)
 		if err != nil {
@@ -107,114 +164,162 @@ func (m *Sealing) plan(events []statemachine.Event, state *SectorInfo) (func(sta
 			Timestamp: uint64(time.Now().Unix()),
 			Message:   string(e),
 			Kind:      fmt.Sprintf("event;%T", event.User),
+
+			Batch: batch,
+			Event: eventName(event.User),
+			From:  state.State,
 		}
 
 		if err, iserr := event.User.(xerrors.Formatter); iserr {
 			l.Trace = fmt.Sprintf("%+v", err)
+
+			// error events return the error they wrap, the printer is unused
+			if werr := err.FormatError(nil); werr != nil {
+				l.Error = werr.Error()
+			}
+		}
+
+		if le, ok := event.User.(loggable); ok {
+			le.logData(&l)
 		}
 
 		state.Log = append(state.Log, l)
 	}
 
-	p := fsmPlanners[state.State]
-	if p == nil {
+	p, ok := fsmPlanners[state.State]
+	if !ok {
 		return nil, xerrors.Errorf("planner for state %s not found", state.State)
 	}
 
-	if err := p(events, state); err != nil {
+	if err := p.plan(events, state); err != nil {
 		return nil, xerrors.Errorf("running planner for state %s failed: %w", state.State, err)
 	}
 
+	for i := logStart; i < len(state.Log); i++ {
+		state.Log[i].To = state.State
+	}
+
+	// slots the sector holds or waits for in other stages are released, the
+	// handlers which would use them won't run
+	if m.stages != nil {
+		if state.Paused {
+			m.stages.leave(state.SectorNumber, UndefinedSectorState)
+		} else {
+			m.stages.leave(state.SectorNumber, state.State)
+		}
+	}
+
+	if state.Paused {
+		log.Infof("sector %d is paused in state %s", state.SectorNumber, state.State)
+		return nil, nil
+	}
+
+	if metadataOnly(events, wasPaused) {
+		// the last handler is done, or left a callback which moves the sector
+		// on, running it again would repeat its work
+		return nil, nil
+	}
+
 	/////
 	// Now decide what to do next
 
-	/*
-
-		*   Empty
-		|   |
-		|   v
-		*<- Packing <- incoming
-		|   |
-		|   v
-		*<- PreCommit1 <--> SealFailed
-		|   |                 ^^^
-		|   v                 |||
-		*<- PreCommit2 -------/||
-		|   |                  ||
-		|   v          /-------/|
-		*   PreCommitting <-----+---> PreCommitFailed
-		|   |                   |     ^
-		|   v                   |     |
-		*<- WaitSeed -----------+-----/
-		|   |||  ^              |
-		|   |||  \--------*-----/
-		|   |||           |
-		|   vvv      v----+----> ComputeProofFailed
-		*<- Committing    |
-		|   |        ^--> CommitFailed
-		|   v             ^
-		*<- CommitWait ---/
-		|   |
-		|   v
-		*<- Proving
-		|
-		v
-		FailedUnrecoverable
-
-		UndefinedSectorState <- ¯\_(ツ)_/¯
-		    |                     ^
-		    *---------------------/
-
-	*/
-
-	switch state.State {
+	// The state graph is generated from fsmPlanners, see WriteStateGraphDOT,
+	// or run `go run ./gen/main.go graph [dot|mermaid]`
+
+	handler, _ := m.handlerFor(state.State)
+	if handler == nil {
+		switch state.State {
+		case Aborted:
+			log.Infof("sector %d was aborted", state.SectorNumber)
+		case PackingFailed:
+			log.Errorf("sector %d failed packing", state.SectorNumber)
+		case UndefinedSectorState:
+			log.Error("sector update with undefined state!")
+		case FailedUnrecoverable:
+			log.Errorf("sector %d failed unrecoverably", state.SectorNumber)
+		default:
+			log.Errorf("unexpected sector update state: %s", state.State)
+		}
+		return nil, nil
+	}
+
+	return handler, nil
+}
+
+// metadataOnly is true if events don't change what a sector does next, they
+// only set its priority, or resume a sector which wasn't paused
+func metadataOnly(events []statemachine.Event, wasPaused bool) bool {
+	for _, event := range events {
+		switch event.User.(type) {
+		case SectorSetPriority:
+		case SectorResume:
+			if wasPaused {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// handlerFor returns the handler for a state. It returns false for states it
+// doesn't know about, states which don't need a handler return a nil handler
+func (m *Sealing) handlerFor(state SectorState) (func(Context, SectorInfo) error, bool) {
+	switch state {
 	// Happy path
 	case Packing:
-		return m.handlePacking, nil
+		return m.handlePacking, true
 	case PreCommit1:
-		return m.handlePreCommit1, nil
+		return m.handlePreCommit1, true
 	case PreCommit2:
-		return m.handlePreCommit2, nil
+		return m.handlePreCommit2, true
 	case PreCommitting:
-		return m.handlePreCommitting, nil
+		return m.handlePreCommitting, true
 	case WaitSeed:
-		return m.handleWaitSeed, nil
+		return m.handleWaitSeed, true
 	case Committing:
-		return m.handleCommitting, nil
+		return m.handleCommitting, true
 	case CommitWait:
-		return m.handleCommitWait, nil
+		return m.handleCommitWait, true
 	case FinalizeSector:
-		return m.handleFinalizeSector, nil
+		return m.handleFinalizeSector, true
 	case Proving:
-		// TODO: track sector health / expiration
-		log.Infof("Proving sector %d", state.SectorNumber)
+		return m.handleProving, true
 
 	// Handled failure modes
 	case SealFailed:
-		return m.handleSealFailed, nil
+		return m.handleSealFailed, true
 	case PreCommitFailed:
-		return m.handlePreCommitFailed, nil
+		return m.handlePreCommitFailed, true
 	case ComputeProofFailed:
-		return m.handleComputeProofFailed, nil
+		return m.handleComputeProofFailed, true
 	case CommitFailed:
-		return m.handleCommitFailed, nil
+		return m.handleCommitFailed, true
+	case PackingFailed:
+		return nil, true // TODO: re-pack the sector
 
-		// Faults
+	// Faults
 	case Faulty:
-		return m.handleFaulty, nil
+		return m.handleFaulty, true
 	case FaultReported:
-		return m.handleFaultReported, nil
+		return m.handleFaultReported, true
+	case FaultedFinal:
+		return nil, true
+
+	case Aborting:
+		return m.handleAborting, true
+	case AbortFailed:
+		return m.handleAbortFailed, true
+	case Aborted:
+		return nil, true
 
 	// Fatal errors
-	case UndefinedSectorState:
-		log.Error("sector update with undefined state!")
-	case FailedUnrecoverable:
-		log.Errorf("sector %d failed unrecoverably", state.SectorNumber)
-	default:
-		log.Errorf("unexpected sector update state: %d", state.State)
+	case UndefinedSectorState, FailedUnrecoverable:
+		return nil, true
 	}
 
-	return nil, nil
+	return nil, false
 }
 
 func planCommitting(events []statemachine.Event, state *SectorInfo) error {
@@ -224,6 +329,8 @@ func planCommitting(events []statemachine.Event, state *SectorInfo) error {
 			if e.applyGlobal(state) {
 				return nil
 			}
+		case SectorQueued, SectorStageStarted:
+			e.(mutator).apply(state)
 		case SectorCommitted: // the normal case
 			e.apply(state)
 			state.State = CommitWait
@@ -249,70 +356,309 @@ func planCommitting(events []statemachine.Event, state *SectorInfo) error {
 	return nil
 }
 
+// planAborting ignores everything except global events and events of the
+// abort handlers, there may still be events in flight from the handler which
+// was cancelled
+func planAborting(events []statemachine.Event, state *SectorInfo) error {
+	for _, event := range events {
+		switch e := event.User.(type) {
+		case globalMutator:
+			if e.applyGlobal(state) {
+				return nil
+			}
+		case SectorAborted:
+			if state.State == Aborting {
+				e.apply(state)
+				state.State = Aborted
+				return nil
+			}
+		case SectorAbortFailed:
+			if state.State == Aborting {
+				log.Warnf("sector %d: aborting failed: %+v", state.SectorNumber, e.error)
+				state.State = AbortFailed
+				return nil
+			}
+		case SectorRetryAbort:
+			if state.State == AbortFailed {
+				state.State = Aborting
+				return nil
+			}
+		default:
+			log.Warnf("sector %d: ignoring event %T in state %s", state.SectorNumber, event.User, state.State)
+		}
+	}
+	return nil
+}
+
+type handlerCtx struct {
+	ctx  context.Context
+	life context.Context
+	sm   *statemachine.Context
+}
+
+func (c *handlerCtx) Context() context.Context {
+	return c.ctx
+}
+
+func (c *handlerCtx) Send(evt interface{}) error {
+	if c.life.Err() != nil {
+		// the handler was cancelled by Stop, drop the event so the state is
+		// re-entered on restart
+		log.Warnf("sealing stopped, dropping event %T", evt)
+		return nil
+	}
+	return c.sm.Send(evt)
+}
+
+// trackHandler creates the context of the next handler of a sector, which
+// gets cancelled when the sector is aborted, retried, or sealing is stopped
+func (m *Sealing) trackHandler(sid abi.SectorNumber) (context.Context, func()) {
+	cctx, cancel := context.WithCancel(m.lifeCtx)
+
+	m.handlerLk.Lock()
+	m.handlers[sid] = cancel
+	m.handlerLk.Unlock()
+
+	return cctx, func() {
+		m.handlerLk.Lock()
+		delete(m.handlers, sid)
+		m.handlerLk.Unlock()
+
+		cancel()
+	}
+}
+
+// handlerContext wraps the statemachine context with the handler context
+// from trackHandler. It returns false if sealing is stopping
+func (m *Sealing) handlerContext(cctx context.Context, ctx *statemachine.Context) (Context, func(), bool) {
+	workDone, ok := m.startWork()
+	if !ok {
+		return nil, nil, false
+	}
+
+	return &handlerCtx{ctx: cctx, life: m.lifeCtx, sm: ctx}, workDone, true
+}
+
 func (m *Sealing) restartSectors(ctx context.Context) error {
 	trackedSectors, err := m.ListSectors()
 	if err != nil {
 		log.Errorf("loading sector list: %+v", err)
 	}
 
+	// restart high priority sectors first, so they get to the stage queues first
+	sort.SliceStable(trackedSectors, func(i, j int) bool {
+		return trackedSectors[i].Priority > trackedSectors[j].Priority
+	})
+
 	for _, sector := range trackedSectors {
 		if err := m.sectors.Send(uint64(sector.SectorNumber), SectorRestart{}); err != nil {
 			log.Errorf("restarting sector %d: %+v", sector.SectorNumber, err)
 		}
 	}
 
-	// TODO: Grab on-chain sector set and diff with trackedSectors
+	// sectors on chain which aren't tracked can be recovered with RecoverSectors
+
+	return nil
+}
+
+// ForceSectorState moves the sector to the given state, after checking that
+// the sector has the data the target state needs. The change and the reason
+// for it are recorded in SectorInfo.Overrides
+func (m *Sealing) ForceSectorState(ctx context.Context, id abi.SectorNumber, state SectorState, reason string) error {
+	if reason == "" {
+		return xerrors.Errorf("a reason for forcing sector state is required")
+	}
+
+	si, err := m.GetSectorInfo(id)
+	if err != nil {
+		return xerrors.Errorf("getting sector info: %w", err)
+	}
+
+	if err := checkForceState(si, state); err != nil {
+		return xerrors.Errorf("can't force sector %d into state %s: %w", id, state, err)
+	}
+
+	return m.sectors.Send(uint64(id), SectorForceState{State: state, Reason: reason})
+}
+
+// SetSectorPriority changes sealing priority of a sector. Sectors with higher
+// priority go first in stage queues, and are restarted first
+func (m *Sealing) SetSectorPriority(ctx context.Context, id abi.SectorNumber, priority uint64) error {
+	// Send would start a machine with an empty record for an unknown sector
+	if _, err := m.GetSectorInfo(id); err != nil {
+		return xerrors.Errorf("getting sector info: %w", err)
+	}
+
+	if err := m.sectors.Send(uint64(id), SectorSetPriority{Priority: priority}); err != nil {
+		return err
+	}
 
+	m.stages.setPriority(id, priority)
 	return nil
 }
 
-func (m *Sealing) ForceSectorState(ctx context.Context, id abi.SectorNumber, state SectorState) error {
-	return m.sectors.Send(id, SectorForceState{state})
+// AbortSector stops sealing a sector which wasn't pre-committed yet. The
+// running handler is cancelled, deals are handed back through DealReleaseFn
+// and sector files are removed, the sector manager has to implement
+// SectorRemover. If either fails, the sector is retried from AbortFailed
+func (m *Sealing) AbortSector(ctx context.Context, id abi.SectorNumber, reason string) error {
+	if _, ok := m.sealer.(SectorRemover); !ok {
+		return xerrors.New("sector manager can't remove sectors, see WithSectorStore")
+	}
+
+	si, err := m.GetSectorInfo(id)
+	if err != nil {
+		return xerrors.Errorf("getting sector info: %w", err)
+	}
+
+	if !canAbort(&si) {
+		return xerrors.Errorf("sector %d can't be aborted in state %s", id, si.State)
+	}
+
+	m.handlerLk.Lock()
+	if cancel, ok := m.handlers[id]; ok {
+		cancel()
+	}
+	m.handlerLk.Unlock()
+
+	return m.sectors.Send(uint64(id), SectorAbort{Reason: reason})
+}
+
+func canAbort(si *SectorInfo) bool {
+	if si.PreCommitMessage != nil {
+		return false
+	}
+
+	_, ok := abortableStates[si.State]
+	return ok
+}
+
+// PauseSector stops running handlers for the sector. The sector keeps its
+// state, and stays paused across restarts until ResumeSector is called. The
+// handler which is running when the sector is paused finishes, and its
+// result is applied
+func (m *Sealing) PauseSector(ctx context.Context, id abi.SectorNumber) error {
+	if _, err := m.GetSectorInfo(id); err != nil {
+		return xerrors.Errorf("getting sector info: %w", err)
+	}
+
+	return m.sectors.Send(uint64(id), SectorPause{})
+}
+
+// ResumeSector runs the handler for the current state of a paused sector
+func (m *Sealing) ResumeSector(ctx context.Context, id abi.SectorNumber) error {
+	si, err := m.GetSectorInfo(id)
+	if err != nil {
+		return xerrors.Errorf("getting sector info: %w", err)
+	}
+
+	if !si.Paused {
+		return xerrors.Errorf("sector %d isn't paused", id)
+	}
+
+	return m.sectors.Send(uint64(id), SectorResume{})
+}
+
+// RetrySector re-runs the handler for the current state of a failed sector,
+// without waiting for the cooldown. Only sectors in states which retry after
+// a cooldown can be retried, other failed states are final, or need a
+// different fix, see ForceSectorState
+func (m *Sealing) RetrySector(ctx context.Context, id abi.SectorNumber) error {
+	si, err := m.GetSectorInfo(id)
+	if err != nil {
+		return xerrors.Errorf("getting sector info: %w", err)
+	}
+
+	if si.Paused {
+		return xerrors.Errorf("sector %d is paused", id)
+	}
+
+	if _, ok := cooldownStates[si.State]; !ok {
+		return xerrors.Errorf("sector %d is in state %s, only sectors in failed states which retry can be retried", id, si.State)
+	}
+
+	// the handler may be waiting for the cooldown, which would hold the retry
+	// event back until it's over, interrupt it
+	m.handlerLk.Lock()
+	if cancel, ok := m.handlers[id]; ok {
+		cancel()
+	}
+	m.handlerLk.Unlock()
+
+	return m.sectors.Send(uint64(id), SectorRetry{})
+}
+
+func eventName(evt interface{}) string {
+	return reflect.TypeOf(evt).Name()
+}
+
+// transition is a state change caused by an event
+type transition struct {
+	evt  mutator
+	next SectorState
+}
+
+// planner processes events in a state. transitions lists state changes the
+// planner can make, they're used to validate the state graph
+type planner struct {
+	plan        func(events []statemachine.Event, state *SectorInfo) error
+	transitions []transition
+}
+
+// planCustom declares a planner with custom event handling
+func planCustom(plan func(events []statemachine.Event, state *SectorInfo) error, ts ...transition) planner {
+	return planner{plan: plan, transitions: ts}
 }
 
 func final(events []statemachine.Event, state *SectorInfo) error {
 	return xerrors.Errorf("didn't expect any events in state %s, got %+v", state.State, events)
 }
 
-func on(mut mutator, next SectorState) func() (mutator, SectorState) {
-	return func() (mutator, SectorState) {
-		return mut, next
-	}
+func on(mut mutator, next SectorState) transition {
+	return transition{evt: mut, next: next}
 }
 
-func planOne(ts ...func() (mut mutator, next SectorState)) func(events []statemachine.Event, state *SectorInfo) error {
-	return func(events []statemachine.Event, state *SectorInfo) error {
-		if len(events) != 1 {
-			for _, event := range events {
-				if gm, ok := event.User.(globalMutator); ok {
-					gm.applyGlobal(state)
+func planOne(ts ...transition) planner {
+	return planCustom(func(events []statemachine.Event, state *SectorInfo) error {
+		// global events apply in order, events after one which interrupts
+		// processing are dropped. At most one other event can be planned
+		planned := false
+		for _, event := range events {
+			if gm, ok := event.User.(globalMutator); ok {
+				if gm.applyGlobal(state) {
 					return nil
 				}
+				continue
 			}
-			return xerrors.Errorf("planner for state %s only has a plan for a single event only, got %+v", state.State, events)
-		}
-
-		if gm, ok := events[0].User.(globalMutator); ok {
-			gm.applyGlobal(state)
-			return nil
-		}
-
-		for _, t := range ts {
-			mut, next := t()
 
-			if reflect.TypeOf(events[0].User) != reflect.TypeOf(mut) {
-				continue
+			if planned {
+				return xerrors.Errorf("planner for state %s only has a plan for a single event only, got %+v", state.State, events)
 			}
+			planned = true
 
-			if err, iserr := events[0].User.(error); iserr {
-				log.Warnf("sector %d got error event %T: %+v", state.SectorNumber, events[0].User, err)
+			if err := planTransition(ts, event, state); err != nil {
+				return err
 			}
+		}
 
-			events[0].User.(mutator).apply(state)
-			state.State = next
-			return nil
+		return nil
+	}, ts...)
+}
+
+func planTransition(ts []transition, event statemachine.Event, state *SectorInfo) error {
+	for _, t := range ts {
+		if reflect.TypeOf(event.User) != reflect.TypeOf(t.evt) {
+			continue
 		}
 
-		return xerrors.Errorf("planner for state %s received unexpected event %T (%+v)", state.State, events[0].User, events[0])
+		if err, iserr := event.User.(error); iserr {
+			log.Warnf("sector %d got error event %T: %+v", state.SectorNumber, event.User, err)
+		}
+
+		event.User.(mutator).apply(state)
+		state.State = t.next
+		return nil
 	}
+
+	return xerrors.Errorf("planner for state %s received unexpected event %T (%+v)", state.State, event.User, event)
 }
@@ -1,8 +1,9 @@
 package sealing
 
 import (
+	"time"
+
 	"github.com/filecoin-project/specs-actors/actors/abi"
-	"github.com/filecoin-project/specs-storage/storage"
 	"github.com/ipfs/go-cid"
 	"golang.org/x/xerrors"
 )
@@ -11,6 +12,12 @@ type mutator interface {
 	apply(state *SectorInfo)
 }
 
+// loggable is an event which carries data worth recording in the structured
+// sector log
+type loggable interface {
+	logData(l *Log)
+}
+
 // globalMutator is an event which can apply in every state
 type globalMutator interface {
 	// applyGlobal applies the event to the state. If if returns true,
@@ -37,26 +44,101 @@ func (evt SectorFatalError) applyGlobal(state *SectorInfo) bool {
 }
 
 type SectorForceState struct {
-	State SectorState
+	State  SectorState
+	Reason string
 }
 
 func (evt SectorForceState) applyGlobal(state *SectorInfo) bool {
+	state.Overrides = append(state.Overrides, StateOverride{
+		Timestamp: uint64(time.Now().Unix()),
+		From:      state.State,
+		To:        evt.State,
+		Reason:    evt.Reason,
+	})
 	state.State = evt.State
+	state.Queued = false
 	return true
 }
 
+// SectorPause, SectorResume and SectorSetPriority don't interrupt event
+// processing, events of the handler which ran before them still apply
+
+type SectorPause struct{}
+
+func (evt SectorPause) applyGlobal(state *SectorInfo) bool {
+	// paused sectors leave stage queues
+	state.Paused = true
+	state.Queued = false
+	return false
+}
+
+type SectorResume struct{}
+
+func (evt SectorResume) applyGlobal(state *SectorInfo) bool {
+	state.Paused = false
+	return false
+}
+
+type SectorSetPriority struct {
+	Priority uint64
+}
+
+func (evt SectorSetPriority) applyGlobal(state *SectorInfo) bool {
+	state.Priority = evt.Priority
+	return false
+}
+
+type SectorAbort struct {
+	Reason string
+}
+
+func (evt SectorAbort) applyGlobal(state *SectorInfo) bool {
+	if !canAbort(state) {
+		log.Errorf("sector %d can't be aborted in state %s", state.SectorNumber, state.State)
+		return true
+	}
+
+	log.Warnf("aborting sector %d: %s", state.SectorNumber, evt.Reason)
+	state.State = Aborting
+	state.Queued = false
+	return true
+}
+
+// SectorRetry re-runs the handler for the current state, skipping the
+// failed state cooldown
+type SectorRetry struct{}
+
+func (evt SectorRetry) applyGlobal(*SectorInfo) bool { return true }
+
 // Normal path
 
+// SectorQueued is sent when a sector has to wait for a slot in a limited
+// stage, see SetStageLimits
+type SectorQueued struct{}
+
+func (evt SectorQueued) apply(state *SectorInfo) {
+	state.Queued = true
+}
+
+// SectorStageStarted is sent when a queued sector got its stage slot
+type SectorStageStarted struct{}
+
+func (evt SectorStageStarted) apply(state *SectorInfo) {
+	state.Queued = false
+}
+
 type SectorStart struct {
 	ID         abi.SectorNumber
 	SectorType abi.RegisteredProof
 	Pieces     []Piece
+	Priority   uint64
 }
 
 func (evt SectorStart) apply(state *SectorInfo) {
 	state.SectorNumber = evt.ID
 	state.Pieces = evt.Pieces
 	state.SectorType = evt.SectorType
+	state.Priority = evt.Priority
 }
 
 type SectorPacked struct{ Pieces []Piece }
@@ -67,20 +149,26 @@ func (evt SectorPacked) apply(state *SectorInfo) {
 
 type SectorPackingFailed struct{ error }
 
-func (evt SectorPackingFailed) apply(*SectorInfo) {}
+func (evt SectorPackingFailed) FormatError(xerrors.Printer) (next error) { return evt.error }
+func (evt SectorPackingFailed) apply(*SectorInfo)                        {}
 
 type SectorPreCommit1 struct {
-	PreCommit1Out storage.PreCommit1Out
-	TicketValue   abi.SealRandomness
-	TicketEpoch   abi.ChainEpoch
+	PreCommit1OutRef cid.Cid
+	TicketValue      abi.SealRandomness
+	TicketEpoch      abi.ChainEpoch
 }
 
 func (evt SectorPreCommit1) apply(state *SectorInfo) {
-	state.PreCommit1Out = evt.PreCommit1Out
+	state.PreCommit1OutRef = &evt.PreCommit1OutRef
 	state.TicketEpoch = evt.TicketEpoch
+	state.TicketDeadline = ticketDeadline(evt.TicketEpoch)
 	state.TicketValue = evt.TicketValue
 }
 
+func (evt SectorPreCommit1) logData(l *Log) {
+	l.Epoch = evt.TicketEpoch
+}
+
 type SectorPreCommit2 struct {
 	Sealed   cid.Cid
 	Unsealed cid.Cid
@@ -93,6 +181,18 @@ func (evt SectorPreCommit2) apply(state *SectorInfo) {
 	state.CommR = &commr
 }
 
+// SectorTicketExpiring is sent when the ticket of a sector waiting for
+// resources would expire before the sector is pre-committed
+type SectorTicketExpiring struct{}
+
+func (evt SectorTicketExpiring) apply(state *SectorInfo) {
+	state.PreCommit1OutRef = nil
+	state.TicketValue = nil
+	state.TicketEpoch = 0
+	state.TicketDeadline = 0
+	state.Queued = false
+}
+
 type SectorSealPreCommitFailed struct{ error }
 
 func (evt SectorSealPreCommitFailed) FormatError(xerrors.Printer) (next error) { return evt.error }
@@ -113,6 +213,10 @@ func (evt SectorPreCommitted) apply(state *SectorInfo) {
 	state.PreCommitMessage = &evt.Message
 }
 
+func (evt SectorPreCommitted) logData(l *Log) {
+	l.MessageCid = &evt.Message
+}
+
 type SectorSeedReady struct {
 	SeedValue abi.InteractiveSealRandomness
 	SeedEpoch abi.ChainEpoch
@@ -123,6 +227,10 @@ func (evt SectorSeedReady) apply(state *SectorInfo) {
 	state.SeedValue = evt.SeedValue
 }
 
+func (evt SectorSeedReady) logData(l *Log) {
+	l.Epoch = evt.SeedEpoch
+}
+
 type SectorComputeProofFailed struct{ error }
 
 func (evt SectorComputeProofFailed) FormatError(xerrors.Printer) (next error) { return evt.error }
@@ -134,15 +242,19 @@ func (evt SectorCommitFailed) FormatError(xerrors.Printer) (next error) { return
 func (evt SectorCommitFailed) apply(*SectorInfo)                        {}
 
 type SectorCommitted struct {
-	Message cid.Cid
-	Proof   []byte
+	Message  cid.Cid
+	ProofRef cid.Cid
 }
 
 func (evt SectorCommitted) apply(state *SectorInfo) {
-	state.Proof = evt.Proof
+	state.ProofRef = &evt.ProofRef
 	state.CommitMessage = &evt.Message
 }
 
+func (evt SectorCommitted) logData(l *Log) {
+	l.MessageCid = &evt.Message
+}
+
 type SectorProving struct{}
 
 func (evt SectorProving) apply(*SectorInfo) {}
@@ -151,6 +263,26 @@ type SectorFinalized struct{}
 
 func (evt SectorFinalized) apply(*SectorInfo) {}
 
+type SectorAborted struct{}
+
+func (evt SectorAborted) apply(state *SectorInfo) {
+	state.PreCommit1OutRef = nil
+	state.ProofRef = nil
+}
+
+type SectorAbortFailed struct{ error }
+
+func (evt SectorAbortFailed) FormatError(xerrors.Printer) (next error) { return evt.error }
+func (evt SectorAbortFailed) apply(*SectorInfo)                        {}
+
+// SectorBlobsRemoved is sent when blobs of a proving sector were deleted
+type SectorBlobsRemoved struct{}
+
+func (evt SectorBlobsRemoved) apply(state *SectorInfo) {
+	state.PreCommit1OutRef = nil
+	state.ProofRef = nil
+}
+
 type SectorFinalizeFailed struct{ error }
 
 func (evt SectorFinalizeFailed) FormatError(xerrors.Printer) (next error) { return evt.error }
@@ -174,6 +306,10 @@ type SectorRetryComputeProof struct{}
 
 func (evt SectorRetryComputeProof) apply(state *SectorInfo) {}
 
+type SectorRetryAbort struct{}
+
+func (evt SectorRetryAbort) apply(state *SectorInfo) {}
+
 type SectorRetryInvalidProof struct{}
 
 func (evt SectorRetryInvalidProof) apply(state *SectorInfo) {
@@ -186,10 +322,16 @@ type SectorFaulty struct{}
 
 func (evt SectorFaulty) apply(state *SectorInfo) {}
 
-type SectorFaultReported struct{ reportMsg cid.Cid }
+type SectorFaultReported struct{ ReportMsg cid.Cid }
 
 func (evt SectorFaultReported) apply(state *SectorInfo) {
-	state.FaultReportMsg = &evt.reportMsg
+	state.FaultReportMsg = &evt.ReportMsg
+}
+
+func (evt SectorFaultReported) logData(l *Log) {
+	l.MessageCid = &evt.ReportMsg
 }
 
 type SectorFaultedFinal struct{}
+
+func (evt SectorFaultedFinal) apply(*SectorInfo) {}
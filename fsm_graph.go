@@ -0,0 +1,257 @@
+package sealing
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"golang.org/x/xerrors"
+)
+
+// forcedStates aren't entered through events, only with ForceSectorState, or
+// from old records. Empty has no planner, it's only found in old records
+var forcedStates = map[SectorState]struct{}{
+	Empty:               {},
+	FailedUnrecoverable: {},
+}
+
+func init() {
+	if err := validatePlanners(fsmPlanners); err != nil {
+		panic(fmt.Sprintf("invalid sector state machine: %+v", err))
+	}
+}
+
+// reachableStates walks the state graph declared by planner transitions,
+// starting at UndefinedSectorState
+func reachableStates(planners map[SectorState]planner) map[SectorState]struct{} {
+	reached := map[SectorState]struct{}{}
+	var queue []SectorState
+
+	visit := func(st SectorState) {
+		if _, ok := reached[st]; ok {
+			return
+		}
+		reached[st] = struct{}{}
+		queue = append(queue, st)
+	}
+
+	visit(UndefinedSectorState)
+	for len(queue) > 0 {
+		st := queue[0]
+		queue = queue[1:]
+
+		// SectorAbort is global, so it isn't in the transitions
+		if _, ok := abortableStates[st]; ok {
+			visit(Aborting)
+		}
+
+		for _, t := range planners[st].transitions {
+			visit(t.next)
+		}
+	}
+
+	return reached
+}
+
+// validatePlanners checks that every reachable state has a planner and a
+// handler, that transitions can be taken, and that all states are reachable.
+// Events in transitions implement mutator, which is checked at compile time
+func validatePlanners(planners map[SectorState]planner) error {
+	var problems []string
+	m := &Sealing{}
+
+	reached := reachableStates(planners)
+	for st := range reached {
+		if p, ok := planners[st]; !ok || p.plan == nil {
+			problems = append(problems, fmt.Sprintf("state %q is reachable, but has no planner", st))
+		}
+		if _, ok := m.handlerFor(st); !ok {
+			problems = append(problems, fmt.Sprintf("state %q is reachable, but has no handler", st))
+		}
+		if _, ok := ExistSectorStateList[st]; !ok && st != UndefinedSectorState {
+			problems = append(problems, fmt.Sprintf("state %q is reachable, but not in ExistSectorStateList", st))
+		}
+	}
+
+	for st, p := range planners {
+		for _, t := range p.transitions {
+			if t.evt == nil {
+				problems = append(problems, fmt.Sprintf("state %q has a transition to %q without an event", st, t.next))
+				continue
+			}
+
+			if _, ok := t.evt.(globalMutator); ok {
+				problems = append(problems, fmt.Sprintf("event %s in state %q is global, its transition to %q is never taken", eventName(t.evt), st, t.next))
+			}
+		}
+	}
+
+	for st := range ExistSectorStateList {
+		_, ok := reached[st]
+		_, forced := forcedStates[st]
+		if !ok && !forced {
+			problems = append(problems, fmt.Sprintf("state %q is unreachable", st))
+		}
+	}
+
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return xerrors.New(strings.Join(problems, "; "))
+	}
+
+	return nil
+}
+
+// graphEdge is a transition in the sector state graph
+type graphEdge struct {
+	from, to SectorState
+	event    string
+}
+
+// stateGraph is the sector state graph, with states and edges sorted, so
+// the output is stable
+type stateGraph struct {
+	states   []SectorState
+	edges    []graphEdge
+	terminal map[SectorState]struct{}
+}
+
+// buildStateGraph collects states and transitions declared by planners, and
+// the global SectorAbort transitions. States without outgoing transitions are
+// terminal
+func buildStateGraph(planners map[SectorState]planner) stateGraph {
+	states := map[SectorState]struct{}{UndefinedSectorState: {}}
+	for st := range ExistSectorStateList {
+		states[st] = struct{}{}
+	}
+
+	seen := map[graphEdge]struct{}{}
+	var edges []graphEdge
+	add := func(e graphEdge) {
+		if _, ok := seen[e]; ok {
+			return
+		}
+		seen[e] = struct{}{}
+		edges = append(edges, e)
+		states[e.from] = struct{}{}
+		states[e.to] = struct{}{}
+	}
+
+	for st, p := range planners {
+		states[st] = struct{}{}
+		for _, t := range p.transitions {
+			add(graphEdge{from: st, to: t.next, event: eventName(t.evt)})
+		}
+	}
+	for st := range abortableStates {
+		add(graphEdge{from: st, to: Aborting, event: eventName(SectorAbort{})})
+	}
+
+	g := stateGraph{terminal: map[SectorState]struct{}{}}
+	for st := range states {
+		g.states = append(g.states, st)
+		g.terminal[st] = struct{}{}
+	}
+	sort.Slice(g.states, func(i, j int) bool { return g.states[i] < g.states[j] })
+
+	sort.Slice(edges, func(i, j int) bool {
+		if edges[i].from != edges[j].from {
+			return edges[i].from < edges[j].from
+		}
+		if edges[i].to != edges[j].to {
+			return edges[i].to < edges[j].to
+		}
+		return edges[i].event < edges[j].event
+	})
+	for _, e := range edges {
+		delete(g.terminal, e.from)
+	}
+	g.edges = edges
+
+	return g
+}
+
+func graphStateName(st SectorState) string {
+	if st == UndefinedSectorState {
+		return "Undefined"
+	}
+	return string(st)
+}
+
+// WriteStateGraphDOT writes the sector state graph in Graphviz DOT format.
+// Failed states are red, terminal states have a double border
+func WriteStateGraphDOT(w io.Writer) error {
+	g := buildStateGraph(fsmPlanners)
+
+	var sb strings.Builder
+	sb.WriteString("digraph SectorStates {\n")
+	sb.WriteString("\tnode [shape=box];\n\n")
+
+	for _, st := range g.states {
+		var attrs []string
+		if st == UndefinedSectorState {
+			attrs = append(attrs, "shape=oval")
+		}
+		if _, ok := failedStates[st]; ok {
+			attrs = append(attrs, "color=red", "fontcolor=red")
+		}
+		if _, ok := g.terminal[st]; ok {
+			attrs = append(attrs, "peripheries=2")
+		}
+
+		fmt.Fprintf(&sb, "\t%q", graphStateName(st))
+		if len(attrs) > 0 {
+			fmt.Fprintf(&sb, " [%s]", strings.Join(attrs, ", "))
+		}
+		sb.WriteString(";\n")
+	}
+	sb.WriteString("\n")
+
+	for _, e := range g.edges {
+		fmt.Fprintf(&sb, "\t%q -> %q [label=%q];\n", graphStateName(e.from), graphStateName(e.to), e.event)
+	}
+	sb.WriteString("}\n")
+
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+// WriteStateGraphMermaid writes the sector state graph as a Mermaid state
+// diagram. UndefinedSectorState is the start state, terminal states
+// transition to the end state, and failed states have the `failed` class
+func WriteStateGraphMermaid(w io.Writer) error {
+	g := buildStateGraph(fsmPlanners)
+
+	name := func(st SectorState) string {
+		if st == UndefinedSectorState {
+			return "[*]"
+		}
+		return string(st)
+	}
+
+	var sb strings.Builder
+	sb.WriteString("stateDiagram-v2\n")
+
+	for _, e := range g.edges {
+		fmt.Fprintf(&sb, "\t%s --> %s: %s\n", name(e.from), name(e.to), e.event)
+	}
+
+	var failed []string
+	for _, st := range g.states {
+		if _, ok := g.terminal[st]; ok && st != UndefinedSectorState {
+			fmt.Fprintf(&sb, "\t%s --> [*]\n", st)
+		}
+		if _, ok := failedStates[st]; ok {
+			failed = append(failed, string(st))
+		}
+	}
+
+	if len(failed) > 0 {
+		sb.WriteString("\n\tclassDef failed fill:#fdd,stroke:#c00\n")
+		fmt.Fprintf(&sb, "\tclass %s failed\n", strings.Join(failed, ", "))
+	}
+
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
@@ -0,0 +1,71 @@
+package sealing
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidatePlanners(t *testing.T) {
+	require.NoError(t, validatePlanners(fsmPlanners))
+
+	for st := range ExistSectorStateList {
+		if _, forced := forcedStates[st]; forced {
+			continue
+		}
+		require.Contains(t, reachableStates(fsmPlanners), st)
+	}
+}
+
+// globalTestEvent is both a mutator and a global event
+type globalTestEvent struct{}
+
+func (globalTestEvent) apply(*SectorInfo)            {}
+func (globalTestEvent) applyGlobal(*SectorInfo) bool { return false }
+
+func TestValidatePlannersErrors(t *testing.T) {
+	err := validatePlanners(map[SectorState]planner{
+		UndefinedSectorState: planOne(on(SectorStart{}, Packing)),
+	})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), `state "Packing" is reachable, but has no planner`)
+	require.Contains(t, err.Error(), `state "Proving" is unreachable`)
+
+	err = validatePlanners(map[SectorState]planner{
+		UndefinedSectorState: planOne(on(SectorStart{}, "Bogus")),
+	})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), `state "Bogus" is reachable, but has no handler`)
+
+	err = validatePlanners(map[SectorState]planner{
+		UndefinedSectorState: planOne(on(globalTestEvent{}, Packing)),
+	})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "event globalTestEvent in state \"\" is global")
+}
+
+func TestStateGraph(t *testing.T) {
+	g := buildStateGraph(fsmPlanners)
+
+	require.Contains(t, g.edges, graphEdge{from: UndefinedSectorState, to: Packing, event: "SectorStart"})
+	require.Contains(t, g.edges, graphEdge{from: PreCommit2, to: PreCommit1, event: "SectorTicketExpiring"})
+	require.Contains(t, g.edges, graphEdge{from: SealFailed, to: Aborting, event: "SectorAbort"})
+
+	require.Contains(t, g.terminal, Aborted)
+	require.Contains(t, g.terminal, FaultedFinal)
+	require.NotContains(t, g.terminal, Proving)
+	require.NotContains(t, g.terminal, PackingFailed)
+
+	var dot bytes.Buffer
+	require.NoError(t, WriteStateGraphDOT(&dot))
+	require.Contains(t, dot.String(), `"Undefined" -> "Packing" [label="SectorStart"];`)
+	require.Contains(t, dot.String(), `"SealFailed" [color=red, fontcolor=red];`)
+	require.Contains(t, dot.String(), `"Aborted" [peripheries=2];`)
+
+	var mermaid bytes.Buffer
+	require.NoError(t, WriteStateGraphMermaid(&mermaid))
+	require.Contains(t, mermaid.String(), "[*] --> Packing: SectorStart")
+	require.Contains(t, mermaid.String(), "FaultedFinal --> [*]")
+	require.Contains(t, mermaid.String(), "classDef failed")
+}
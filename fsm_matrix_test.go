@@ -0,0 +1,333 @@
+package sealing
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-statemachine"
+)
+
+// anyEvent matches events without an explicit entry in a state
+const anyEvent = "*"
+
+// expectedTransitions declares the state every non-global event leads to in
+// every state. Events which aren't listed must be rejected by the planner
+var expectedTransitions = map[SectorState]map[string]SectorState{
+	UndefinedSectorState: {
+		"SectorStart": Packing,
+	},
+	Empty: {},
+	Packing: {
+		"SectorPacked": PreCommit1,
+	},
+	PreCommit1: {
+		"SectorQueued":              PreCommit1,
+		"SectorStageStarted":        PreCommit1,
+		"SectorPreCommit1":          PreCommit2,
+		"SectorSealPreCommitFailed": SealFailed,
+		"SectorPackingFailed":       PackingFailed,
+	},
+	PreCommit2: {
+		"SectorQueued":              PreCommit2,
+		"SectorStageStarted":        PreCommit2,
+		"SectorPreCommit2":          PreCommitting,
+		"SectorTicketExpiring":      PreCommit1,
+		"SectorSealPreCommitFailed": SealFailed,
+		"SectorPackingFailed":       PackingFailed,
+	},
+	PreCommitting: {
+		"SectorSealPreCommitFailed":  SealFailed,
+		"SectorPreCommitted":         WaitSeed,
+		"SectorChainPreCommitFailed": PreCommitFailed,
+	},
+	WaitSeed: {
+		"SectorSeedReady":            Committing,
+		"SectorChainPreCommitFailed": PreCommitFailed,
+	},
+	Committing: {
+		"SectorQueued":              Committing,
+		"SectorStageStarted":        Committing,
+		"SectorCommitted":           CommitWait,
+		"SectorSeedReady":           Committing, // same seed, ignored
+		"SectorComputeProofFailed":  ComputeProofFailed,
+		"SectorSealPreCommitFailed": CommitFailed,
+		"SectorCommitFailed":        CommitFailed,
+	},
+	CommitWait: {
+		"SectorProving":      FinalizeSector,
+		"SectorCommitFailed": CommitFailed,
+	},
+	FinalizeSector: {
+		// TODO: SectorFinalizeFailed is rejected, there is no state for it yet,
+		//  finalizing is retried on restart
+		"SectorFinalized": Proving,
+	},
+	Proving: {
+		"SectorBlobsRemoved":  Proving,
+		"SectorFaultReported": FaultReported,
+		"SectorFaulty":        Faulty,
+	},
+	FailedUnrecoverable: {},
+	SealFailed: {
+		"SectorRetrySeal": PreCommit1,
+	},
+	PreCommitFailed: {
+		"SectorRetryPreCommit":      PreCommitting,
+		"SectorRetryWaitSeed":       WaitSeed,
+		"SectorSealPreCommitFailed": SealFailed,
+	},
+	ComputeProofFailed: {
+		"SectorRetryComputeProof": Committing,
+	},
+	CommitFailed: {
+		"SectorSealPreCommitFailed": SealFailed,
+		"SectorRetryWaitSeed":       WaitSeed,
+		"SectorRetryComputeProof":   Committing,
+		"SectorRetryInvalidProof":   Committing,
+	},
+	PackingFailed: {},
+	Faulty: {
+		"SectorFaultReported": FaultReported,
+	},
+	FaultReported: {
+		"SectorFaultedFinal": FaultedFinal,
+	},
+	FaultedFinal: {},
+	Aborting: {
+		"SectorAborted":     Aborted,
+		"SectorAbortFailed": AbortFailed,
+		anyEvent:            Aborting,
+	},
+	AbortFailed: {
+		"SectorRetryAbort": Aborting,
+		anyEvent:           AbortFailed,
+	},
+	Aborted: {
+		anyEvent: Aborted,
+	},
+}
+
+// rejectGlobal are states in which even global events are rejected
+var rejectGlobal = map[SectorState]bool{
+	Empty:        true,
+	FaultedFinal: true,
+}
+
+// expectedGlobal returns the state a global event leads to
+func expectedGlobal(state SectorState, evt interface{}) SectorState {
+	switch evt := evt.(type) {
+	case SectorForceState:
+		return evt.State
+	case SectorAbort:
+		if canAbort(&SectorInfo{State: state}) {
+			return Aborting
+		}
+	}
+	return state
+}
+
+func matrixEvents() map[string]interface{} {
+	err := xerrors.New("test error")
+
+	evts := []interface{}{
+		SectorRestart{},
+		SectorFatalError{err},
+		SectorForceState{State: Proving, Reason: "test"},
+		SectorPause{},
+		SectorResume{},
+		SectorSetPriority{Priority: 10},
+		SectorAbort{Reason: "test"},
+		SectorRetry{},
+
+		SectorQueued{},
+		SectorStageStarted{},
+
+		SectorStart{},
+		SectorPacked{},
+		SectorPackingFailed{err},
+		SectorPreCommit1{},
+		SectorPreCommit2{},
+		SectorTicketExpiring{},
+		SectorSealPreCommitFailed{err},
+		SectorChainPreCommitFailed{err},
+		SectorPreCommitted{},
+		SectorSeedReady{},
+		SectorComputeProofFailed{err},
+		SectorCommitFailed{err},
+		SectorCommitted{},
+		SectorProving{},
+		SectorFinalized{},
+		SectorAborted{},
+		SectorAbortFailed{err},
+		SectorBlobsRemoved{},
+		SectorFinalizeFailed{err},
+
+		SectorRetrySeal{},
+		SectorRetryPreCommit{},
+		SectorRetryWaitSeed{},
+		SectorRetryComputeProof{},
+		SectorRetryInvalidProof{},
+		SectorRetryAbort{},
+
+		SectorFaulty{},
+		SectorFaultReported{},
+		SectorFaultedFinal{},
+	}
+
+	out := map[string]interface{}{}
+	for _, evt := range evts {
+		out[eventName(evt)] = evt
+	}
+	return out
+}
+
+// declaredEvents returns names of all event types declared in fsm_events.go
+func declaredEvents(t *testing.T) []string {
+	f, err := parser.ParseFile(token.NewFileSet(), "fsm_events.go", nil, 0)
+	require.NoError(t, err)
+
+	var out []string
+	for _, decl := range f.Decls {
+		gd, ok := decl.(*ast.GenDecl)
+		if !ok || gd.Tok != token.TYPE {
+			continue
+		}
+		for _, spec := range gd.Specs {
+			name := spec.(*ast.TypeSpec).Name.Name
+			if strings.HasPrefix(name, "Sector") {
+				out = append(out, name)
+			}
+		}
+	}
+	return out
+}
+
+func TestTransitionMatrixComplete(t *testing.T) {
+	evts := matrixEvents()
+	for _, name := range declaredEvents(t) {
+		require.Contains(t, evts, name, "event %s is missing from the transition matrix test", name)
+	}
+
+	for state := range ExistSectorStateList {
+		require.Contains(t, expectedTransitions, state, "state %s is missing from expectedTransitions", state)
+	}
+	require.Contains(t, expectedTransitions, UndefinedSectorState)
+
+	for state, trans := range expectedTransitions {
+		for name := range trans {
+			if name == anyEvent {
+				continue
+			}
+			require.Contains(t, evts, name, "unknown event %s in expected transitions of %s", name, state)
+		}
+	}
+}
+
+func TestTransitionMatrix(t *testing.T) {
+	m := &Sealing{}
+
+	for state, trans := range expectedTransitions {
+		for name, evt := range matrixEvents() {
+			si := &SectorInfo{State: state}
+			_, err := m.plan([]statemachine.Event{{User: evt}}, si)
+
+			_, global := evt.(globalMutator)
+
+			var expect SectorState
+			var accepted bool
+			switch {
+			case global:
+				expect, accepted = expectedGlobal(state, evt), !rejectGlobal[state]
+			default:
+				expect, accepted = trans[name]
+				if !accepted {
+					expect, accepted = trans[anyEvent]
+				}
+			}
+
+			if !accepted {
+				require.Error(t, err, "expected %s to reject %s", state, name)
+				continue
+			}
+
+			require.NoError(t, err, "expected %s to accept %s", state, name)
+			require.Equal(t, expect, si.State, "%s in state %s", name, state)
+		}
+	}
+}
+
+// TestTransitionMatrixBatched checks that events which don't interrupt event
+// processing don't drop other events planned in the same batch
+func TestTransitionMatrixBatched(t *testing.T) {
+	m := &Sealing{}
+
+	for state, trans := range expectedTransitions {
+		if rejectGlobal[state] {
+			continue
+		}
+
+		for name, evt := range matrixEvents() {
+			if _, global := evt.(globalMutator); global {
+				continue
+			}
+			expect, ok := trans[name]
+			if !ok {
+				continue
+			}
+
+			for _, batch := range [][]interface{}{
+				{SectorSetPriority{Priority: 3}, evt},
+				{evt, SectorSetPriority{Priority: 3}},
+				{SectorPause{}, evt},
+				{evt, SectorPause{}},
+			} {
+				events := make([]statemachine.Event, len(batch))
+				for i, e := range batch {
+					events[i] = statemachine.Event{User: e}
+				}
+
+				si := &SectorInfo{State: state}
+				_, err := m.plan(events, si)
+				require.NoError(t, err, "%s in state %s, batch %v", name, state, batch)
+				require.Equal(t, expect, si.State, "%s in state %s, batch %v", name, state, batch)
+			}
+		}
+	}
+}
+
+// TestPlannerTransitions checks that the transitions planners declare for the
+// state graph match what they do, planCustom planners handle events in their
+// own code, which can drift from the declared list
+func TestPlannerTransitions(t *testing.T) {
+	for state, p := range fsmPlanners {
+		declared := map[string]SectorState{}
+		for _, tr := range p.transitions {
+			declared[eventName(tr.evt)] = tr.next
+		}
+
+		for name, evt := range matrixEvents() {
+			if _, global := evt.(globalMutator); global {
+				continue
+			}
+
+			si := &SectorInfo{State: state}
+			if err := p.plan([]statemachine.Event{{User: evt}}, si); err != nil {
+				require.NotContains(t, declared, name, "%s declares a transition on %s, but rejects it", state, name)
+				continue
+			}
+
+			next, ok := declared[name]
+			if !ok {
+				require.Equal(t, state, si.State, "%s moves to %s on %s, which isn't declared", state, si.State, name)
+				continue
+			}
+			require.Equal(t, next, si.State, "%s declares %s -> %s, but moves to %s", name, state, next, si.State)
+		}
+	}
+}
@@ -5,8 +5,11 @@ import (
 
 	logging "github.com/ipfs/go-log/v2"
 	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
 
 	"github.com/filecoin-project/go-statemachine"
+	"github.com/filecoin-project/specs-actors/actors/abi"
+	"github.com/filecoin-project/specs-actors/actors/builtin"
 )
 
 func init() {
@@ -107,3 +110,199 @@ func TestPlanCommittingHandlesSectorCommitFailed(t *testing.T) {
 
 	require.Equal(t, CommitFailed, m.state.State)
 }
+
+func TestStructuredLog(t *testing.T) {
+	m := test{
+		s:     &Sealing{},
+		t:     t,
+		state: &SectorInfo{State: PreCommitting},
+	}
+
+	msg := builtin.AccountActorCodeID
+	m.planSingle(SectorPreCommitted{Message: msg})
+	require.Equal(t, WaitSeed, m.state.State)
+
+	m.planSingle(SectorChainPreCommitFailed{xerrors.New("msg failed")})
+	require.Equal(t, PreCommitFailed, m.state.State)
+
+	require.Len(t, m.state.Log, 2)
+
+	l := m.state.Log[0]
+	require.Equal(t, "SectorPreCommitted", l.Event)
+	require.Equal(t, PreCommitting, l.From)
+	require.Equal(t, WaitSeed, l.To)
+	require.Equal(t, &msg, l.MessageCid)
+	require.Empty(t, l.Error)
+
+	l = m.state.Log[1]
+	require.Equal(t, "SectorChainPreCommitFailed", l.Event)
+	require.Equal(t, WaitSeed, l.From)
+	require.Equal(t, PreCommitFailed, l.To)
+	require.Equal(t, "msg failed", l.Error)
+}
+
+func TestPauseResume(t *testing.T) {
+	m := test{
+		s:     &Sealing{},
+		t:     t,
+		state: &SectorInfo{State: PreCommit1},
+	}
+
+	m.planSingle(SectorPause{})
+	require.True(t, m.state.Paused)
+
+	// events still apply, but no handler is run
+	next, err := m.s.plan([]statemachine.Event{{User: SectorPreCommit1{}}}, m.state)
+	require.NoError(t, err)
+	require.Nil(t, next)
+	require.Equal(t, PreCommit2, m.state.State)
+
+	next, err = m.s.plan([]statemachine.Event{{User: SectorRestart{}}}, m.state)
+	require.NoError(t, err)
+	require.Nil(t, next)
+
+	next, err = m.s.plan([]statemachine.Event{{User: SectorResume{}}}, m.state)
+	require.NoError(t, err)
+	require.NotNil(t, next)
+	require.False(t, m.state.Paused)
+	require.Equal(t, PreCommit2, m.state.State)
+
+	// resuming a sector which isn't paused doesn't run the handler again
+	next, err = m.s.plan([]statemachine.Event{{User: SectorResume{}}}, m.state)
+	require.NoError(t, err)
+	require.Nil(t, next)
+}
+
+func TestPauseDuringHandler(t *testing.T) {
+	m := test{
+		s:     &Sealing{},
+		t:     t,
+		state: &SectorInfo{State: PreCommitting},
+	}
+
+	// the result of the handler which was running when the sector was paused
+	// is applied, so it doesn't run again on resume
+	next, err := m.s.plan([]statemachine.Event{{User: SectorPause{}}, {User: SectorPreCommitted{}}}, m.state)
+	require.NoError(t, err)
+	require.Nil(t, next)
+	require.True(t, m.state.Paused)
+	require.Equal(t, WaitSeed, m.state.State)
+}
+
+func TestSetPriority(t *testing.T) {
+	m := test{
+		s:     &Sealing{},
+		t:     t,
+		state: &SectorInfo{State: PreCommitting},
+	}
+
+	next, err := m.s.plan([]statemachine.Event{{User: SectorPreCommitted{}}, {User: SectorSetPriority{Priority: 3}}}, m.state)
+	require.NoError(t, err)
+	require.NotNil(t, next)
+	require.Equal(t, WaitSeed, m.state.State)
+	require.Equal(t, uint64(3), m.state.Priority)
+
+	// a priority change alone doesn't run the WaitSeed handler again, which
+	// would register another seed callback
+	next, err = m.s.plan([]statemachine.Event{{User: SectorSetPriority{Priority: 5}}}, m.state)
+	require.NoError(t, err)
+	require.Nil(t, next)
+	require.Equal(t, WaitSeed, m.state.State)
+	require.Equal(t, uint64(5), m.state.Priority)
+
+	m.state.State = Committing
+	next, err = m.s.plan([]statemachine.Event{{User: SectorSetPriority{Priority: 7}}, {User: SectorCommitted{}}}, m.state)
+	require.NoError(t, err)
+	require.NotNil(t, next)
+	require.Equal(t, CommitWait, m.state.State)
+	require.Equal(t, uint64(7), m.state.Priority)
+}
+
+func TestForceState(t *testing.T) {
+	c := builtin.AccountActorCodeID
+	si := SectorInfo{State: PreCommitFailed, CommD: &c, Pieces: []Piece{{CommP: c}}}
+
+	require.Error(t, checkForceState(si, "NotAState"))
+	require.Error(t, checkForceState(si, Empty))
+	require.Error(t, checkForceState(si, UndefinedSectorState))
+	require.Error(t, checkForceState(si, PreCommitting))
+	require.NoError(t, checkForceState(si, SealFailed))
+
+	si.CommR = &c
+	require.NoError(t, checkForceState(si, PreCommitting))
+	require.Error(t, checkForceState(si, WaitSeed))
+
+	// aborts go through AbortSector, which checks the sector can be aborted
+	require.Error(t, checkForceState(si, Aborting))
+	require.Error(t, checkForceState(si, AbortFailed))
+	require.Error(t, checkForceState(si, Aborted))
+
+	m := test{
+		s:     &Sealing{},
+		t:     t,
+		state: &si,
+	}
+
+	m.planSingle(SectorForceState{State: PreCommitting, Reason: "retry precommit"})
+	require.Equal(t, PreCommitting, m.state.State)
+	require.Len(t, m.state.Overrides, 1)
+	require.Equal(t, PreCommitFailed, m.state.Overrides[0].From)
+	require.Equal(t, PreCommitting, m.state.Overrides[0].To)
+	require.Equal(t, "retry precommit", m.state.Overrides[0].Reason)
+}
+
+func TestAbort(t *testing.T) {
+	m := test{
+		s:     &Sealing{},
+		t:     t,
+		state: &SectorInfo{State: PreCommit1},
+	}
+
+	m.planSingle(SectorAbort{Reason: "test"})
+	require.Equal(t, Aborting, m.state.State)
+
+	// late events from the cancelled handler are ignored
+	m.planSingle(SectorSealPreCommitFailed{xerrors.New("context canceled")})
+	require.Equal(t, Aborting, m.state.State)
+
+	m.planSingle(SectorAbortFailed{xerrors.New("remove failed")})
+	require.Equal(t, AbortFailed, m.state.State)
+
+	m.planSingle(SectorRetryAbort{})
+	require.Equal(t, Aborting, m.state.State)
+
+	m.planSingle(SectorAborted{})
+	require.Equal(t, Aborted, m.state.State)
+
+	m.state = &SectorInfo{State: WaitSeed}
+	m.planSingle(SectorAbort{Reason: "test"})
+	require.Equal(t, WaitSeed, m.state.State)
+}
+
+func TestCountSealing(t *testing.T) {
+	sectors := []SectorInfo{
+		{State: UndefinedSectorState},
+		{State: Packing},
+		{State: PreCommit2},
+		{State: WaitSeed},
+		{State: CommitWait},
+		{State: FinalizeSector},
+		{State: Proving},
+		{State: SealFailed},
+	}
+
+	require.Equal(t, 5, countSealing(sectors))
+}
+
+func TestTicketExpiring(t *testing.T) {
+	m := test{
+		s:     &Sealing{},
+		t:     t,
+		state: &SectorInfo{State: PreCommit2, TicketValue: abi.SealRandomness{1}, TicketEpoch: 10},
+	}
+
+	m.planSingle(SectorTicketExpiring{})
+	require.Equal(m.t, m.state.State, PreCommit1)
+	require.Empty(t, m.state.TicketValue)
+	require.Equal(t, abi.ChainEpoch(0), m.state.TicketEpoch)
+}
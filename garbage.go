@@ -41,41 +41,140 @@ func (m *Sealing) pledgeSector(ctx context.Context, sectorID abi.SectorID, exist
 	return out, nil
 }
 
-func (m *Sealing) PledgeSector() error {
-	go func() {
-		ctx := context.TODO() // we can't use the context from command which invokes
-		// this, as we run everything here async, and it's cancelled when the
-		// command exits
+// PledgeHandle tracks a CC sector pledge started with PledgeSectorAsync
+type PledgeHandle struct {
+	sector abi.SectorNumber
 
-		size := abi.PaddedPieceSize(m.sealer.SectorSize()).Unpadded()
+	done chan struct{}
+	err  error
+}
 
-		_, rt, err := ffiwrapper.ProofTypeFromSectorSize(m.sealer.SectorSize())
-		if err != nil {
-			log.Error(err)
-			return
-		}
+// Sector returns the number allocated for the pledged sector
+func (h *PledgeHandle) Sector() abi.SectorNumber {
+	return h.sector
+}
 
-		sid, err := m.sc.Next()
-		if err != nil {
-			log.Errorf("%+v", err)
-			return
-		}
-		err = m.sealer.NewSector(ctx, m.minerSector(sid))
-		if err != nil {
-			log.Errorf("%+v", err)
-			return
-		}
+// Done is closed when the sector has been handed to the state machine, or
+// pledging failed
+func (h *PledgeHandle) Done() <-chan struct{} {
+	return h.done
+}
+
+// Wait waits for the pledge to finish and returns its result
+func (h *PledgeHandle) Wait(ctx context.Context) (abi.SectorNumber, error) {
+	select {
+	case <-h.done:
+		return h.sector, h.err
+	case <-ctx.Done():
+		return h.sector, ctx.Err()
+	}
+}
+
+func (m *Sealing) PledgeSector() error {
+	_, err := m.PledgeSectorAsync(context.TODO())
+	return err
+}
 
-		pieces, err := m.pledgeSector(ctx, m.minerSector(sid), []abi.UnpaddedPieceSize{}, size)
+// PledgeSectors starts pledging n CC sectors. If a sector number can't be
+// allocated, handles for sectors started so far are returned with the error
+func (m *Sealing) PledgeSectors(ctx context.Context, n int) ([]*PledgeHandle, error) {
+	out := make([]*PledgeHandle, 0, n)
+	for i := 0; i < n; i++ {
+		h, err := m.PledgeSectorAsync(ctx)
 		if err != nil {
-			log.Errorf("%+v", err)
-			return
+			return out, xerrors.Errorf("pledging sector %d of %d: %w", i+1, n, err)
 		}
+		out = append(out, h)
+	}
+	return out, nil
+}
+
+// PledgeSectorContext creates a CC sector, and returns once it's handed to
+// the state machine
+func (m *Sealing) PledgeSectorContext(ctx context.Context) (abi.SectorNumber, error) {
+	done, ok := m.startWork()
+	if !ok {
+		return 0, xerrors.New("sealing is stopping")
+	}
+	defer done()
+
+	sid, rt, err := m.allocateCCSector(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := m.pledgeNewSector(ctx, sid, rt); err != nil {
+		return sid, err
+	}
 
-		if err := m.newSector(sid, rt, pieces); err != nil {
-			log.Errorf("%+v", err)
-			return
+	return sid, nil
+}
+
+// PledgeSectorAsync allocates a sector number for a CC sector, and fills the
+// sector in the background. The returned handle can be used to wait for
+// the result
+func (m *Sealing) PledgeSectorAsync(ctx context.Context) (*PledgeHandle, error) {
+	done, ok := m.startWork()
+	if !ok {
+		return nil, xerrors.New("sealing is stopping")
+	}
+
+	sid, rt, err := m.allocateCCSector(ctx)
+	if err != nil {
+		done()
+		return nil, err
+	}
+
+	h := &PledgeHandle{
+		sector: sid,
+		done:   make(chan struct{}),
+	}
+
+	go func() {
+		defer done()
+		defer close(h.done)
+
+		// we can't use the context from command which invokes this, as we run
+		// everything here async, and it's cancelled when the command exits
+		h.err = m.pledgeNewSector(m.lifeCtx, sid, rt)
+		if h.err != nil {
+			log.Errorf("pledging sector %d: %+v", sid, h.err)
 		}
 	}()
+
+	return h, nil
+}
+
+func (m *Sealing) allocateCCSector(ctx context.Context) (abi.SectorNumber, abi.RegisteredProof, error) {
+	_, rt, err := ffiwrapper.ProofTypeFromSectorSize(m.sealer.SectorSize())
+	if err != nil {
+		return 0, 0, xerrors.Errorf("bad sector size: %w", err)
+	}
+
+	sid, err := m.sc.Next()
+	if err != nil {
+		return 0, 0, xerrors.Errorf("getting sector number: %w", err)
+	}
+
+	if err := m.sealer.NewSector(ctx, m.minerSector(sid)); err != nil {
+		m.burnSector(sid, err)
+		return 0, 0, xerrors.Errorf("initializing sector %d: %w", sid, err)
+	}
+
+	return sid, rt, nil
+}
+
+func (m *Sealing) pledgeNewSector(ctx context.Context, sid abi.SectorNumber, rt abi.RegisteredProof) error {
+	size := abi.PaddedPieceSize(m.sealer.SectorSize()).Unpadded()
+
+	pieces, err := m.pledgeSector(ctx, m.minerSector(sid), []abi.UnpaddedPieceSize{}, size)
+	if err != nil {
+		return xerrors.Errorf("pledging sector %d: %w", sid, err)
+	}
+
+	if err := m.newSector(sid, rt, pieces, DefaultPriority); err != nil {
+		return xerrors.Errorf("starting sector %d: %w", sid, err)
+	}
+
 	return nil
 }
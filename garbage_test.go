@@ -0,0 +1,153 @@
+package sealing_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/specs-actors/actors/abi"
+
+	sealing "github.com/filecoin-project/storage-fsm"
+	"github.com/filecoin-project/storage-fsm/mock"
+)
+
+func TestPledgeSectorContext(t *testing.T) {
+	h, stop := newHarness(t)
+	defer stop()
+
+	sid, err := h.m.PledgeSectorContext(h.ctx)
+	require.NoError(t, err)
+
+	// the sector is in the state machine when the pledge returns
+	_, err = h.m.GetSectorInfo(sid)
+	require.NoError(t, err)
+	require.Equal(t, 1, h.sm.Calls(mock.StepAddPiece))
+}
+
+func TestPledgeSectorContextCancel(t *testing.T) {
+	h, stop := newHarness(t)
+	defer stop()
+
+	h.sm.Inject(mock.StepAddPiece, mock.Fault{Times: 1, Delay: time.Hour})
+
+	ctx, cancel := context.WithTimeout(h.ctx, 50*time.Millisecond)
+	defer cancel()
+
+	sid, err := h.m.PledgeSectorContext(ctx)
+	require.True(t, xerrors.Is(err, context.DeadlineExceeded), "unexpected error: %+v", err)
+
+	// the sector was never handed to the state machine
+	_, err = h.m.GetSectorInfo(sid)
+	require.Error(t, err)
+
+	// a cancelled allocation doesn't get in the way of the next one
+	next, err := h.m.PledgeSectorContext(h.ctx)
+	require.NoError(t, err)
+	require.NotEqual(t, sid, next)
+}
+
+func TestPledgeSectorAsync(t *testing.T) {
+	h, stop := newHarness(t)
+	defer stop()
+
+	ph, err := h.m.PledgeSectorAsync(h.ctx)
+	require.NoError(t, err)
+
+	sid, err := ph.Wait(h.ctx)
+	require.NoError(t, err)
+	require.Equal(t, ph.Sector(), sid)
+
+	select {
+	case <-ph.Done():
+	default:
+		t.Fatal("handle isn't done after Wait returned")
+	}
+
+	h.waitState(sid, sealing.Proving)
+}
+
+func TestPledgeSectorAsyncFails(t *testing.T) {
+	h, stop := newHarness(t)
+	defer stop()
+
+	h.sm.Inject(mock.StepAddPiece, mock.Fault{Times: 1, Err: xerrors.New("disk on fire")})
+
+	ph, err := h.m.PledgeSectorAsync(h.ctx)
+	require.NoError(t, err)
+
+	_, err = ph.Wait(h.ctx)
+	require.Error(t, err)
+
+	_, err = h.m.GetSectorInfo(ph.Sector())
+	require.Error(t, err)
+}
+
+func TestPledgeHandleWaitCancel(t *testing.T) {
+	h, stop := newHarness(t)
+	defer stop()
+
+	h.sm.Inject(mock.StepAddPiece, mock.Fault{Times: 1, Delay: 200 * time.Millisecond})
+
+	// the pledge runs on the sealing context, cancelling the context it was
+	// started with doesn't stop it
+	ctx, cancel := context.WithCancel(h.ctx)
+	ph, err := h.m.PledgeSectorAsync(ctx)
+	require.NoError(t, err)
+	cancel()
+
+	wctx, wcancel := context.WithTimeout(h.ctx, 20*time.Millisecond)
+	defer wcancel()
+
+	sid, err := ph.Wait(wctx)
+	require.True(t, xerrors.Is(err, context.DeadlineExceeded), "unexpected error: %+v", err)
+	require.Equal(t, ph.Sector(), sid)
+
+	select {
+	case <-ph.Done():
+		t.Fatal("handle is done while the piece is still being added")
+	default:
+	}
+
+	sid, err = ph.Wait(h.ctx)
+	require.NoError(t, err)
+	h.waitState(sid, sealing.Proving)
+}
+
+func TestPledgeSectors(t *testing.T) {
+	h, stop := newHarness(t)
+	defer stop()
+
+	handles, err := h.m.PledgeSectors(h.ctx, 3)
+	require.NoError(t, err)
+	require.Len(t, handles, 3)
+
+	seen := map[abi.SectorNumber]struct{}{}
+	for _, ph := range handles {
+		sid, err := ph.Wait(h.ctx)
+		require.NoError(t, err)
+		seen[sid] = struct{}{}
+
+		h.waitState(sid, sealing.Proving)
+	}
+	require.Len(t, seen, 3)
+}
+
+func TestPledgeSectorsAllocationFails(t *testing.T) {
+	h, stop := newHarness(t)
+	defer stop()
+
+	// the third sector can't be initialized
+	h.sm.Inject(mock.StepNewSector, mock.Fault{Sectors: []abi.SectorNumber{2}, Err: xerrors.New("no space")})
+
+	handles, err := h.m.PledgeSectors(h.ctx, 5)
+	require.Error(t, err)
+	require.Len(t, handles, 2)
+
+	for _, ph := range handles {
+		_, err := ph.Wait(h.ctx)
+		require.NoError(t, err)
+	}
+}
@@ -9,14 +9,43 @@ import (
 	sealing "github.com/filecoin-project/storage-fsm"
 )
 
+// Without arguments, main generates CBOR encoders. `graph [dot|mermaid]`
+// writes the sector state graph to stdout instead
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "graph" {
+		graph(os.Args[2:])
+		return
+	}
+
 	err := gen.WriteMapEncodersToFile("./cbor_gen.go", "sealing",
 		sealing.Piece{},
 		sealing.SectorInfo{},
 		sealing.Log{},
+		sealing.StateOverride{},
 	)
 	if err != nil {
 		fmt.Println(err)
 		os.Exit(1)
 	}
 }
+
+func graph(args []string) {
+	format := "dot"
+	if len(args) > 0 {
+		format = args[0]
+	}
+
+	var err error
+	switch format {
+	case "dot":
+		err = sealing.WriteStateGraphDOT(os.Stdout)
+	case "mermaid":
+		err = sealing.WriteStateGraphMermaid(os.Stdout)
+	default:
+		err = fmt.Errorf("unknown graph format %q, expected dot or mermaid", format)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,347 @@
+// inspect prints sector metadata stored by Sealing in a badger datastore,
+// without starting a Sealing instance. Badger doesn't allow concurrent
+// access, so the miner using the datastore must be stopped. Miners hosted
+// by a sealing.Manager are inspected with -prefix /miners/<miner address>.
+//
+//	go run ./inspect/main.go -repo <datastore dir> list
+//	go run ./inspect/main.go -repo <datastore dir> show <sector>
+//	go run ./inspect/main.go -repo <datastore dir> -json log <sector>
+//	go run ./inspect/main.go -repo <datastore dir> replay <sector>
+//	go run ./inspect/main.go -repo <datastore dir> -blobs <blob dir> export > sectors.export
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/namespace"
+	"github.com/ipfs/go-datastore/query"
+	badger "github.com/ipfs/go-ds-badger2"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/specs-actors/actors/abi"
+
+	sealing "github.com/filecoin-project/storage-fsm"
+)
+
+var (
+	repoFlag   = flag.String("repo", "", "path to the badger datastore directory")
+	prefixFlag = flag.String("prefix", "/", "namespace of the datastore passed to Sealing")
+	jsonFlag   = flag.Bool("json", false, "print JSON instead of tables")
+	blobsFlag  = flag.String("blobs", "", "directory of the miner FSBlobStore, exports include blobs")
+)
+
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), "usage: %s -repo <dir> [flags] list | show <sector> | log <sector> | replay <sector> | export\n\n", os.Args[0])
+	flag.PrintDefaults()
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+
+	if err := run(flag.Args()); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string) error {
+	if *repoFlag == "" || len(args) == 0 {
+		usage()
+		return xerrors.New("missing datastore path or command")
+	}
+
+	opts := badger.DefaultOptions
+	opts.ReadOnly = true
+	ds, err := badger.NewDatastore(*repoFlag, &opts)
+	if err != nil {
+		return xerrors.Errorf("opening datastore %s: %w", *repoFlag, err)
+	}
+	defer ds.Close() // nolint: errcheck
+
+	var blobs sealing.BlobStore
+	if *blobsFlag != "" {
+		// don't create the directory, unlike NewFSBlobStore
+		if _, err := os.Stat(*blobsFlag); err != nil {
+			return xerrors.Errorf("opening blob directory: %w", err)
+		}
+		if blobs, err = sealing.NewFSBlobStore(*blobsFlag); err != nil {
+			return err
+		}
+	}
+
+	return inspect(os.Stdout, ds, datastore.NewKey(*prefixFlag), blobs, args, *jsonFlag)
+}
+
+// inspect runs a command, blobs is only needed by export
+func inspect(w io.Writer, ds datastore.Datastore, prefix datastore.Key, blobs sealing.BlobStore, args []string, asJSON bool) error {
+	switch args[0] {
+	case "list":
+		sectors, err := loadSectors(ds, prefix)
+		if err != nil {
+			return err
+		}
+		return printList(w, sectors, asJSON)
+	case "show", "log", "replay":
+		if len(args) != 2 {
+			return xerrors.Errorf("usage: %s <sector>", args[0])
+		}
+		num, err := strconv.ParseUint(args[1], 10, 64)
+		if err != nil {
+			return xerrors.Errorf("parsing sector number: %w", err)
+		}
+
+		si, err := loadSector(ds, prefix, abi.SectorNumber(num))
+		if err != nil {
+			return err
+		}
+
+		switch args[0] {
+		case "log":
+			return printLog(w, si.Log, asJSON)
+		case "replay":
+			rep, err := sealing.ReplaySector(si)
+			if err != nil {
+				return xerrors.Errorf("replaying sector %d: %w", num, err)
+			}
+			return printReplay(w, rep, asJSON)
+		}
+		return printSector(w, si, asJSON)
+	case "export":
+		if blobs == nil {
+			return xerrors.New("export needs the blob directory, see -blobs")
+		}
+		return sealing.ExportSectors(namespace.Wrap(ds, prefix), blobs, w)
+	default:
+		return xerrors.Errorf("unknown command %q", args[0])
+	}
+}
+
+func sectorsKey(prefix datastore.Key) datastore.Key {
+	return prefix.Child(datastore.NewKey(sealing.SectorStorePrefix))
+}
+
+// loadSectors decodes all sectors stored under prefix, ordered by number
+func loadSectors(ds datastore.Datastore, prefix datastore.Key) ([]sealing.SectorInfo, error) {
+	res, err := ds.Query(query.Query{Prefix: sectorsKey(prefix).String()})
+	if err != nil {
+		return nil, xerrors.Errorf("querying sectors: %w", err)
+	}
+	defer res.Close() // nolint: errcheck
+
+	var out []sealing.SectorInfo
+	for r := range res.Next() {
+		if r.Error != nil {
+			return nil, xerrors.Errorf("reading sectors: %w", r.Error)
+		}
+
+		si, err := sealing.DecodeSectorInfo(r.Value)
+		if err != nil {
+			return nil, xerrors.Errorf("decoding sector %s: %w", r.Key, err)
+		}
+		out = append(out, si)
+	}
+
+	sort.Slice(out, func(i, j int) bool { return out[i].SectorNumber < out[j].SectorNumber })
+	return out, nil
+}
+
+func loadSector(ds datastore.Datastore, prefix datastore.Key, num abi.SectorNumber) (sealing.SectorInfo, error) {
+	b, err := ds.Get(sectorsKey(prefix).ChildString(fmt.Sprint(num)))
+	if err != nil {
+		return sealing.SectorInfo{}, xerrors.Errorf("getting sector %d: %w", num, err)
+	}
+
+	si, err := sealing.DecodeSectorInfo(b)
+	if err != nil {
+		return si, xerrors.Errorf("decoding sector %d: %w", num, err)
+	}
+	return si, nil
+}
+
+type sectorSummary struct {
+	SectorNumber     abi.SectorNumber
+	State            sealing.SectorState
+	Queued           bool
+	Deals            []abi.DealID
+	Paused           bool
+	PreCommitMessage *cid.Cid
+	CommitMessage    *cid.Cid
+	LastErr          string
+}
+
+func dealIDs(si sealing.SectorInfo) []abi.DealID {
+	out := make([]abi.DealID, 0, len(si.Pieces))
+	for _, p := range si.Pieces {
+		if p.DealID != nil {
+			out = append(out, *p.DealID)
+		}
+	}
+	return out
+}
+
+// stateStr shows the sector state, and if the sector waits for a stage slot
+func stateStr(si sealing.SectorInfo) string {
+	if si.Queued {
+		return fmt.Sprintf("%s (queued)", si.State)
+	}
+	return string(si.State)
+}
+
+func printList(w io.Writer, sectors []sealing.SectorInfo, asJSON bool) error {
+	if asJSON {
+		out := make([]sectorSummary, len(sectors))
+		for i, si := range sectors {
+			out[i] = sectorSummary{
+				SectorNumber:     si.SectorNumber,
+				State:            si.State,
+				Queued:           si.Queued,
+				Deals:            dealIDs(si),
+				Paused:           si.Paused,
+				PreCommitMessage: si.PreCommitMessage,
+				CommitMessage:    si.CommitMessage,
+				LastErr:          si.LastErr,
+			}
+		}
+		return printJSON(w, out)
+	}
+
+	tw := tabwriter.NewWriter(w, 2, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tState\tDeals\tPaused\tPreCommit\tCommit\tLast error")
+	for _, si := range sectors {
+		fmt.Fprintf(tw, "%d\t%s\t%v\t%t\t%s\t%s\t%s\n", si.SectorNumber, stateStr(si), dealIDs(si), si.Paused, cidStr(si.PreCommitMessage), cidStr(si.CommitMessage), si.LastErr)
+	}
+	return tw.Flush()
+}
+
+func printSector(w io.Writer, si sealing.SectorInfo, asJSON bool) error {
+	if asJSON {
+		return printJSON(w, si)
+	}
+
+	tw := tabwriter.NewWriter(w, 2, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "Sector:\t%d\n", si.SectorNumber)
+	fmt.Fprintf(tw, "State:\t%s\n", stateStr(si))
+	fmt.Fprintf(tw, "Type:\t%d\n", si.SectorType)
+	fmt.Fprintf(tw, "Priority:\t%d\n", si.Priority)
+	fmt.Fprintf(tw, "Paused:\t%t\n", si.Paused)
+	fmt.Fprintf(tw, "Ticket:\t%s @ %d\n", hex.EncodeToString(si.TicketValue), si.TicketEpoch)
+	fmt.Fprintf(tw, "Ticket deadline:\t%d\n", si.TicketDeadline)
+	fmt.Fprintf(tw, "Seed:\t%s @ %d\n", hex.EncodeToString(si.SeedValue), si.SeedEpoch)
+	fmt.Fprintf(tw, "CommD:\t%s\n", cidStr(si.CommD))
+	fmt.Fprintf(tw, "CommR:\t%s\n", cidStr(si.CommR))
+	fmt.Fprintf(tw, "PreCommit message:\t%s\n", cidStr(si.PreCommitMessage))
+	fmt.Fprintf(tw, "Commit message:\t%s\n", cidStr(si.CommitMessage))
+	fmt.Fprintf(tw, "Fault report message:\t%s\n", cidStr(si.FaultReportMsg))
+	fmt.Fprintf(tw, "PreCommit1 output:\t%s\n", cidStr(si.PreCommit1OutRef))
+	fmt.Fprintf(tw, "Proof:\t%s\n", cidStr(si.ProofRef))
+	fmt.Fprintf(tw, "Invalid proofs:\t%d\n", si.InvalidProofs)
+	fmt.Fprintf(tw, "Last error:\t%s\n", si.LastErr)
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(w, "\nPieces:\n")
+	tw = tabwriter.NewWriter(w, 2, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "#\tDeal\tSize\tCommP")
+	for i, p := range si.Pieces {
+		deal := "-"
+		if p.DealID != nil {
+			deal = fmt.Sprint(*p.DealID)
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%d\t%s\n", i, deal, p.Size, p.CommP)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	if len(si.Overrides) > 0 {
+		fmt.Fprintf(w, "\nOverrides:\n")
+		tw = tabwriter.NewWriter(w, 2, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "Time\tFrom\tTo\tReason")
+		for _, o := range si.Overrides {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", timeStr(o.Timestamp), o.From, o.To, o.Reason)
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+	}
+
+	fmt.Fprintf(w, "\nLog:\n")
+	return printLog(w, si.Log, false)
+}
+
+func printLog(w io.Writer, entries []sealing.Log, asJSON bool) error {
+	if asJSON {
+		if entries == nil {
+			entries = []sealing.Log{}
+		}
+		return printJSON(w, entries)
+	}
+
+	tw := tabwriter.NewWriter(w, 2, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "Time\tEvent\tFrom\tTo\tEpoch\tMessage CID\tDetails")
+	for _, l := range entries {
+		details := l.Message
+		if l.Error != "" {
+			details = l.Error
+		}
+		details = strings.SplitN(details, "\n", 2)[0]
+
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%d\t%s\t%s\n", timeStr(l.Timestamp), l.Event, l.From, l.To, l.Epoch, cidStr(l.MessageCid), details)
+	}
+	return tw.Flush()
+}
+
+// printReplay prints fields which differ between the stored sector and the
+// sector rebuilt from its log
+func printReplay(w io.Writer, rep *sealing.SectorReplay, asJSON bool) error {
+	if asJSON {
+		diffs := rep.Diffs
+		if diffs == nil {
+			diffs = []sealing.SectorFieldDiff{}
+		}
+		return printJSON(w, diffs)
+	}
+
+	if len(rep.Diffs) == 0 {
+		fmt.Fprintf(w, "replayed %d log entries, state %s matches\n", len(rep.Replayed.Log), rep.Replayed.State)
+		return nil
+	}
+
+	tw := tabwriter.NewWriter(w, 2, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "Field\tStored\tReplayed")
+	for _, d := range rep.Diffs {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", d.Field, d.Stored, d.Replayed)
+	}
+	return tw.Flush()
+}
+
+func printJSON(w io.Writer, v interface{}) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+func cidStr(c *cid.Cid) string {
+	if c == nil {
+		return "-"
+	}
+	return c.String()
+}
+
+func timeStr(ts uint64) string {
+	return time.Unix(int64(ts), 0).Format("2006-01-02 15:04:05")
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/specs-actors/actors/abi"
+
+	sealing "github.com/filecoin-project/storage-fsm"
+)
+
+func putSector(t *testing.T, ds datastore.Datastore, si sealing.SectorInfo) {
+	var buf bytes.Buffer
+	require.NoError(t, si.MarshalCBOR(&buf))
+	require.NoError(t, ds.Put(datastore.NewKey("/miner/sectors").ChildString(si.SectorNumber.String()), buf.Bytes()))
+}
+
+func TestInspect(t *testing.T) {
+	ds := datastore.NewMapDatastore()
+	prefix := datastore.NewKey("/miner")
+
+	commP, err := cid.Parse("bafy2bzacea3wsdh6y3a36tb3skempjoxqpuyompjbmfeyf34fi3uy6uue42v4")
+	require.NoError(t, err)
+
+	deal := abi.DealID(7)
+	putSector(t, ds, sealing.SectorInfo{
+		State:        sealing.WaitSeed,
+		SectorNumber: 12,
+		Pieces:       []sealing.Piece{{DealID: &deal, Size: 1016, CommP: commP}},
+
+		TicketValue:    abi.SealRandomness{1},
+		TicketEpoch:    345,
+		TicketDeadline: 3345,
+
+		Log: []sealing.Log{
+			{Timestamp: 1, Event: "SectorStart", To: sealing.Packing},
+			{Timestamp: 2, Event: "SectorPacked", From: sealing.Packing, To: sealing.PreCommit1},
+		},
+	})
+	putSector(t, ds, sealing.SectorInfo{State: sealing.Proving, SectorNumber: 3})
+	putSector(t, ds, sealing.SectorInfo{State: sealing.PreCommit2, SectorNumber: 14, Queued: true})
+
+	sectors, err := loadSectors(ds, prefix)
+	require.NoError(t, err)
+	require.Len(t, sectors, 3)
+	require.Equal(t, abi.SectorNumber(3), sectors[0].SectorNumber)
+	require.Equal(t, abi.SectorNumber(12), sectors[1].SectorNumber)
+
+	var out bytes.Buffer
+	require.NoError(t, inspect(&out, ds, prefix, nil, []string{"list"}, false))
+	require.Contains(t, out.String(), "WaitSeed")
+	require.Contains(t, out.String(), "[7]")
+	require.Contains(t, out.String(), "PreCommit2 (queued)")
+
+	out.Reset()
+	require.NoError(t, inspect(&out, ds, prefix, nil, []string{"log", "12"}, true))
+	var entries []sealing.Log
+	require.NoError(t, json.Unmarshal(out.Bytes(), &entries))
+	require.Len(t, entries, 2)
+	require.Equal(t, sealing.PreCommit1, entries[1].To)
+
+	out.Reset()
+	require.NoError(t, inspect(&out, ds, prefix, nil, []string{"show", "12"}, false))
+	require.Contains(t, out.String(), "SectorPacked")
+	require.Regexp(t, `Ticket deadline:\s+3345`, out.String())
+
+	require.Error(t, inspect(&out, ds, prefix, nil, []string{"show", "5"}, false))
+
+	// sector 3 has no log to replay
+	out.Reset()
+	require.NoError(t, inspect(&out, ds, prefix, nil, []string{"replay", "3"}, true))
+	var diffs []sealing.SectorFieldDiff
+	require.NoError(t, json.Unmarshal(out.Bytes(), &diffs))
+	require.Equal(t, []sealing.SectorFieldDiff{
+		{Field: "State", Stored: `"Proving"`, Replayed: `""`},
+		{Field: "SectorNumber", Stored: `3`, Replayed: `0`},
+	}, diffs)
+
+	out.Reset()
+	require.Error(t, inspect(&out, ds, prefix, nil, []string{"export"}, false))
+	blobs := sealing.NewDatastoreBlobStore(datastore.NewMapDatastore())
+	require.NoError(t, inspect(&out, ds, prefix, blobs, []string{"export"}, false))
+	exported, err := sealing.ReadSectorExport(&out)
+	require.NoError(t, err)
+	require.Len(t, exported.Sectors, 3)
+}
@@ -0,0 +1,284 @@
+package sealing
+
+import (
+	"bytes"
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/namespace"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+	sectorstorage "github.com/filecoin-project/sector-storage"
+	"github.com/filecoin-project/sector-storage/ffiwrapper"
+)
+
+// MinersPrefix is the namespace a Manager keeps miner stores in. A miner
+// store holds what a Sealing instance keeps at the root of its datastore
+const MinersPrefix = "/miners"
+
+// MinerNamespace returns the datastore namespace of a miner hosted by a
+// Manager, e.g. to inspect it with `inspect -prefix`
+func MinerNamespace(maddr address.Address) datastore.Key {
+	return datastore.NewKey(MinersPrefix).ChildString(maddr.String())
+}
+
+// MinerConfig configures a miner hosted by a Manager
+type MinerConfig struct {
+	Miner  address.Address
+	Worker address.Address
+
+	Sealer sectorstorage.SectorManager
+	Verif  ffiwrapper.Verifier
+
+	// Counter defaults to a StoredCounter in the miner store
+	Counter SectorIDCounter
+	// TktFn defaults to drawing tickets from the chain, see New
+	TktFn   TicketFn
+	Release DealReleaseFn
+	// Blobs defaults to an FSBlobStore in BlobDir, one of them must be set.
+	// Miners can't share a blob store, each deletes blobs its sectors don't
+	// reference
+	Blobs   BlobStore
+	BlobDir string
+}
+
+// Manager hosts Sealing instances for several miners in one process. They
+// share the chain API and events, and keep state in their own namespace of
+// one datastore, see MinerNamespace
+type Manager struct {
+	api    SealingAPI
+	events Events
+	ds     datastore.Batching
+
+	lk      sync.Mutex
+	miners  map[address.Address]*Sealing
+	running context.Context // set by Run, miners added later are started
+	stopped bool            // Sealing instances can't be restarted, see Run
+}
+
+func NewManager(api SealingAPI, events Events, ds datastore.Batching) *Manager {
+	return &Manager{
+		api:    api,
+		events: events,
+		ds:     ds,
+
+		miners: map[address.Address]*Sealing{},
+	}
+}
+
+// AddMiner creates a Sealing instance for a miner. If the manager is running,
+// the miner is started
+func (mg *Manager) AddMiner(cfg MinerConfig) (*Sealing, error) {
+	mg.lk.Lock()
+	defer mg.lk.Unlock()
+
+	if _, ok := mg.miners[cfg.Miner]; ok {
+		return nil, xerrors.Errorf("miner %s already added", cfg.Miner)
+	}
+
+	ds := namespace.Wrap(mg.ds, MinerNamespace(cfg.Miner))
+
+	sc := cfg.Counter
+	if sc == nil {
+		sc = NewStoredCounter(ds)
+	}
+
+	blobs := cfg.Blobs
+	if blobs == nil {
+		if cfg.BlobDir == "" {
+			return nil, xerrors.Errorf("miner %s has no BlobStore or BlobDir", cfg.Miner)
+		}
+
+		var err error
+		blobs, err = NewFSBlobStore(cfg.BlobDir)
+		if err != nil {
+			return nil, xerrors.Errorf("creating blob store of miner %s: %w", cfg.Miner, err)
+		}
+	}
+
+	m := New(mg.api, mg.events, cfg.Miner, cfg.Worker, ds, cfg.Sealer, sc, cfg.Verif, cfg.TktFn, cfg.Release, blobs)
+	if mg.running != nil {
+		if err := m.Run(mg.running); err != nil {
+			return nil, xerrors.Errorf("starting miner %s: %w", cfg.Miner, err)
+		}
+	}
+
+	mg.miners[cfg.Miner] = m
+	return m, nil
+}
+
+// RemoveMiner stops a miner, and stops hosting it. Its store is kept
+func (mg *Manager) RemoveMiner(ctx context.Context, maddr address.Address) error {
+	mg.lk.Lock()
+	m, ok := mg.miners[maddr]
+	delete(mg.miners, maddr)
+	running := mg.running != nil
+	mg.lk.Unlock()
+
+	if !ok {
+		return xerrors.Errorf("miner %s not found", maddr)
+	}
+	if !running {
+		return nil
+	}
+
+	if err := m.Stop(ctx); err != nil {
+		return xerrors.Errorf("stopping miner %s: %w", maddr, err)
+	}
+	return nil
+}
+
+// Miner returns the Sealing instance of a hosted miner
+func (mg *Manager) Miner(maddr address.Address) (*Sealing, bool) {
+	mg.lk.Lock()
+	defer mg.lk.Unlock()
+
+	m, ok := mg.miners[maddr]
+	return m, ok
+}
+
+// Miners returns addresses of hosted miners, in address order
+func (mg *Manager) Miners() []address.Address {
+	mg.lk.Lock()
+	defer mg.lk.Unlock()
+
+	return mg.sortedMiners()
+}
+
+// sortedMiners must be called with lk held
+func (mg *Manager) sortedMiners() []address.Address {
+	out := make([]address.Address, 0, len(mg.miners))
+	for maddr := range mg.miners {
+		out = append(out, maddr)
+	}
+	sort.Slice(out, func(i, j int) bool { return bytes.Compare(out[i].Bytes(), out[j].Bytes()) < 0 })
+	return out
+}
+
+// Run starts all hosted miners, and miners added later. A manager runs once,
+// stopped Sealing instances can't be started again, so Run fails after Stop.
+// If a miner fails to start, miners started before it are stopped
+func (mg *Manager) Run(ctx context.Context) error {
+	mg.lk.Lock()
+	defer mg.lk.Unlock()
+
+	if mg.running != nil {
+		return xerrors.New("manager already running")
+	}
+	if mg.stopped {
+		return xerrors.New("manager was stopped, create a new one")
+	}
+
+	var started []address.Address
+	for _, maddr := range mg.sortedMiners() {
+		err := mg.miners[maddr].Run(ctx)
+		// the miner may have started some sectors before failing
+		started = append(started, maddr)
+		if err != nil {
+			mg.stopped = true
+			mg.stopMiners(started)
+			return xerrors.Errorf("starting miner %s: %w", maddr, err)
+		}
+	}
+
+	mg.running = ctx
+	return nil
+}
+
+// stopMiners stops miners which were started by a failed Run, it must be
+// called with lk held
+func (mg *Manager) stopMiners(miners []address.Address) {
+	ctx, cancel := context.WithTimeout(context.Background(), stopGrace)
+	defer cancel()
+
+	for _, maddr := range miners {
+		if err := mg.miners[maddr].Stop(ctx); err != nil {
+			log.Errorf("stopping miner %s: %+v", maddr, err)
+		}
+	}
+}
+
+// Stop stops all hosted miners, see Sealing.Stop. All miners are stopped even
+// if some of them fail to
+func (mg *Manager) Stop(ctx context.Context) error {
+	mg.lk.Lock()
+	miners := make([]*Sealing, 0, len(mg.miners))
+	for _, maddr := range mg.sortedMiners() {
+		miners = append(miners, mg.miners[maddr])
+	}
+	mg.running = nil
+	mg.stopped = true
+	mg.lk.Unlock()
+
+	// miners are stopped without holding lk, so stats and sector lists can be
+	// read while they shut down
+	var stopErr error
+	for _, m := range miners {
+		if err := m.Stop(ctx); err != nil && stopErr == nil {
+			stopErr = xerrors.Errorf("stopping miner %s: %w", m.Address(), err)
+		}
+	}
+
+	return stopErr
+}
+
+// MinerSector is a sector of a hosted miner
+type MinerSector struct {
+	Miner address.Address
+	SectorInfo
+}
+
+// ListSectors lists sectors of all hosted miners, in miner order
+func (mg *Manager) ListSectors() ([]MinerSector, error) {
+	mg.lk.Lock()
+	defer mg.lk.Unlock()
+
+	var out []MinerSector
+	for _, maddr := range mg.sortedMiners() {
+		sectors, err := mg.miners[maddr].ListSectors()
+		if err != nil {
+			return nil, xerrors.Errorf("listing sectors of miner %s: %w", maddr, err)
+		}
+
+		sort.Slice(sectors, func(i, j int) bool { return sectors[i].SectorNumber < sectors[j].SectorNumber })
+		for _, si := range sectors {
+			out = append(out, MinerSector{Miner: maddr, SectorInfo: si})
+		}
+	}
+	return out, nil
+}
+
+// MinerStats summarizes the sectors of a hosted miner
+type MinerStats struct {
+	Sectors int
+	States  map[SectorState]int
+	Stages  map[SectorState]StageStatus
+}
+
+// Stats returns sector stats of all hosted miners
+func (mg *Manager) Stats() (map[address.Address]MinerStats, error) {
+	mg.lk.Lock()
+	defer mg.lk.Unlock()
+
+	out := map[address.Address]MinerStats{}
+	for maddr, m := range mg.miners {
+		sectors, err := m.ListSectors()
+		if err != nil {
+			return nil, xerrors.Errorf("listing sectors of miner %s: %w", maddr, err)
+		}
+
+		st := MinerStats{
+			Sectors: len(sectors),
+			States:  map[SectorState]int{},
+			Stages:  m.StageStatus(),
+		}
+		for _, si := range sectors {
+			st.States[si.State]++
+		}
+		out[maddr] = st
+	}
+	return out, nil
+}
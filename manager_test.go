@@ -0,0 +1,217 @@
+package sealing_test
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/namespace"
+	dssync "github.com/ipfs/go-datastore/sync"
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/specs-actors/actors/abi"
+
+	sealing "github.com/filecoin-project/storage-fsm"
+	"github.com/filecoin-project/storage-fsm/mock"
+)
+
+func TestManagerMiners(t *testing.T) {
+	maddrA, err := address.NewIDAddress(1000)
+	require.NoError(t, err)
+	maddrB, err := address.NewIDAddress(1001)
+	require.NoError(t, err)
+
+	chain := mock.NewChain()
+	chain.AddMiner(maddrA, testSectorSize)
+	chain.AddMiner(maddrB, testSectorSize)
+
+	// one sector manager can seal for both, sectors are keyed by miner
+	ds := dssync.MutexWrap(datastore.NewMapDatastore())
+	sm := mock.NewSectorMgr(testSectorSize)
+	mg := sealing.NewManager(chain, chain, ds)
+
+	dir, err := ioutil.TempDir("", "manager")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir) // nolint: errcheck
+
+	cfg := func(maddr address.Address) sealing.MinerConfig {
+		return sealing.MinerConfig{Miner: maddr, Worker: maddr, Sealer: sm, Verif: mock.NewVerifier(), BlobDir: filepath.Join(dir, maddr.String())}
+	}
+
+	// miners need somewhere to keep blobs
+	noBlobs := cfg(maddrA)
+	noBlobs.BlobDir = ""
+	_, err = mg.AddMiner(noBlobs)
+	require.Error(t, err)
+
+	a, err := mg.AddMiner(cfg(maddrA))
+	require.NoError(t, err)
+	_, err = mg.AddMiner(cfg(maddrA))
+	require.Error(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	require.NoError(t, mg.Run(ctx))
+	go chain.Mine(ctx, time.Millisecond)
+
+	defer func() {
+		sctx, scancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer scancel()
+		require.NoError(t, mg.Stop(sctx))
+	}()
+
+	// added while running, so it's started
+	b, err := mg.AddMiner(cfg(maddrB))
+	require.NoError(t, err)
+	require.Equal(t, []address.Address{maddrA, maddrB}, mg.Miners())
+
+	sidA, err := a.PledgeSectorContext(ctx)
+	require.NoError(t, err)
+	sidB, err := b.PledgeSectorContext(ctx)
+	require.NoError(t, err)
+
+	// counters are per miner
+	require.Equal(t, abi.SectorNumber(0), sidA)
+	require.Equal(t, abi.SectorNumber(0), sidB)
+
+	waitSectorState(t, a, sidA, sealing.Proving)
+	waitSectorState(t, b, sidB, sealing.Proving)
+	require.Contains(t, chain.ProvenSectors(maddrA), sidA)
+	require.Contains(t, chain.ProvenSectors(maddrB), sidB)
+
+	for _, maddr := range []address.Address{maddrA, maddrB} {
+		has, err := ds.Has(sealing.MinerNamespace(maddr).ChildString(sealing.SectorStorePrefix).ChildString("0"))
+		require.NoError(t, err)
+		require.True(t, has, "sector of %s not in its namespace", maddr)
+	}
+
+	sectors, err := mg.ListSectors()
+	require.NoError(t, err)
+	require.Len(t, sectors, 2)
+	require.Equal(t, maddrA, sectors[0].Miner)
+	require.Equal(t, maddrB, sectors[1].Miner)
+
+	stats, err := mg.Stats()
+	require.NoError(t, err)
+	require.Equal(t, 1, stats[maddrB].Sectors)
+	require.Equal(t, map[sealing.SectorState]int{sealing.Proving: 1}, stats[maddrB].States)
+
+	sctx, scancel := context.WithTimeout(ctx, 5*time.Second)
+	defer scancel()
+	require.NoError(t, mg.RemoveMiner(sctx, maddrB))
+	require.Equal(t, []address.Address{maddrA}, mg.Miners())
+	_, ok := mg.Miner(maddrB)
+	require.False(t, ok)
+}
+
+func newTestManager(t *testing.T, miners ...address.Address) (*sealing.Manager, *mock.Chain, *mock.SectorMgr, datastore.Batching) {
+	chain := mock.NewChain()
+	ds := dssync.MutexWrap(datastore.NewMapDatastore())
+	sm := mock.NewSectorMgr(testSectorSize)
+	mg := sealing.NewManager(chain, chain, ds)
+
+	for _, maddr := range miners {
+		chain.AddMiner(maddr, testSectorSize)
+		blobs := sealing.NewDatastoreBlobStore(namespace.Wrap(ds, sealing.MinerNamespace(maddr).ChildString(sealing.BlobStorePrefix)))
+		_, err := mg.AddMiner(sealing.MinerConfig{Miner: maddr, Worker: maddr, Sealer: sm, Verif: mock.NewVerifier(), Blobs: blobs})
+		require.NoError(t, err)
+	}
+
+	return mg, chain, sm, ds
+}
+
+func TestManagerRunFails(t *testing.T) {
+	maddrA, err := address.NewIDAddress(1000)
+	require.NoError(t, err)
+	maddrB, err := address.NewIDAddress(1001)
+	require.NoError(t, err)
+
+	mg, _, _, ds := newTestManager(t, maddrA, maddrB)
+
+	// A has a running sector, so there is a state machine to stop
+	var buf bytes.Buffer
+	require.NoError(t, (&sealing.SectorInfo{Version: sealing.SectorInfoVersion, State: sealing.Proving, SectorNumber: 1}).MarshalCBOR(&buf))
+	require.NoError(t, ds.Put(sealing.MinerNamespace(maddrA).ChildString(sealing.SectorStorePrefix).ChildString("1"), buf.Bytes()))
+
+	// B has a record which can't be migrated, so it fails to start after A
+	require.NoError(t, ds.Put(sealing.MinerNamespace(maddrB).ChildString(sealing.SectorStorePrefix).ChildString("0"), []byte{0xff}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	require.Error(t, mg.Run(ctx))
+
+	// A was stopped
+	a, ok := mg.Miner(maddrA)
+	require.True(t, ok)
+	_, err = a.PledgeSectorContext(ctx)
+	require.Error(t, err)
+
+	require.Error(t, mg.Run(ctx))
+
+	// A's sectors were stopped when Run failed, stopping again does nothing
+	require.NoError(t, mg.Stop(ctx))
+	require.NoError(t, mg.Stop(ctx))
+}
+
+func TestManagerRunAfterStop(t *testing.T) {
+	maddr, err := address.NewIDAddress(1000)
+	require.NoError(t, err)
+
+	mg, _, _, _ := newTestManager(t, maddr)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	require.NoError(t, mg.Run(ctx))
+	require.NoError(t, mg.Stop(ctx))
+	require.NoError(t, mg.Stop(ctx))
+
+	// stopped instances wouldn't do anything
+	require.Error(t, mg.Run(ctx))
+}
+
+func TestManagerStopUnlocked(t *testing.T) {
+	maddr, err := address.NewIDAddress(1000)
+	require.NoError(t, err)
+
+	mg, chain, sm, _ := newTestManager(t, maddr)
+	sm.Inject(mock.StepPreCommit1, mock.Fault{Delay: 500 * time.Millisecond})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	require.NoError(t, mg.Run(ctx))
+	go chain.Mine(ctx, time.Millisecond)
+
+	m, _ := mg.Miner(maddr)
+	sid, err := m.PledgeSectorContext(ctx)
+	require.NoError(t, err)
+	waitSectorState(t, m, sid, sealing.PreCommit1)
+	for sm.Calls(mock.StepPreCommit1) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	stopped := make(chan error)
+	go func() {
+		sctx, scancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer scancel()
+		stopped <- mg.Stop(sctx)
+	}()
+
+	// the manager can be queried while miners wait for handlers
+	time.Sleep(50 * time.Millisecond)
+	_, err = mg.Stats()
+	require.NoError(t, err)
+
+	select {
+	case err := <-stopped:
+		t.Fatalf("Stop returned before the PreCommit1 handler: %v", err)
+	default:
+	}
+
+	require.NoError(t, <-stopped)
+}
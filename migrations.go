@@ -0,0 +1,317 @@
+package sealing
+
+import (
+	"bytes"
+	"context"
+	"io"
+
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	cbg "github.com/whyrusleeping/cbor-gen"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/specs-actors/actors/abi"
+)
+
+// SectorInfoVersion is the schema version of SectorInfo records written by
+// this package. Records without a Version field are version 0
+const SectorInfoVersion = 3
+
+// sectorMigrations[v] upgrades a record from version v to v+1. Migrations
+// work on the raw record, so they can handle fields SectorInfo no longer has
+var sectorMigrations = []func(rec *sectorRecord, blobs BlobStore) error{
+	// 0 -> 1: the unused Nonce field was removed, TicketDeadline is stored
+	// with the ticket
+	func(rec *sectorRecord, blobs BlobStore) error {
+		rec.remove("Nonce")
+
+		tkt, ok := rec.fields["TicketValue"]
+		if !ok {
+			return nil
+		}
+		ticket, err := cbg.ReadByteArray(bytes.NewReader(tkt.Raw), cbg.ByteArrayMaxLen)
+		if err != nil {
+			return xerrors.Errorf("reading field %q: %w", "TicketValue", err)
+		}
+		if len(ticket) == 0 {
+			return nil
+		}
+
+		epoch, err := rec.int64("TicketEpoch")
+		if err != nil {
+			return err
+		}
+
+		var buf bytes.Buffer
+		if err := writeInt64(&buf, int64(ticketDeadline(abi.ChainEpoch(epoch)))); err != nil {
+			return err
+		}
+		rec.set("TicketDeadline", buf.Bytes())
+		return nil
+	},
+	// 1 -> 2: Log entries have a Batch, older entries are left without one
+	func(rec *sectorRecord, blobs BlobStore) error {
+		return nil
+	},
+	// 2 -> 3: PreCommit1Out and Proof were moved to the BlobStore
+	func(rec *sectorRecord, blobs BlobStore) error {
+		if err := rec.moveToBlob("PreCommit1Out", "PreCommit1OutRef", blobs); err != nil {
+			return err
+		}
+		return rec.moveToBlob("Proof", "ProofRef", blobs)
+	},
+}
+
+// sectorRecord is a stored SectorInfo CBOR map, with raw field values
+type sectorRecord struct {
+	keys   []string
+	fields map[string]*cbg.Deferred
+}
+
+func decodeSectorRecord(r io.Reader) (*sectorRecord, error) {
+	maj, n, err := cbg.CborReadHeader(r)
+	if err != nil {
+		return nil, err
+	}
+	if maj != cbg.MajMap {
+		return nil, xerrors.Errorf("sector record should be a map, got major type %d", maj)
+	}
+
+	rec := &sectorRecord{fields: map[string]*cbg.Deferred{}}
+	for i := uint64(0); i < n; i++ {
+		key, err := cbg.ReadString(r)
+		if err != nil {
+			return nil, xerrors.Errorf("reading field %d name: %w", i, err)
+		}
+
+		val := new(cbg.Deferred)
+		if err := val.UnmarshalCBOR(r); err != nil {
+			return nil, xerrors.Errorf("reading field %q: %w", key, err)
+		}
+
+		if _, ok := rec.fields[key]; ok {
+			return nil, xerrors.Errorf("duplicate field %q", key)
+		}
+		rec.keys = append(rec.keys, key)
+		rec.fields[key] = val
+	}
+
+	return rec, nil
+}
+
+func (rec *sectorRecord) marshal(w io.Writer) error {
+	if err := cbg.CborWriteHeader(w, cbg.MajMap, uint64(len(rec.keys))); err != nil {
+		return err
+	}
+
+	for _, key := range rec.keys {
+		if err := cbg.CborWriteHeader(w, cbg.MajTextString, uint64(len(key))); err != nil {
+			return err
+		}
+		if _, err := io.WriteString(w, key); err != nil {
+			return err
+		}
+		if err := rec.fields[key].MarshalCBOR(w); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// set replaces a field value, or adds the field at the start of the record
+func (rec *sectorRecord) set(key string, raw []byte) {
+	if _, ok := rec.fields[key]; !ok {
+		rec.keys = append([]string{key}, rec.keys...)
+	}
+	rec.fields[key] = &cbg.Deferred{Raw: raw}
+}
+
+func (rec *sectorRecord) remove(key string) {
+	if _, ok := rec.fields[key]; !ok {
+		return
+	}
+	delete(rec.fields, key)
+
+	for i, k := range rec.keys {
+		if k == key {
+			rec.keys = append(rec.keys[:i], rec.keys[i+1:]...)
+			break
+		}
+	}
+}
+
+// moveToBlob stores the byte string in field from as a blob, and replaces the
+// field with field to, holding the blob reference. Empty values are dropped
+func (rec *sectorRecord) moveToBlob(from, to string, blobs BlobStore) error {
+	v, ok := rec.fields[from]
+	if !ok {
+		return nil
+	}
+	rec.remove(from)
+
+	data, err := cbg.ReadByteArray(bytes.NewReader(v.Raw), cbg.ByteArrayMaxLen)
+	if err != nil {
+		return xerrors.Errorf("reading field %q: %w", from, err)
+	}
+	if len(data) == 0 {
+		return nil
+	}
+
+	ref, err := blobs.Put(context.TODO(), data)
+	if err != nil {
+		return xerrors.Errorf("storing field %q as a blob: %w", from, err)
+	}
+
+	var buf bytes.Buffer
+	if err := cbg.WriteCid(&buf, ref); err != nil {
+		return err
+	}
+	rec.set(to, buf.Bytes())
+	return nil
+}
+
+// int64 reads a signed integer field, missing fields are 0
+func (rec *sectorRecord) int64(key string) (int64, error) {
+	v, ok := rec.fields[key]
+	if !ok {
+		return 0, nil
+	}
+
+	maj, extra, err := cbg.CborReadHeader(bytes.NewReader(v.Raw))
+	if err != nil {
+		return 0, xerrors.Errorf("reading field %q: %w", key, err)
+	}
+	switch maj {
+	case cbg.MajUnsignedInt:
+		return int64(extra), nil
+	case cbg.MajNegativeInt:
+		return -1 - int64(extra), nil
+	default:
+		return 0, xerrors.Errorf("wrong type for field %q", key)
+	}
+}
+
+// writeInt64 encodes a signed integer the way cbor-gen does
+func writeInt64(w io.Writer, v int64) error {
+	if v >= 0 {
+		return cbg.CborWriteHeader(w, cbg.MajUnsignedInt, uint64(v))
+	}
+	return cbg.CborWriteHeader(w, cbg.MajNegativeInt, uint64(-v)-1)
+}
+
+func (rec *sectorRecord) version() (uint64, error) {
+	v, ok := rec.fields["Version"]
+	if !ok {
+		return 0, nil
+	}
+
+	maj, extra, err := cbg.CborReadHeader(bytes.NewReader(v.Raw))
+	if err != nil {
+		return 0, err
+	}
+	if maj != cbg.MajUnsignedInt {
+		return 0, xerrors.Errorf("wrong type for Version field")
+	}
+	return extra, nil
+}
+
+// migrateSectorRecord upgrades an encoded SectorInfo to SectorInfoVersion,
+// moving inline data to blobs. It returns the version the record had, and raw
+// unchanged if it's current
+func migrateSectorRecord(raw []byte, blobs BlobStore) ([]byte, uint64, error) {
+	rec, err := decodeSectorRecord(bytes.NewReader(raw))
+	if err != nil {
+		return nil, 0, xerrors.Errorf("decoding sector record: %w", err)
+	}
+
+	from, err := rec.version()
+	if err != nil {
+		return nil, 0, xerrors.Errorf("reading sector record version: %w", err)
+	}
+	if from > SectorInfoVersion {
+		return nil, from, xerrors.Errorf("sector record version %d is newer than supported version %d", from, SectorInfoVersion)
+	}
+	if from == SectorInfoVersion {
+		return raw, from, nil
+	}
+
+	for v := from; v < SectorInfoVersion; v++ {
+		if err := sectorMigrations[v](rec, blobs); err != nil {
+			return nil, from, xerrors.Errorf("migrating sector record from version %d: %w", v, err)
+		}
+		rec.set("Version", cbg.CborEncodeMajorType(cbg.MajUnsignedInt, v+1))
+	}
+
+	var buf bytes.Buffer
+	if err := rec.marshal(&buf); err != nil {
+		return nil, from, xerrors.Errorf("encoding migrated sector record: %w", err)
+	}
+	return buf.Bytes(), from, nil
+}
+
+// DecodeSectorInfo decodes a stored SectorInfo record of any schema version.
+// Records from before blobs were moved to the BlobStore reference blobs which
+// aren't stored; Run moves them to the store
+func DecodeSectorInfo(raw []byte) (SectorInfo, error) {
+	var si SectorInfo
+
+	raw, _, err := migrateSectorRecord(raw, refOnlyBlobs{})
+	if err != nil {
+		return si, err
+	}
+
+	if err := si.UnmarshalCBOR(bytes.NewReader(raw)); err != nil {
+		return si, err
+	}
+	return si, nil
+}
+
+// migrateSectors upgrades records in the sector store of ds to
+// SectorInfoVersion. No records are written if any record can't be upgraded.
+// Blobs moved out of records are stored before the records are committed. If
+// committing fails, the old records still reference them by content, and
+// sweepBlobs deletes them if those records go away before the next migration
+func migrateSectors(ds datastore.Batching, blobs BlobStore) error {
+	res, err := ds.Query(query.Query{Prefix: SectorStorePrefix})
+	if err != nil {
+		return xerrors.Errorf("querying sectors: %w", err)
+	}
+	defer res.Close() // nolint: errcheck
+
+	batch, err := ds.Batch()
+	if err != nil {
+		return xerrors.Errorf("creating batch: %w", err)
+	}
+
+	migrated := 0
+	for r := range res.Next() {
+		if r.Error != nil {
+			return xerrors.Errorf("reading sectors: %w", r.Error)
+		}
+
+		out, from, err := migrateSectorRecord(r.Value, blobs)
+		if err != nil {
+			return xerrors.Errorf("sector record %s: %w", r.Key, err)
+		}
+		if from == SectorInfoVersion {
+			continue
+		}
+
+		if err := batch.Put(datastore.NewKey(r.Key), out); err != nil {
+			return xerrors.Errorf("storing migrated sector record %s: %w", r.Key, err)
+		}
+		migrated++
+	}
+
+	if migrated == 0 {
+		return nil
+	}
+
+	if err := batch.Commit(); err != nil {
+		return xerrors.Errorf("committing migrated sector records: %w", err)
+	}
+
+	log.Infof("migrated %d sector records to version %d", migrated, SectorInfoVersion)
+	return nil
+}
@@ -0,0 +1,264 @@
+package sealing
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	dssync "github.com/ipfs/go-datastore/sync"
+	"github.com/stretchr/testify/require"
+	cbg "github.com/whyrusleeping/cbor-gen"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/specs-actors/actors/abi"
+	"github.com/filecoin-project/specs-actors/actors/builtin"
+)
+
+// goldenV0Sector is the sector encoded in testdata/sectorinfo-v0*.cbor,
+// decoded at SectorInfoVersion
+func goldenV0Sector() SectorInfo {
+	d := abi.DealID(1234)
+	commD := builtin.StorageMarketActorCodeID
+	commR := builtin.StorageMinerActorCodeID
+	msg := builtin.AccountActorCodeID
+
+	return SectorInfo{
+		Version:      SectorInfoVersion,
+		State:        WaitSeed,
+		SectorNumber: 234,
+		SectorType:   abi.RegisteredProof_StackedDRG2KiBSeal,
+		Pieces: []Piece{
+			{DealID: &d, Size: 1016, CommP: builtin.PaymentChannelActorCodeID},
+		},
+		TicketValue:      abi.SealRandomness{87, 78, 7, 87},
+		TicketEpoch:      345,
+		TicketDeadline:   ticketDeadline(345), // computed when migrating to v1
+		PreCommit1OutRef: mustBlobRef([]byte{1, 2, 3, 4}),
+		CommD:            &commD,
+		CommR:            &commR,
+		PreCommitMessage: &msg,
+		SeedValue:        abi.InteractiveSealRandomness{},
+		LastErr:          "hi",
+		Log: []Log{
+			{Timestamp: 1588000000, Message: "{\"User\":{}}", Kind: "event;sealing.SectorPreCommitted"},
+		},
+	}
+}
+
+func TestSectorMigrationsComplete(t *testing.T) {
+	require.Len(t, sectorMigrations, SectorInfoVersion)
+}
+
+func TestMigrateGoldenV0(t *testing.T) {
+	withLog := goldenV0Sector()
+	msg := builtin.AccountActorCodeID
+	withLog.Priority = 5
+	withLog.Paused = true
+	withLog.Overrides = []StateOverride{{Timestamp: 1588000001, From: PreCommitFailed, To: WaitSeed, Reason: "message landed"}}
+	withLog.Log[0].Event = "SectorPreCommitted"
+	withLog.Log[0].From = PreCommitting
+	withLog.Log[0].To = WaitSeed
+	withLog.Log[0].MessageCid = &msg
+
+	for file, expected := range map[string]SectorInfo{
+		"sectorinfo-v0.cbor":     goldenV0Sector(),
+		"sectorinfo-v0-log.cbor": withLog,
+	} {
+		t.Run(file, func(t *testing.T) {
+			raw, err := ioutil.ReadFile(filepath.Join("testdata", file))
+			require.NoError(t, err)
+
+			// v0 records have the Nonce field
+			var si SectorInfo
+			require.Error(t, si.UnmarshalCBOR(bytes.NewReader(raw)))
+
+			si, err = DecodeSectorInfo(raw)
+			require.NoError(t, err)
+			require.Equal(t, expected, si)
+
+			ds := datastore.NewMapDatastore()
+			blobs := NewDatastoreBlobStore(datastore.NewMapDatastore())
+			require.NoError(t, ds.Put(sectorKey(234), raw))
+			require.NoError(t, migrateSectors(ds, blobs))
+
+			migrated, err := ds.Get(sectorKey(234))
+			require.NoError(t, err)
+
+			var direct SectorInfo
+			require.NoError(t, direct.UnmarshalCBOR(bytes.NewReader(migrated)))
+			require.Equal(t, expected, direct)
+
+			// PreCommit1Out was moved to the blob store
+			pc1o, err := blobs.Get(context.Background(), *direct.PreCommit1OutRef)
+			require.NoError(t, err)
+			require.Equal(t, []byte{1, 2, 3, 4}, pc1o)
+
+			// migrated records are left alone
+			require.NoError(t, migrateSectors(ds, blobs))
+			again, err := ds.Get(sectorKey(234))
+			require.NoError(t, err)
+			require.Equal(t, migrated, again)
+		})
+	}
+}
+
+func TestMigrateGoldenV1V2(t *testing.T) {
+	// v1 log entries have no Batch
+	v1 := goldenSector()
+	v1.Log[0].Batch = 0
+
+	for file, expected := range map[string]SectorInfo{
+		"sectorinfo-v1.cbor": v1,
+		"sectorinfo-v2.cbor": goldenSector(),
+	} {
+		raw, err := ioutil.ReadFile(filepath.Join("testdata", file))
+		require.NoError(t, err)
+
+		// blobs were inline, they're referenced by content
+		si, err := DecodeSectorInfo(raw)
+		require.NoError(t, err)
+		require.Equal(t, expected, si, file)
+	}
+}
+
+func TestDecodeLogV1(t *testing.T) {
+	// log entries stored before SectorInfo v2 have no Batch
+	expected := goldenLog()
+	expected.Batch = 0
+
+	raw, err := ioutil.ReadFile(filepath.Join("testdata", "log-v1.cbor"))
+	require.NoError(t, err)
+
+	var l Log
+	require.NoError(t, l.UnmarshalCBOR(bytes.NewReader(raw)))
+	require.Equal(t, expected, l)
+}
+
+func TestMigrateNewerVersion(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, (&SectorInfo{Version: SectorInfoVersion + 1, State: Proving, SectorNumber: 3}).MarshalCBOR(&buf))
+
+	_, err := DecodeSectorInfo(buf.Bytes())
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "newer than supported")
+
+	old, err := ioutil.ReadFile(filepath.Join("testdata", "sectorinfo-v0.cbor"))
+	require.NoError(t, err)
+
+	ds := datastore.NewMapDatastore()
+	require.NoError(t, ds.Put(sectorKey(3), buf.Bytes()))
+	require.NoError(t, ds.Put(sectorKey(234), old))
+	require.Error(t, migrateSectors(ds, refOnlyBlobs{}))
+
+	// nothing is written if any record can't be migrated
+	stored, err := ds.Get(sectorKey(234))
+	require.NoError(t, err)
+	require.Equal(t, old, stored)
+}
+
+// failingBatchDS fails to commit batches
+type failingBatchDS struct {
+	datastore.Batching
+}
+
+func (ds failingBatchDS) Batch() (datastore.Batch, error) {
+	b, err := ds.Batching.Batch()
+	return failingBatch{b}, err
+}
+
+type failingBatch struct {
+	datastore.Batch
+}
+
+func (failingBatch) Commit() error {
+	return xerrors.New("disk full")
+}
+
+func TestMigrateCommitFails(t *testing.T) {
+	raw, err := ioutil.ReadFile(filepath.Join("testdata", "sectorinfo-v0.cbor"))
+	require.NoError(t, err)
+
+	ds := dssync.MutexWrap(datastore.NewMapDatastore())
+	require.NoError(t, ds.Put(sectorKey(234), raw))
+	blobs := dsBlobs(ds)
+
+	require.Error(t, migrateSectors(failingBatchDS{ds}, blobs))
+
+	// the record isn't migrated, the blob moved out of it is left behind
+	stored, err := ds.Get(sectorKey(234))
+	require.NoError(t, err)
+	require.Equal(t, raw, stored)
+
+	ref := *mustBlobRef([]byte{1, 2, 3, 4})
+	_, err = blobs.Get(context.Background(), ref)
+	require.NoError(t, err)
+
+	// old records reference inline blobs by content, so the sweep keeps the
+	// blob until the migration is retried
+	m := &Sealing{ds: ds, blobs: blobs}
+	require.NoError(t, m.sweepBlobs(context.Background()))
+	_, err = blobs.Get(context.Background(), ref)
+	require.NoError(t, err)
+
+	require.NoError(t, migrateSectors(ds, blobs))
+	stored, err = ds.Get(sectorKey(234))
+	require.NoError(t, err)
+	si, err := DecodeSectorInfo(stored)
+	require.NoError(t, err)
+	require.Equal(t, ref, *si.PreCommit1OutRef)
+
+	// once the sector is gone, nothing references it
+	require.NoError(t, ds.Delete(sectorKey(234)))
+	require.NoError(t, m.sweepBlobs(context.Background()))
+	_, err = blobs.Get(context.Background(), ref)
+	require.Error(t, err)
+}
+
+func TestRunMigratesSectors(t *testing.T) {
+	raw, err := ioutil.ReadFile(filepath.Join("testdata", "sectorinfo-v0.cbor"))
+	require.NoError(t, err)
+
+	// the Proving handler only deletes blobs, so it runs without an API
+	rec, err := decodeSectorRecord(bytes.NewReader(raw))
+	require.NoError(t, err)
+	rec.set("State", append(cbg.CborEncodeMajorType(cbg.MajTextString, uint64(len(Proving))), Proving...))
+
+	var buf bytes.Buffer
+	require.NoError(t, rec.marshal(&buf))
+
+	ds := dssync.MutexWrap(datastore.NewMapDatastore())
+	require.NoError(t, ds.Put(sectorKey(234), buf.Bytes()))
+
+	m := New(nil, nil, testMaddr(t), testMaddr(t), ds, nil, nil, nil, nil, nil, dsBlobs(ds))
+	require.NoError(t, m.Run(context.Background()))
+	defer m.Stop(context.Background()) // nolint: errcheck
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		si, err := m.GetSectorInfo(234)
+		require.NoError(t, err)
+		require.Equal(t, Proving, si.State)
+		require.Equal(t, uint64(SectorInfoVersion), si.Version)
+
+		if !si.hasBlobs() {
+			break
+		}
+		require.True(t, time.Now().Before(deadline), "blobs of proving sector weren't removed")
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// PreCommit1Out was moved to the blob store by the migration, and removed
+	_, err = m.blobs.Get(context.Background(), *mustBlobRef([]byte{1, 2, 3, 4}))
+	require.Error(t, err)
+
+	blobs, err := ds.Query(query.Query{Prefix: BlobStorePrefix, KeysOnly: true})
+	require.NoError(t, err)
+	left, err := blobs.Rest()
+	require.NoError(t, err)
+	require.Empty(t, left)
+}
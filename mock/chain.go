@@ -0,0 +1,736 @@
+package mock
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	logging "github.com/ipfs/go-log/v2"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/specs-actors/actors/abi"
+	"github.com/filecoin-project/specs-actors/actors/abi/big"
+	"github.com/filecoin-project/specs-actors/actors/builtin"
+	"github.com/filecoin-project/specs-actors/actors/builtin/market"
+	"github.com/filecoin-project/specs-actors/actors/builtin/miner"
+	"github.com/filecoin-project/specs-actors/actors/crypto"
+	"github.com/filecoin-project/specs-actors/actors/runtime/exitcode"
+
+	sealing "github.com/filecoin-project/storage-fsm"
+)
+
+var log = logging.Logger("sealmock")
+
+var _ sealing.SealingAPI = &Chain{}
+var _ sealing.Events = &Chain{}
+
+// Message is a message sent to the simulated chain
+type Message struct {
+	Cid cid.Cid
+
+	From     address.Address
+	To       address.Address
+	Method   abi.MethodNum
+	Value    big.Int
+	GasPrice big.Int
+	GasLimit int64
+	Params   []byte
+}
+
+// MessageHook decides the exit code of a message included in the chain. Only
+// messages with exitcode.Ok change chain state
+type MessageHook func(msg Message, height abi.ChainEpoch) exitcode.ExitCode
+
+// DataCommitmentFn computes CommD of a sector from its deal pieces
+type DataCommitmentFn func(rt abi.RegisteredProof, pieces []abi.PieceInfo) (cid.Cid, error)
+
+type msgState struct {
+	Message
+
+	lookup *sealing.MsgLookup
+	undo   []func()
+}
+
+type chainAtHandler struct {
+	hnd        sealing.HeightHandler
+	rev        sealing.RevertHandler
+	confidence int
+	h          abi.ChainEpoch
+
+	applied bool
+	tok     sealing.TipSetToken
+}
+
+// Chain is an in-memory chain implementing SealingAPI and Events. Epochs
+// only advance when Advance is called, messages sent are included in the
+// next epoch, and Reorg reverts epochs, along with messages included in them
+type Chain struct {
+	lk sync.Mutex
+
+	height abi.ChainEpoch
+	fork   uint64
+	// salts[h] is the fork the tipset at height h was mined in, it makes
+	// tipset tokens and randomness differ after reorgs
+	salts []uint64
+
+	// closed and replaced on every head change
+	headChange chan struct{}
+
+	msgs    map[cid.Cid]*msgState
+	order   []*msgState
+	pending []*msgState
+
+	hook  MessageHook
+	commD DataCommitmentFn
+
+	sectorSizes map[address.Address]abi.SectorSize
+	precommits  map[address.Address]map[abi.SectorNumber]*miner.SectorPreCommitOnChainInfo
+	proven      map[address.Address]map[abi.SectorNumber]*miner.SectorOnChainInfo
+
+	deals    map[abi.DealID]*dealEntry
+	nextDeal abi.DealID
+
+	objs     map[cid.Cid][]byte
+	balances map[address.Address]big.Int
+
+	handlers []*chainAtHandler
+}
+
+type dealEntry struct {
+	proposal market.DealProposal
+	state    market.DealState
+}
+
+func NewChain() *Chain {
+	return &Chain{
+		salts:      []uint64{0},
+		headChange: make(chan struct{}),
+
+		msgs:  map[cid.Cid]*msgState{},
+		commD: DataCommitment,
+
+		sectorSizes: map[address.Address]abi.SectorSize{},
+		precommits:  map[address.Address]map[abi.SectorNumber]*miner.SectorPreCommitOnChainInfo{},
+		proven:      map[address.Address]map[abi.SectorNumber]*miner.SectorOnChainInfo{},
+
+		deals:    map[abi.DealID]*dealEntry{},
+		objs:     map[cid.Cid][]byte{},
+		balances: map[address.Address]big.Int{},
+	}
+}
+
+// AddMiner registers a miner actor with the given sector size
+func (c *Chain) AddMiner(maddr address.Address, ssize abi.SectorSize) {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+
+	c.sectorSizes[maddr] = ssize
+	c.precommits[maddr] = map[abi.SectorNumber]*miner.SectorPreCommitOnChainInfo{}
+	c.proven[maddr] = map[abi.SectorNumber]*miner.SectorOnChainInfo{}
+}
+
+// AddDeal publishes a storage deal, and returns its ID
+func (c *Chain) AddDeal(proposal market.DealProposal) abi.DealID {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+
+	id := c.nextDeal
+	c.nextDeal++
+
+	c.deals[id] = &dealEntry{
+		proposal: proposal,
+		state: market.DealState{
+			SectorStartEpoch: -1,
+			LastUpdatedEpoch: -1,
+			SlashEpoch:       -1,
+		},
+	}
+
+	return id
+}
+
+// RemoveDeal drops a deal, like the market actor does when a deal expires or
+// is slashed
+func (c *Chain) RemoveDeal(id abi.DealID) {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+
+	delete(c.deals, id)
+}
+
+// SetBalance sets the balance WalletBalance returns for an address
+func (c *Chain) SetBalance(addr address.Address, balance big.Int) {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+
+	c.balances[addr] = balance
+}
+
+// SetMessageHook sets the hook deciding exit codes of included messages. By
+// default all messages succeed
+func (c *Chain) SetMessageHook(hook MessageHook) {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+
+	c.hook = hook
+}
+
+// SetDataCommitment replaces the function computing CommD in
+// StateComputeDataCommitment, DataCommitment is used by default
+func (c *Chain) SetDataCommitment(fn DataCommitmentFn) {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+
+	c.commD = fn
+}
+
+// PutObj stores an object which can be read with ChainReadObj
+func (c *Chain) PutObj(data []byte) cid.Cid {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+
+	k := sum(data)
+	c.objs[k] = data
+	return k
+}
+
+// Height returns the current chain height
+func (c *Chain) Height() abi.ChainEpoch {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+
+	return c.height
+}
+
+// Messages returns all messages sent, in the order they were sent
+func (c *Chain) Messages() []Message {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+
+	out := make([]Message, len(c.order))
+	for i, msg := range c.order {
+		out[i] = msg.Message
+	}
+	return out
+}
+
+// Lookup returns where a message was included, false if it's still pending
+func (c *Chain) Lookup(mcid cid.Cid) (sealing.MsgLookup, bool) {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+
+	msg, ok := c.msgs[mcid]
+	if !ok || msg.lookup == nil {
+		return sealing.MsgLookup{}, false
+	}
+	return *msg.lookup, true
+}
+
+// ProvenSectors returns the sectors of a miner which were prove-committed,
+// along with the epoch they were proven at
+func (c *Chain) ProvenSectors(maddr address.Address) map[abi.SectorNumber]abi.ChainEpoch {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+
+	out := map[abi.SectorNumber]abi.ChainEpoch{}
+	for n, sector := range c.proven[maddr] {
+		out[n] = sector.ActivationEpoch
+	}
+	return out
+}
+
+// Advance mines n epochs. Pending messages are included in the first one,
+// and ChainAt handlers which got enough confidence are called
+func (c *Chain) Advance(ctx context.Context, n int) {
+	for i := 0; i < n; i++ {
+		c.mine(ctx)
+	}
+}
+
+// Mine advances the chain by one epoch every interval until ctx is done
+func (c *Chain) Mine(ctx context.Context, interval time.Duration) {
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+
+	for {
+		select {
+		case <-tick.C:
+			c.mine(ctx)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (c *Chain) mine(ctx context.Context) {
+	c.lk.Lock()
+
+	c.height++
+	c.salts = append(c.salts, c.fork)
+
+	tok := c.tokenAt(c.height)
+	for _, msg := range c.pending {
+		c.include(msg, tok)
+	}
+	c.pending = nil
+
+	apply := c.dueHandlers()
+	height := c.height
+	c.notify()
+
+	c.lk.Unlock()
+
+	for _, h := range apply {
+		if err := h.hnd(ctx, h.tok, height); err != nil {
+			log.Warnf("ChainAt handler for height %d: %+v", h.h, err)
+		}
+	}
+}
+
+// Reorg reverts the last depth epochs. Messages included in them return to
+// the message pool, and are included again with the next mined epoch, which
+// has different randomness
+func (c *Chain) Reorg(ctx context.Context, depth int) error {
+	c.lk.Lock()
+
+	to := c.height - abi.ChainEpoch(depth)
+	if depth <= 0 || to < 0 {
+		c.lk.Unlock()
+		return xerrors.Errorf("can't revert %d epochs at height %d", depth, c.height)
+	}
+
+	var reincluded []*msgState
+	for i := len(c.order) - 1; i >= 0; i-- {
+		msg := c.order[i]
+		if msg.lookup == nil || msg.lookup.Height <= to {
+			continue
+		}
+
+		for j := len(msg.undo) - 1; j >= 0; j-- {
+			msg.undo[j]()
+		}
+		msg.undo = nil
+		msg.lookup = nil
+
+		reincluded = append([]*msgState{msg}, reincluded...)
+	}
+	c.pending = append(reincluded, c.pending...)
+
+	var revert []*chainAtHandler
+	for _, h := range c.handlers {
+		if h.applied && h.h > to {
+			h.applied = false
+			revert = append(revert, h)
+		}
+	}
+
+	c.salts = c.salts[:to+1]
+	c.height = to
+	c.fork++
+	c.notify()
+
+	c.lk.Unlock()
+
+	for _, h := range revert {
+		if err := h.rev(ctx, h.tok); err != nil {
+			log.Warnf("ChainAt revert handler for height %d: %+v", h.h, err)
+		}
+	}
+
+	return nil
+}
+
+func (c *Chain) include(msg *msgState, tok sealing.TipSetToken) {
+	code := exitcode.Ok
+	if c.hook != nil {
+		code = c.hook(msg.Message, c.height)
+	}
+	if code == exitcode.Ok {
+		code = c.apply(msg)
+	}
+
+	msg.lookup = &sealing.MsgLookup{
+		Receipt: sealing.MessageReceipt{
+			ExitCode: code,
+		},
+		TipSetTok: tok,
+		Height:    c.height,
+	}
+}
+
+// apply executes the miner actor methods used in sealing
+func (c *Chain) apply(msg *msgState) exitcode.ExitCode {
+	precommits, ok := c.precommits[msg.To]
+	if !ok {
+		return exitcode.Ok
+	}
+
+	switch msg.Method {
+	case builtin.MethodsMiner.PreCommitSector:
+		var params miner.SectorPreCommitInfo
+		if err := params.UnmarshalCBOR(bytes.NewReader(msg.Params)); err != nil {
+			return exitcode.ErrSerialization
+		}
+
+		if _, ok := precommits[params.SectorNumber]; ok {
+			return exitcode.ErrIllegalArgument
+		}
+		if _, ok := c.proven[msg.To][params.SectorNumber]; ok {
+			return exitcode.ErrIllegalArgument
+		}
+
+		precommits[params.SectorNumber] = &miner.SectorPreCommitOnChainInfo{
+			Info:             params,
+			PreCommitDeposit: big.Zero(),
+			PreCommitEpoch:   c.height,
+		}
+		msg.undo = append(msg.undo, func() {
+			delete(precommits, params.SectorNumber)
+		})
+	case builtin.MethodsMiner.ProveCommitSector:
+		var params miner.ProveCommitSectorParams
+		if err := params.UnmarshalCBOR(bytes.NewReader(msg.Params)); err != nil {
+			return exitcode.ErrSerialization
+		}
+
+		pci, ok := precommits[params.SectorNumber]
+		if !ok {
+			return exitcode.ErrNotFound
+		}
+		if c.height < pci.PreCommitEpoch+miner.PreCommitChallengeDelay {
+			return exitcode.ErrIllegalArgument
+		}
+
+		delete(precommits, params.SectorNumber)
+		c.proven[msg.To][params.SectorNumber] = &miner.SectorOnChainInfo{
+			Info:                  pci.Info,
+			ActivationEpoch:       c.height,
+			DealWeight:            big.Zero(),
+			PledgeRequirement:     big.Zero(),
+			DeclaredFaultEpoch:    -1,
+			DeclaredFaultDuration: -1,
+		}
+		for _, id := range pci.Info.DealIDs {
+			if d, ok := c.deals[id]; ok {
+				d.state.SectorStartEpoch = c.height
+			}
+		}
+
+		msg.undo = append(msg.undo, func() {
+			precommits[params.SectorNumber] = pci
+			delete(c.proven[msg.To], params.SectorNumber)
+			for _, id := range pci.Info.DealIDs {
+				if d, ok := c.deals[id]; ok {
+					d.state.SectorStartEpoch = -1
+				}
+			}
+		})
+	}
+
+	return exitcode.Ok
+}
+
+func (c *Chain) dueHandlers() []*chainAtHandler {
+	var out []*chainAtHandler
+	for _, h := range c.handlers {
+		if h.applied || c.height < h.h+abi.ChainEpoch(h.confidence) {
+			continue
+		}
+
+		h.applied = true
+		h.tok = c.tokenAt(h.h)
+		out = append(out, h)
+	}
+	return out
+}
+
+func (c *Chain) notify() {
+	close(c.headChange)
+	c.headChange = make(chan struct{})
+}
+
+func (c *Chain) tokenAt(h abi.ChainEpoch) sealing.TipSetToken {
+	return sealing.TipSetToken(fmt.Sprintf("%d/%d", h, c.salts[h]))
+}
+
+func (c *Chain) checkToken(tok sealing.TipSetToken) error {
+	var h abi.ChainEpoch
+	var salt uint64
+	if _, err := fmt.Sscanf(string(tok), "%d/%d", &h, &salt); err != nil {
+		return xerrors.Errorf("bad tipset token %q: %w", tok, err)
+	}
+
+	if h > c.height || c.salts[h] != salt {
+		return xerrors.Errorf("tipset %q not in the current chain", tok)
+	}
+	return nil
+}
+
+// SealingAPI
+
+func (c *Chain) StateWaitMsg(ctx context.Context, mcid cid.Cid) (sealing.MsgLookup, error) {
+	for {
+		c.lk.Lock()
+		msg, ok := c.msgs[mcid]
+		if !ok {
+			c.lk.Unlock()
+			return sealing.MsgLookup{}, xerrors.Errorf("message %s not found", mcid)
+		}
+		if msg.lookup != nil {
+			out := *msg.lookup
+			c.lk.Unlock()
+			return out, nil
+		}
+		change := c.headChange
+		c.lk.Unlock()
+
+		select {
+		case <-change:
+		case <-ctx.Done():
+			return sealing.MsgLookup{}, ctx.Err()
+		}
+	}
+}
+
+func (c *Chain) StateComputeDataCommitment(ctx context.Context, maddr address.Address, sectorType abi.RegisteredProof, deals []abi.DealID, tok sealing.TipSetToken) (cid.Cid, error) {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+
+	if err := c.checkToken(tok); err != nil {
+		return cid.Undef, err
+	}
+
+	pieces := make([]abi.PieceInfo, len(deals))
+	for i, id := range deals {
+		d, ok := c.deals[id]
+		if !ok {
+			return cid.Undef, xerrors.Errorf("deal %d not found", id)
+		}
+
+		pieces[i] = abi.PieceInfo{
+			Size:     d.proposal.PieceSize,
+			PieceCID: d.proposal.PieceCID,
+		}
+	}
+
+	return c.commD(sectorType, pieces)
+}
+
+// StateSectorPreCommitInfo returns nil if the sector isn't pre-committed
+func (c *Chain) StateSectorPreCommitInfo(ctx context.Context, maddr address.Address, sectorNumber abi.SectorNumber, tok sealing.TipSetToken) (*miner.SectorPreCommitOnChainInfo, error) {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+
+	if err := c.checkToken(tok); err != nil {
+		return nil, err
+	}
+
+	precommits, ok := c.precommits[maddr]
+	if !ok {
+		return nil, xerrors.Errorf("miner %s not found", maddr)
+	}
+
+	pci, ok := precommits[sectorNumber]
+	if !ok {
+		return nil, nil
+	}
+
+	out := *pci
+	return &out, nil
+}
+
+// StateMinerPreCommittedSectors returns sectors which are pre-committed, but
+// not proven, in sector number order
+func (c *Chain) StateMinerPreCommittedSectors(ctx context.Context, maddr address.Address, tok sealing.TipSetToken) ([]*miner.SectorPreCommitOnChainInfo, error) {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+
+	if err := c.checkToken(tok); err != nil {
+		return nil, err
+	}
+
+	precommits, ok := c.precommits[maddr]
+	if !ok {
+		return nil, xerrors.Errorf("miner %s not found", maddr)
+	}
+
+	out := make([]*miner.SectorPreCommitOnChainInfo, 0, len(precommits))
+	for _, pci := range precommits {
+		pci := *pci
+		out = append(out, &pci)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Info.SectorNumber < out[j].Info.SectorNumber })
+	return out, nil
+}
+
+// StateMinerSectors returns proven sectors, in sector number order
+func (c *Chain) StateMinerSectors(ctx context.Context, maddr address.Address, tok sealing.TipSetToken) ([]*miner.SectorOnChainInfo, error) {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+
+	if err := c.checkToken(tok); err != nil {
+		return nil, err
+	}
+
+	proven, ok := c.proven[maddr]
+	if !ok {
+		return nil, xerrors.Errorf("miner %s not found", maddr)
+	}
+
+	out := make([]*miner.SectorOnChainInfo, 0, len(proven))
+	for _, sector := range proven {
+		sector := *sector
+		out = append(out, &sector)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Info.SectorNumber < out[j].Info.SectorNumber })
+	return out, nil
+}
+
+func (c *Chain) StateMinerSectorSize(ctx context.Context, maddr address.Address, tok sealing.TipSetToken) (abi.SectorSize, error) {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+
+	ss, ok := c.sectorSizes[maddr]
+	if !ok {
+		return 0, xerrors.Errorf("miner %s not found", maddr)
+	}
+	return ss, nil
+}
+
+func (c *Chain) StateMarketStorageDeal(ctx context.Context, id abi.DealID, tok sealing.TipSetToken) (market.DealProposal, market.DealState, error) {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+
+	d, ok := c.deals[id]
+	if !ok {
+		return market.DealProposal{}, market.DealState{}, xerrors.Errorf("deal %d not found", id)
+	}
+	return d.proposal, d.state, nil
+}
+
+func (c *Chain) SendMsg(ctx context.Context, from, to address.Address, method abi.MethodNum, value, gasPrice big.Int, gasLimit int64, params []byte) (cid.Cid, error) {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+
+	msg := &msgState{
+		Message: Message{
+			From:     from,
+			To:       to,
+			Method:   method,
+			Value:    value,
+			GasPrice: gasPrice,
+			GasLimit: gasLimit,
+			Params:   params,
+		},
+	}
+
+	// the nonce makes identical messages have different CIDs
+	var nonce [8]byte
+	binary.BigEndian.PutUint64(nonce[:], uint64(len(c.order)))
+	msg.Cid = sum(append(append(nonce[:], to.Bytes()...), params...))
+
+	c.msgs[msg.Cid] = msg
+	c.order = append(c.order, msg)
+	c.pending = append(c.pending, msg)
+
+	return msg.Cid, nil
+}
+
+func (c *Chain) ChainHead(ctx context.Context) (sealing.TipSetToken, abi.ChainEpoch, error) {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+
+	return c.tokenAt(c.height), c.height, nil
+}
+
+// ChainGetRandomness returns randomness derived from the arguments, and the
+// tipset at randEpoch, so it changes when randEpoch is reverted
+func (c *Chain) ChainGetRandomness(ctx context.Context, tok sealing.TipSetToken, personalization crypto.DomainSeparationTag, randEpoch abi.ChainEpoch, entropy []byte) (abi.Randomness, error) {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+
+	if err := c.checkToken(tok); err != nil {
+		return nil, err
+	}
+	if randEpoch < 0 || randEpoch > c.height {
+		return nil, xerrors.Errorf("randomness epoch %d out of chain range (head %d)", randEpoch, c.height)
+	}
+
+	h := sha256.New()
+	_ = binary.Write(h, binary.BigEndian, int64(personalization))
+	_ = binary.Write(h, binary.BigEndian, int64(randEpoch))
+	_ = binary.Write(h, binary.BigEndian, c.salts[randEpoch])
+	_, _ = h.Write(entropy)
+
+	return h.Sum(nil), nil
+}
+
+func (c *Chain) ChainReadObj(ctx context.Context, obj cid.Cid) ([]byte, error) {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+
+	data, ok := c.objs[obj]
+	if !ok {
+		return nil, xerrors.Errorf("object %s not found", obj)
+	}
+	return data, nil
+}
+
+func (c *Chain) WalletBalance(ctx context.Context, addr address.Address) (big.Int, error) {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+
+	b, ok := c.balances[addr]
+	if !ok {
+		return big.Zero(), nil
+	}
+	return b, nil
+}
+
+// Events
+
+// ChainAt calls hnd once the chain is confidence epochs past h, and rev when
+// the tipset at h is reverted, after which hnd can be called again
+func (c *Chain) ChainAt(hnd sealing.HeightHandler, rev sealing.RevertHandler, confidence int, h abi.ChainEpoch) error {
+	c.lk.Lock()
+
+	c.handlers = append(c.handlers, &chainAtHandler{
+		hnd:        hnd,
+		rev:        rev,
+		confidence: confidence,
+		h:          h,
+	})
+
+	apply := c.dueHandlers()
+	height := c.height
+
+	c.lk.Unlock()
+
+	for _, h := range apply {
+		if err := h.hnd(context.TODO(), h.tok, height); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func sum(data []byte) cid.Cid {
+	c, err := cid.Prefix{
+		Version:  1,
+		Codec:    cid.DagCBOR,
+		MhType:   0x12, // sha2-256
+		MhLength: -1,
+	}.Sum(data)
+	if err != nil {
+		panic(err)
+	}
+	return c
+}
@@ -0,0 +1,233 @@
+package mock
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/specs-actors/actors/abi"
+	"github.com/filecoin-project/specs-actors/actors/abi/big"
+	"github.com/filecoin-project/specs-actors/actors/builtin"
+	"github.com/filecoin-project/specs-actors/actors/builtin/miner"
+	"github.com/filecoin-project/specs-actors/actors/crypto"
+	"github.com/filecoin-project/specs-actors/actors/runtime/exitcode"
+
+	sealing "github.com/filecoin-project/storage-fsm"
+)
+
+func testChain(t *testing.T) (*Chain, address.Address) {
+	maddr, err := address.NewIDAddress(1000)
+	require.NoError(t, err)
+
+	c := NewChain()
+	c.AddMiner(maddr, 2048)
+	return c, maddr
+}
+
+func precommitParams(t *testing.T, n abi.SectorNumber) []byte {
+	buf := new(bytes.Buffer)
+	require.NoError(t, (&miner.SectorPreCommitInfo{
+		SectorNumber: n,
+		SealedCID:    sum([]byte("commr")),
+	}).MarshalCBOR(buf))
+	return buf.Bytes()
+}
+
+func TestMessageInclusion(t *testing.T) {
+	ctx := context.Background()
+	c, maddr := testChain(t)
+
+	mcid, err := c.SendMsg(ctx, maddr, maddr, builtin.MethodsMiner.PreCommitSector, big.Zero(), big.Zero(), 0, precommitParams(t, 1))
+	require.NoError(t, err)
+
+	res := make(chan error)
+	go func() {
+		lookup, err := c.StateWaitMsg(ctx, mcid)
+		if err == nil && lookup.Height != 1 {
+			err = xerrors.Errorf("unexpected inclusion height %d", lookup.Height)
+		}
+		res <- err
+	}()
+
+	select {
+	case <-res:
+		t.Fatal("message included before advancing")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	c.Advance(ctx, 1)
+	require.NoError(t, <-res)
+
+	tok, _, err := c.ChainHead(ctx)
+	require.NoError(t, err)
+
+	pci, err := c.StateSectorPreCommitInfo(ctx, maddr, 1, tok)
+	require.NoError(t, err)
+	require.NotNil(t, pci)
+	require.Equal(t, abi.ChainEpoch(1), pci.PreCommitEpoch)
+
+	pci, err = c.StateSectorPreCommitInfo(ctx, maddr, 2, tok)
+	require.NoError(t, err)
+	require.Nil(t, pci)
+
+	// pre-committing the same sector again fails
+	mcid, err = c.SendMsg(ctx, maddr, maddr, builtin.MethodsMiner.PreCommitSector, big.Zero(), big.Zero(), 0, precommitParams(t, 1))
+	require.NoError(t, err)
+	c.Advance(ctx, 1)
+
+	lookup, ok := c.Lookup(mcid)
+	require.True(t, ok)
+	require.Equal(t, exitcode.ErrIllegalArgument, lookup.Receipt.ExitCode)
+}
+
+func TestMessageHook(t *testing.T) {
+	ctx := context.Background()
+	c, maddr := testChain(t)
+
+	c.SetMessageHook(func(msg Message, height abi.ChainEpoch) exitcode.ExitCode {
+		return exitcode.SysErrOutOfGas
+	})
+
+	mcid, err := c.SendMsg(ctx, maddr, maddr, builtin.MethodsMiner.PreCommitSector, big.Zero(), big.Zero(), 0, precommitParams(t, 1))
+	require.NoError(t, err)
+	c.Advance(ctx, 1)
+
+	lookup, err := c.StateWaitMsg(ctx, mcid)
+	require.NoError(t, err)
+	require.Equal(t, exitcode.SysErrOutOfGas, lookup.Receipt.ExitCode)
+
+	// failed messages don't change state
+	pci, err := c.StateSectorPreCommitInfo(ctx, maddr, 1, lookup.TipSetTok)
+	require.NoError(t, err)
+	require.Nil(t, pci)
+}
+
+func TestReorg(t *testing.T) {
+	ctx := context.Background()
+	c, maddr := testChain(t)
+
+	c.Advance(ctx, 5)
+
+	var applied, reverted int
+	require.NoError(t, c.ChainAt(func(ctx context.Context, tok sealing.TipSetToken, curH abi.ChainEpoch) error {
+		applied++
+		return nil
+	}, func(ctx context.Context, tok sealing.TipSetToken) error {
+		reverted++
+		return nil
+	}, 2, 7))
+
+	tok, _, err := c.ChainHead(ctx)
+	require.NoError(t, err)
+	rand5, err := c.ChainGetRandomness(ctx, tok, crypto.DomainSeparationTag_SealRandomness, 5, nil)
+	require.NoError(t, err)
+
+	mcid, err := c.SendMsg(ctx, maddr, maddr, builtin.MethodsMiner.PreCommitSector, big.Zero(), big.Zero(), 0, precommitParams(t, 1))
+	require.NoError(t, err)
+
+	c.Advance(ctx, 4) // 9
+	require.Equal(t, 1, applied)
+
+	tok, _, err = c.ChainHead(ctx)
+	require.NoError(t, err)
+	rand7, err := c.ChainGetRandomness(ctx, tok, crypto.DomainSeparationTag_SealRandomness, 7, nil)
+	require.NoError(t, err)
+
+	lookup, ok := c.Lookup(mcid)
+	require.True(t, ok)
+	require.Equal(t, abi.ChainEpoch(6), lookup.Height)
+
+	require.NoError(t, c.Reorg(ctx, 4)) // 5
+	require.Equal(t, 1, reverted)
+	require.Equal(t, abi.ChainEpoch(5), c.Height())
+
+	_, ok = c.Lookup(mcid)
+	require.False(t, ok)
+
+	_, err = c.StateSectorPreCommitInfo(ctx, maddr, 1, lookup.TipSetTok)
+	require.Error(t, err, "reverted tipset")
+
+	tok, _, err = c.ChainHead(ctx)
+	require.NoError(t, err)
+	pci, err := c.StateSectorPreCommitInfo(ctx, maddr, 1, tok)
+	require.NoError(t, err)
+	require.Nil(t, pci)
+
+	// randomness before the fork doesn't change
+	rand, err := c.ChainGetRandomness(ctx, tok, crypto.DomainSeparationTag_SealRandomness, 5, nil)
+	require.NoError(t, err)
+	require.Equal(t, rand5, rand)
+
+	c.Advance(ctx, 4) // 9
+	require.Equal(t, 2, applied)
+
+	// randomness after the fork does
+	tok, _, err = c.ChainHead(ctx)
+	require.NoError(t, err)
+	rand, err = c.ChainGetRandomness(ctx, tok, crypto.DomainSeparationTag_SealRandomness, 7, nil)
+	require.NoError(t, err)
+	require.NotEqual(t, rand7, rand)
+
+	lookup, ok = c.Lookup(mcid)
+	require.True(t, ok)
+	require.Equal(t, abi.ChainEpoch(6), lookup.Height)
+
+	tok, _, err = c.ChainHead(ctx)
+	require.NoError(t, err)
+	pci, err = c.StateSectorPreCommitInfo(ctx, maddr, 1, tok)
+	require.NoError(t, err)
+	require.NotNil(t, pci)
+}
+
+func TestMinerSectors(t *testing.T) {
+	ctx := context.Background()
+	c, maddr := testChain(t)
+
+	for _, n := range []abi.SectorNumber{2, 1} {
+		_, err := c.SendMsg(ctx, maddr, maddr, builtin.MethodsMiner.PreCommitSector, big.Zero(), big.Zero(), 0, precommitParams(t, n))
+		require.NoError(t, err)
+	}
+	c.Advance(ctx, 1+int(miner.PreCommitChallengeDelay))
+
+	buf := new(bytes.Buffer)
+	require.NoError(t, (&miner.ProveCommitSectorParams{SectorNumber: 2}).MarshalCBOR(buf))
+	mcid, err := c.SendMsg(ctx, maddr, maddr, builtin.MethodsMiner.ProveCommitSector, big.Zero(), big.Zero(), 0, buf.Bytes())
+	require.NoError(t, err)
+	c.Advance(ctx, 1)
+
+	lookup, ok := c.Lookup(mcid)
+	require.True(t, ok)
+	require.Equal(t, exitcode.Ok, lookup.Receipt.ExitCode)
+
+	tok, _, err := c.ChainHead(ctx)
+	require.NoError(t, err)
+
+	precommits, err := c.StateMinerPreCommittedSectors(ctx, maddr, tok)
+	require.NoError(t, err)
+	require.Len(t, precommits, 1)
+	require.Equal(t, abi.SectorNumber(1), precommits[0].Info.SectorNumber)
+
+	sectors, err := c.StateMinerSectors(ctx, maddr, tok)
+	require.NoError(t, err)
+	require.Len(t, sectors, 1)
+	require.Equal(t, abi.SectorNumber(2), sectors[0].Info.SectorNumber)
+	require.Equal(t, lookup.Height, sectors[0].ActivationEpoch)
+	require.Equal(t, abi.ChainEpoch(-1), sectors[0].DeclaredFaultEpoch)
+
+	// proving is reverted with the message
+	require.NoError(t, c.Reorg(ctx, 1))
+	tok, _, err = c.ChainHead(ctx)
+	require.NoError(t, err)
+
+	sectors, err = c.StateMinerSectors(ctx, maddr, tok)
+	require.NoError(t, err)
+	require.Empty(t, sectors)
+	precommits, err = c.StateMinerPreCommittedSectors(ctx, maddr, tok)
+	require.NoError(t, err)
+	require.Len(t, precommits, 2)
+}
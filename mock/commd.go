@@ -0,0 +1,26 @@
+package mock
+
+import (
+	"crypto/sha256"
+
+	"github.com/ipfs/go-cid"
+
+	commcid "github.com/filecoin-project/go-fil-commcid"
+	"github.com/filecoin-project/sector-storage/zerocomm"
+	"github.com/filecoin-project/specs-actors/actors/abi"
+)
+
+// DataCommitment computes a fake, but deterministic CommD from the non-zero
+// pieces of a sector, so sectors filled with pledge pieces around deals get
+// the same CommD as computed from the deals alone
+func DataCommitment(rt abi.RegisteredProof, pieces []abi.PieceInfo) (cid.Cid, error) {
+	h := sha256.New()
+	for _, p := range pieces {
+		if p.PieceCID == zerocomm.ZeroPieceCommitment(p.Size.Unpadded()) {
+			continue
+		}
+		_, _ = h.Write(p.PieceCID.Bytes())
+	}
+
+	return commcid.DataCommitmentV1ToCID(h.Sum(nil)), nil
+}
@@ -0,0 +1,491 @@
+package mock
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"golang.org/x/xerrors"
+
+	commcid "github.com/filecoin-project/go-fil-commcid"
+	sectorstorage "github.com/filecoin-project/sector-storage"
+	"github.com/filecoin-project/sector-storage/ffiwrapper"
+	"github.com/filecoin-project/sector-storage/stores"
+	"github.com/filecoin-project/sector-storage/zerocomm"
+	"github.com/filecoin-project/specs-actors/actors/abi"
+	"github.com/filecoin-project/specs-storage/storage"
+
+	sealing "github.com/filecoin-project/storage-fsm"
+)
+
+var _ sectorstorage.SectorManager = &SectorMgr{}
+var _ sealing.SectorRemover = &SectorMgr{}
+var _ sealing.SectorFileIndex = &SectorMgr{}
+
+// Step is a SectorMgr operation faults can be injected into
+type Step string
+
+const (
+	StepNewSector      Step = "NewSector"
+	StepAddPiece       Step = "AddPiece"
+	StepPreCommit1     Step = "SealPreCommit1"
+	StepPreCommit2     Step = "SealPreCommit2"
+	StepCommit1        Step = "SealCommit1"
+	StepCommit2        Step = "SealCommit2"
+	StepFinalizeSector Step = "FinalizeSector"
+	StepRemove         Step = "Remove"
+)
+
+// Fault describes how a step misbehaves
+type Fault struct {
+	// Sectors the fault applies to, all sectors if empty
+	Sectors []abi.SectorNumber
+	// Times is the number of calls the fault applies to, every call if 0
+	Times int
+
+	// Delay is waited before running the step. The step fails if the
+	// context is cancelled while waiting
+	Delay time.Duration
+	// Err is returned instead of running the step
+	Err error
+}
+
+func (f *Fault) matches(sector abi.SectorNumber) bool {
+	if len(f.Sectors) == 0 {
+		return true
+	}
+	for _, s := range f.Sectors {
+		if s == sector {
+			return true
+		}
+	}
+	return false
+}
+
+type sectorState struct {
+	data   []byte
+	pieces []abi.PieceInfo
+
+	sealed    bool
+	finalized bool
+}
+
+// SectorMgr is a SectorManager doing fake, deterministic sealing. Proofs it
+// computes are accepted by Verifier
+type SectorMgr struct {
+	lk sync.Mutex
+
+	ssize   abi.SectorSize
+	sectors map[abi.SectorID]*sectorState
+
+	faults        map[Step][]*Fault
+	invalidProofs map[abi.SectorNumber]bool
+	calls         map[Step]int
+
+	available uint64
+}
+
+func NewSectorMgr(ssize abi.SectorSize) *SectorMgr {
+	return &SectorMgr{
+		ssize:   ssize,
+		sectors: map[abi.SectorID]*sectorState{},
+
+		faults:        map[Step][]*Fault{},
+		invalidProofs: map[abi.SectorNumber]bool{},
+		calls:         map[Step]int{},
+
+		available: math.MaxUint64,
+	}
+}
+
+// Inject adds a fault to a step. Faults are checked in the order they were
+// added, the first matching one is used
+func (sm *SectorMgr) Inject(step Step, f Fault) {
+	sm.lk.Lock()
+	defer sm.lk.Unlock()
+
+	sm.faults[step] = append(sm.faults[step], &f)
+}
+
+// ClearFaults removes all injected faults
+func (sm *SectorMgr) ClearFaults() {
+	sm.lk.Lock()
+	defer sm.lk.Unlock()
+
+	sm.faults = map[Step][]*Fault{}
+}
+
+// SetInvalidProofs makes SealCommit2 return proofs Verifier rejects for the
+// sector
+func (sm *SectorMgr) SetInvalidProofs(sector abi.SectorNumber, invalid bool) {
+	sm.lk.Lock()
+	defer sm.lk.Unlock()
+
+	sm.invalidProofs[sector] = invalid
+}
+
+// SetAvailable sets free space FsStat reports for local storage, which is
+// unlimited by default
+func (sm *SectorMgr) SetAvailable(available uint64) {
+	sm.lk.Lock()
+	defer sm.lk.Unlock()
+
+	sm.available = available
+}
+
+// Calls returns how many times a step was called
+func (sm *SectorMgr) Calls(step Step) int {
+	sm.lk.Lock()
+	defer sm.lk.Unlock()
+
+	return sm.calls[step]
+}
+
+// Sectors returns IDs of sectors which have files in the manager
+func (sm *SectorMgr) Sectors() []abi.SectorID {
+	sm.lk.Lock()
+	defer sm.lk.Unlock()
+
+	out := make([]abi.SectorID, 0, len(sm.sectors))
+	for id := range sm.sectors {
+		out = append(out, id)
+	}
+	return out
+}
+
+// step records a call, and applies a matching fault
+func (sm *SectorMgr) step(ctx context.Context, step Step, sector abi.SectorNumber) error {
+	sm.lk.Lock()
+	sm.calls[step]++
+
+	var fault *Fault
+	for i, f := range sm.faults[step] {
+		if !f.matches(sector) {
+			continue
+		}
+
+		fault = f
+		if f.Times > 0 {
+			f.Times--
+			if f.Times == 0 {
+				sm.faults[step] = append(sm.faults[step][:i:i], sm.faults[step][i+1:]...)
+			}
+		}
+		break
+	}
+	sm.lk.Unlock()
+
+	if fault == nil {
+		return nil
+	}
+
+	if fault.Delay > 0 {
+		select {
+		case <-time.After(fault.Delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	return fault.Err
+}
+
+func (sm *SectorMgr) sector(id abi.SectorID) (*sectorState, error) {
+	sm.lk.Lock()
+	defer sm.lk.Unlock()
+
+	ss, ok := sm.sectors[id]
+	if !ok {
+		return nil, xerrors.Errorf("sector %d not found", id.Number)
+	}
+	return ss, nil
+}
+
+func (sm *SectorMgr) SectorSize() abi.SectorSize {
+	return sm.ssize
+}
+
+func (sm *SectorMgr) NewSector(ctx context.Context, sector abi.SectorID) error {
+	if err := sm.step(ctx, StepNewSector, sector.Number); err != nil {
+		return err
+	}
+
+	sm.lk.Lock()
+	defer sm.lk.Unlock()
+
+	if _, ok := sm.sectors[sector]; ok {
+		return xerrors.Errorf("sector %d already exists", sector.Number)
+	}
+	sm.sectors[sector] = &sectorState{}
+	return nil
+}
+
+func (sm *SectorMgr) AddPiece(ctx context.Context, sector abi.SectorID, pieceSizes []abi.UnpaddedPieceSize, newPieceSize abi.UnpaddedPieceSize, pieceData storage.Data) (abi.PieceInfo, error) {
+	if err := sm.step(ctx, StepAddPiece, sector.Number); err != nil {
+		return abi.PieceInfo{}, err
+	}
+
+	data, err := ioutil.ReadAll(pieceData)
+	if err != nil {
+		return abi.PieceInfo{}, xerrors.Errorf("reading piece data: %w", err)
+	}
+	if abi.UnpaddedPieceSize(len(data)) != newPieceSize {
+		return abi.PieceInfo{}, xerrors.Errorf("piece data size %d doesn't match piece size %d", len(data), newPieceSize)
+	}
+
+	sm.lk.Lock()
+	defer sm.lk.Unlock()
+
+	ss, ok := sm.sectors[sector]
+	if !ok {
+		// like the real sealer, AddPiece creates the sector
+		ss = &sectorState{}
+		sm.sectors[sector] = ss
+	}
+	if ss.sealed {
+		return abi.PieceInfo{}, xerrors.Errorf("can't add piece to sealed sector %d", sector.Number)
+	}
+
+	pi := abi.PieceInfo{
+		Size:     newPieceSize.Padded(),
+		PieceCID: PieceCommitment(data),
+	}
+
+	ss.data = append(ss.data, data...)
+	ss.pieces = append(ss.pieces, pi)
+
+	return pi, nil
+}
+
+func (sm *SectorMgr) SealPreCommit1(ctx context.Context, sector abi.SectorID, ticket abi.SealRandomness, pieces []abi.PieceInfo) (storage.PreCommit1Out, error) {
+	if err := sm.step(ctx, StepPreCommit1, sector.Number); err != nil {
+		return nil, err
+	}
+
+	if _, err := sm.sector(sector); err != nil {
+		return nil, err
+	}
+
+	var sum abi.PaddedPieceSize
+	for _, p := range pieces {
+		sum += p.Size
+	}
+	if sum != abi.PaddedPieceSize(sm.ssize) {
+		return nil, xerrors.Errorf("piece sizes don't add up to sector size: %d != %d", sum, sm.ssize)
+	}
+
+	rt, _, err := ffiwrapper.ProofTypeFromSectorSize(sm.ssize)
+	if err != nil {
+		return nil, err
+	}
+
+	commD, err := DataCommitment(rt, pieces)
+	if err != nil {
+		return nil, err
+	}
+
+	d, err := commcid.CIDToDataCommitmentV1(commD)
+	if err != nil {
+		return nil, err
+	}
+
+	// PreCommit1Out is CommD followed by the ticket
+	return append(d, ticket...), nil
+}
+
+func (sm *SectorMgr) SealPreCommit2(ctx context.Context, sector abi.SectorID, pc1o storage.PreCommit1Out) (storage.SectorCids, error) {
+	if err := sm.step(ctx, StepPreCommit2, sector.Number); err != nil {
+		return storage.SectorCids{}, err
+	}
+
+	ss, err := sm.sector(sector)
+	if err != nil {
+		return storage.SectorCids{}, err
+	}
+	if len(pc1o) < 32 {
+		return storage.SectorCids{}, xerrors.Errorf("invalid PreCommit1Out")
+	}
+
+	sm.lk.Lock()
+	ss.sealed = true
+	sm.lk.Unlock()
+
+	commR := sha256.Sum256(append(sectorBytes(sector), pc1o...))
+
+	return storage.SectorCids{
+		Unsealed: commcid.DataCommitmentV1ToCID(pc1o[:32]),
+		Sealed:   commcid.ReplicaCommitmentV1ToCID(commR[:]),
+	}, nil
+}
+
+func (sm *SectorMgr) SealCommit1(ctx context.Context, sector abi.SectorID, ticket abi.SealRandomness, seed abi.InteractiveSealRandomness, pieces []abi.PieceInfo, cids storage.SectorCids) (storage.Commit1Out, error) {
+	if err := sm.step(ctx, StepCommit1, sector.Number); err != nil {
+		return nil, err
+	}
+
+	ss, err := sm.sector(sector)
+	if err != nil {
+		return nil, err
+	}
+
+	sm.lk.Lock()
+	sealed := ss.sealed
+	sm.lk.Unlock()
+	if !sealed {
+		return nil, xerrors.Errorf("sector %d isn't sealed", sector.Number)
+	}
+
+	return commit1Out(sector, ticket, seed, cids.Sealed), nil
+}
+
+func (sm *SectorMgr) SealCommit2(ctx context.Context, sector abi.SectorID, c1o storage.Commit1Out) (storage.Proof, error) {
+	if err := sm.step(ctx, StepCommit2, sector.Number); err != nil {
+		return nil, err
+	}
+
+	proof := sealProof(c1o)
+
+	sm.lk.Lock()
+	defer sm.lk.Unlock()
+	if sm.invalidProofs[sector.Number] {
+		proof[0] ^= 0xff
+	}
+
+	return proof, nil
+}
+
+func (sm *SectorMgr) FinalizeSector(ctx context.Context, sector abi.SectorID) error {
+	if err := sm.step(ctx, StepFinalizeSector, sector.Number); err != nil {
+		return err
+	}
+
+	ss, err := sm.sector(sector)
+	if err != nil {
+		return err
+	}
+
+	sm.lk.Lock()
+	defer sm.lk.Unlock()
+	ss.finalized = true
+
+	return nil
+}
+
+func (sm *SectorMgr) Remove(ctx context.Context, sector abi.SectorID) error {
+	if err := sm.step(ctx, StepRemove, sector.Number); err != nil {
+		return err
+	}
+
+	sm.lk.Lock()
+	defer sm.lk.Unlock()
+
+	delete(sm.sectors, sector)
+	return nil
+}
+
+// SectorFiles reports the unsealed file of sectors with pieces, and the
+// sealed and cache files of sectors which went through SealPreCommit2
+func (sm *SectorMgr) SectorFiles(ctx context.Context, sector abi.SectorID) (stores.SectorFileType, error) {
+	sm.lk.Lock()
+	defer sm.lk.Unlock()
+
+	ss, ok := sm.sectors[sector]
+	if !ok {
+		return stores.FTNone, nil
+	}
+
+	out := stores.FTNone
+	if len(ss.pieces) > 0 {
+		out |= stores.FTUnsealed
+	}
+	if ss.sealed {
+		out |= stores.FTSealed | stores.FTCache
+	}
+	return out, nil
+}
+
+// StorageLocal reports a single local storage path
+func (sm *SectorMgr) StorageLocal(ctx context.Context) (map[stores.ID]string, error) {
+	return map[stores.ID]string{"mock": ""}, nil
+}
+
+func (sm *SectorMgr) FsStat(ctx context.Context, id stores.ID) (stores.FsStat, error) {
+	sm.lk.Lock()
+	defer sm.lk.Unlock()
+
+	return stores.FsStat{Capacity: math.MaxUint64, Available: sm.available}, nil
+}
+
+func (sm *SectorMgr) ReadPieceFromSealedSector(ctx context.Context, sector abi.SectorID, offset ffiwrapper.UnpaddedByteIndex, size abi.UnpaddedPieceSize, ticket abi.SealRandomness, commD cid.Cid) (io.ReadCloser, error) {
+	ss, err := sm.sector(sector)
+	if err != nil {
+		return nil, err
+	}
+
+	sm.lk.Lock()
+	defer sm.lk.Unlock()
+
+	if uint64(offset)+uint64(size) > uint64(len(ss.data)) {
+		return nil, xerrors.Errorf("reading past sector data: %d+%d > %d", offset, size, len(ss.data))
+	}
+
+	return ioutil.NopCloser(bytes.NewReader(ss.data[offset : uint64(offset)+uint64(size)])), nil
+}
+
+func (sm *SectorMgr) GenerateEPostCandidates(ctx context.Context, miner abi.ActorID, sectorInfo []abi.SectorInfo, challengeSeed abi.PoStRandomness, faults []abi.SectorNumber) ([]storage.PoStCandidateWithTicket, error) {
+	return nil, xerrors.New("not supported by the mock sector manager")
+}
+
+func (sm *SectorMgr) GenerateFallbackPoSt(ctx context.Context, miner abi.ActorID, sectorInfo []abi.SectorInfo, challengeSeed abi.PoStRandomness, faults []abi.SectorNumber) (storage.FallbackPostOut, error) {
+	return storage.FallbackPostOut{}, xerrors.New("not supported by the mock sector manager")
+}
+
+func (sm *SectorMgr) ComputeElectionPoSt(ctx context.Context, miner abi.ActorID, sectorInfo []abi.SectorInfo, challengeSeed abi.PoStRandomness, winners []abi.PoStCandidate) ([]abi.PoStProof, error) {
+	return nil, xerrors.New("not supported by the mock sector manager")
+}
+
+// PieceCommitment returns the CommP SectorMgr computes for piece data. Zeroed
+// data gets the zero piece commitment, so pledge pieces pass checkPieces
+func PieceCommitment(data []byte) cid.Cid {
+	zero := true
+	for _, b := range data {
+		if b != 0 {
+			zero = false
+			break
+		}
+	}
+	if zero {
+		return zerocomm.ZeroPieceCommitment(abi.UnpaddedPieceSize(len(data)))
+	}
+
+	commP := sha256.Sum256(data)
+	return commcid.PieceCommitmentV1ToCID(commP[:])
+}
+
+func sectorBytes(sector abi.SectorID) []byte {
+	var b [16]byte
+	binary.BigEndian.PutUint64(b[:8], uint64(sector.Miner))
+	binary.BigEndian.PutUint64(b[8:], uint64(sector.Number))
+	return b[:]
+}
+
+func commit1Out(sector abi.SectorID, ticket abi.SealRandomness, seed abi.InteractiveSealRandomness, commR cid.Cid) storage.Commit1Out {
+	h := sha256.New()
+	_, _ = h.Write(sectorBytes(sector))
+	_, _ = h.Write(ticket)
+	_, _ = h.Write(seed)
+	_, _ = h.Write(commR.Bytes())
+	return h.Sum(nil)
+}
+
+func sealProof(c1o storage.Commit1Out) storage.Proof {
+	p := sha256.Sum256(append([]byte("proof"), c1o...))
+	return p[:]
+}
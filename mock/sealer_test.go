@@ -0,0 +1,83 @@
+package mock
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/specs-actors/actors/abi"
+)
+
+func TestFaults(t *testing.T) {
+	ctx := context.Background()
+	sm := NewSectorMgr(2048)
+
+	sm.Inject(StepNewSector, Fault{Sectors: []abi.SectorNumber{2}, Times: 1, Err: xerrors.New("fail")})
+
+	require.NoError(t, sm.NewSector(ctx, abi.SectorID{Number: 1}))
+	require.Error(t, sm.NewSector(ctx, abi.SectorID{Number: 2}))
+	require.NoError(t, sm.NewSector(ctx, abi.SectorID{Number: 2}))
+
+	require.Equal(t, 3, sm.Calls(StepNewSector))
+
+	sm.Inject(StepRemove, Fault{Err: xerrors.New("fail")})
+	require.Error(t, sm.Remove(ctx, abi.SectorID{Number: 1}))
+	require.Error(t, sm.Remove(ctx, abi.SectorID{Number: 1}))
+
+	sm.ClearFaults()
+	require.NoError(t, sm.Remove(ctx, abi.SectorID{Number: 1}))
+	require.Equal(t, []abi.SectorID{{Number: 2}}, sm.Sectors())
+}
+
+func TestProofs(t *testing.T) {
+	ctx := context.Background()
+	sm := NewSectorMgr(2048)
+	v := NewVerifier()
+
+	sid := abi.SectorID{Miner: 1000, Number: 1}
+	require.NoError(t, sm.NewSector(ctx, sid))
+
+	size := abi.PaddedPieceSize(2048).Unpadded()
+	pi, err := sm.AddPiece(ctx, sid, nil, size, zeroReader(size))
+	require.NoError(t, err)
+
+	ticket := abi.SealRandomness{1, 2, 3}
+	seed := abi.InteractiveSealRandomness{4, 5, 6}
+
+	pc1o, err := sm.SealPreCommit1(ctx, sid, ticket, []abi.PieceInfo{pi})
+	require.NoError(t, err)
+	cids, err := sm.SealPreCommit2(ctx, sid, pc1o)
+	require.NoError(t, err)
+
+	verify := func() bool {
+		c1o, err := sm.SealCommit1(ctx, sid, ticket, seed, []abi.PieceInfo{pi}, cids)
+		require.NoError(t, err)
+		proof, err := sm.SealCommit2(ctx, sid, c1o)
+		require.NoError(t, err)
+
+		svi := abi.SealVerifyInfo{
+			SectorID:              sid,
+			Randomness:            ticket,
+			InteractiveRandomness: seed,
+			UnsealedCID:           cids.Unsealed,
+		}
+		svi.OnChain.SealedCID = cids.Sealed
+		svi.OnChain.Proof = proof
+
+		ok, err := v.VerifySeal(svi)
+		require.NoError(t, err)
+		return ok
+	}
+
+	require.True(t, verify())
+
+	sm.SetInvalidProofs(1, true)
+	require.False(t, verify())
+}
+
+func zeroReader(size abi.UnpaddedPieceSize) *bytes.Reader {
+	return bytes.NewReader(make([]byte, size))
+}
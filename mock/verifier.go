@@ -0,0 +1,52 @@
+package mock
+
+import (
+	"bytes"
+	"context"
+	"sync"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/sector-storage/ffiwrapper"
+	"github.com/filecoin-project/specs-actors/actors/abi"
+)
+
+var _ ffiwrapper.Verifier = &Verifier{}
+
+// Verifier accepts seal proofs computed by SectorMgr
+type Verifier struct {
+	lk  sync.Mutex
+	err error
+}
+
+func NewVerifier() *Verifier {
+	return &Verifier{}
+}
+
+// SetError makes VerifySeal fail with err, nil restores normal behavior
+func (v *Verifier) SetError(err error) {
+	v.lk.Lock()
+	defer v.lk.Unlock()
+
+	v.err = err
+}
+
+func (v *Verifier) VerifySeal(svi abi.SealVerifyInfo) (bool, error) {
+	v.lk.Lock()
+	err := v.err
+	v.lk.Unlock()
+	if err != nil {
+		return false, err
+	}
+
+	c1o := commit1Out(svi.SectorID, svi.Randomness, svi.InteractiveRandomness, svi.OnChain.SealedCID)
+	return bytes.Equal(sealProof(c1o), svi.OnChain.Proof), nil
+}
+
+func (v *Verifier) VerifyElectionPost(ctx context.Context, info abi.PoStVerifyInfo) (bool, error) {
+	return false, xerrors.New("not supported by the mock verifier")
+}
+
+func (v *Verifier) VerifyFallbackPost(ctx context.Context, info abi.PoStVerifyInfo) (bool, error) {
+	return false, xerrors.New("not supported by the mock verifier")
+}
@@ -0,0 +1,736 @@
+package sealing_test
+
+import (
+	"bytes"
+	"context"
+	"math/rand"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/namespace"
+	"github.com/ipfs/go-datastore/query"
+	dssync "github.com/ipfs/go-datastore/sync"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/sector-storage/ffiwrapper"
+	"github.com/filecoin-project/sector-storage/zerocomm"
+	"github.com/filecoin-project/specs-actors/actors/abi"
+	"github.com/filecoin-project/specs-actors/actors/abi/big"
+	"github.com/filecoin-project/specs-actors/actors/builtin"
+	"github.com/filecoin-project/specs-actors/actors/builtin/market"
+	"github.com/filecoin-project/specs-actors/actors/builtin/miner"
+	"github.com/filecoin-project/specs-actors/actors/runtime/exitcode"
+
+	sealing "github.com/filecoin-project/storage-fsm"
+	"github.com/filecoin-project/storage-fsm/mock"
+)
+
+const testSectorSize = 2048
+
+type harness struct {
+	t   *testing.T
+	ctx context.Context
+
+	maddr address.Address
+	ds    datastore.Batching
+	chain *mock.Chain
+	sm    *mock.SectorMgr
+	verif *mock.Verifier
+	blobs *faultyBlobs
+	m     *sealing.Sealing
+}
+
+// faultyBlobs is a BlobStore which fails to delete blobs while failDelete is
+// set
+type faultyBlobs struct {
+	sealing.BlobStore
+
+	failDelete int32
+}
+
+func (b *faultyBlobs) Delete(ctx context.Context, ref cid.Cid) error {
+	if atomic.LoadInt32(&b.failDelete) != 0 {
+		return xerrors.Errorf("can't delete blob %s", ref)
+	}
+	return b.BlobStore.Delete(ctx, ref)
+}
+
+func newHarness(t *testing.T) (*harness, func()) {
+	maddr, err := address.NewIDAddress(1000)
+	require.NoError(t, err)
+
+	chain := mock.NewChain()
+	chain.AddMiner(maddr, testSectorSize)
+
+	return startHarness(t, maddr, chain, mock.NewSectorMgr(testSectorSize))
+}
+
+// startHarness runs sealing with an empty datastore, on an existing chain and
+// sector manager
+func startHarness(t *testing.T, maddr address.Address, chain *mock.Chain, sm *mock.SectorMgr) (*harness, func()) {
+	ds := dssync.MutexWrap(datastore.NewMapDatastore())
+	verif := mock.NewVerifier()
+	blobs := &faultyBlobs{BlobStore: sealing.NewDatastoreBlobStore(namespace.Wrap(ds, datastore.NewKey(sealing.BlobStorePrefix)))}
+
+	m := sealing.New(chain, chain, maddr, maddr, ds, sm, sealing.NewStoredCounter(ds), verif, nil, nil, blobs)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	require.NoError(t, m.Run(ctx))
+	go chain.Mine(ctx, time.Millisecond)
+
+	h := &harness{
+		t:     t,
+		ctx:   ctx,
+		maddr: maddr,
+		ds:    ds,
+		chain: chain,
+		sm:    sm,
+		verif: verif,
+		blobs: blobs,
+		m:     m,
+	}
+
+	return h, func() {
+		cancel()
+		sctx, scancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer scancel()
+		require.NoError(t, m.Stop(sctx))
+	}
+}
+
+func (h *harness) waitState(sid abi.SectorNumber, state sealing.SectorState) {
+	waitSectorState(h.t, h.m, sid, state)
+}
+
+func waitSectorState(t *testing.T, m *sealing.Sealing, sid abi.SectorNumber, state sealing.SectorState) {
+	waitSector(t, m, sid, string(state), func(si sealing.SectorInfo) bool { return si.State == state })
+}
+
+func waitSector(t *testing.T, m *sealing.Sealing, sid abi.SectorNumber, what string, cond func(sealing.SectorInfo) bool) {
+	// not require.Eventually, it can send on a closed channel when the
+	// condition is slower than the tick
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		si, err := m.GetSectorInfo(sid)
+		if err == nil && cond(si) {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("waiting for sector %d to reach %s, it's in %s (err: %v)", sid, what, si.State, err)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestPipelineCC(t *testing.T) {
+	h, stop := newHarness(t)
+	defer stop()
+
+	sid, err := h.m.PledgeSectorContext(h.ctx)
+	require.NoError(t, err)
+
+	h.waitState(sid, sealing.Proving)
+
+	require.Contains(t, h.chain.ProvenSectors(h.maddr), sid)
+	require.Equal(t, 1, h.sm.Calls(mock.StepCommit2))
+	require.Equal(t, 1, h.sm.Calls(mock.StepFinalizeSector))
+
+	// PreCommit1 output and the proof are removed once the sector is proving
+	waitSector(t, h.m, sid, "no blobs", func(si sealing.SectorInfo) bool {
+		return si.PreCommit1OutRef == nil && si.ProofRef == nil
+	})
+	require.Empty(t, h.storedBlobs())
+}
+
+// storedBlobs returns keys of blobs in the harness blob store
+func (h *harness) storedBlobs() []string {
+	res, err := h.ds.Query(query.Query{Prefix: sealing.BlobStorePrefix, KeysOnly: true})
+	require.NoError(h.t, err)
+	entries, err := res.Rest()
+	require.NoError(h.t, err)
+
+	out := make([]string, len(entries))
+	for i, e := range entries {
+		out[i] = e.Key
+	}
+	return out
+}
+
+func TestPipelineBlobDeleteFails(t *testing.T) {
+	h, stop := newHarness(t)
+	defer stop()
+
+	atomic.StoreInt32(&h.blobs.failDelete, 1)
+
+	sid, err := h.m.PledgeSectorContext(h.ctx)
+	require.NoError(t, err)
+
+	// the sector doesn't get stuck, the blobs are left behind
+	waitSector(t, h.m, sid, "no blobs", func(si sealing.SectorInfo) bool {
+		return si.State == sealing.Proving && si.PreCommit1OutRef == nil && si.ProofRef == nil
+	})
+	require.Len(t, h.storedBlobs(), 2)
+}
+
+func TestPipelineDeal(t *testing.T) {
+	h, stop := newHarness(t)
+	defer stop()
+
+	size := abi.PaddedPieceSize(testSectorSize).Unpadded()
+	data := make([]byte, size)
+	_, _ = rand.New(rand.NewSource(1)).Read(data)
+
+	dealID := h.chain.AddDeal(market.DealProposal{
+		PieceCID:   mock.PieceCommitment(data),
+		PieceSize:  size.Padded(),
+		Provider:   h.maddr,
+		StartEpoch: 100000,
+		EndEpoch:   200000,
+	})
+
+	sid, _, err := h.m.AllocatePieceContext(h.ctx, size)
+	require.NoError(t, err)
+	require.NoError(t, h.m.SealPiece(h.ctx, size, bytes.NewReader(data), sid, dealID))
+
+	h.waitState(sid, sealing.Proving)
+
+	tok, _, err := h.chain.ChainHead(h.ctx)
+	require.NoError(t, err)
+	_, state, err := h.chain.StateMarketStorageDeal(h.ctx, dealID, tok)
+	require.NoError(t, err)
+	require.Equal(t, h.chain.ProvenSectors(h.maddr)[sid], state.SectorStartEpoch)
+
+	rep, err := h.m.ReplaySector(sid)
+	require.NoError(t, err)
+	require.Empty(t, rep.Diffs)
+}
+
+func TestPipelineSealRetry(t *testing.T) {
+	h, stop := newHarness(t)
+	defer stop()
+
+	h.sm.Inject(mock.StepPreCommit1, mock.Fault{Times: 1, Err: xerrors.New("disk on fire")})
+
+	sid, err := h.m.PledgeSectorContext(h.ctx)
+	require.NoError(t, err)
+
+	h.waitState(sid, sealing.SealFailed)
+	require.NoError(t, h.m.RetrySector(h.ctx, sid))
+	h.waitState(sid, sealing.Proving)
+
+	require.Equal(t, 2, h.sm.Calls(mock.StepPreCommit1))
+
+	// only failed sectors are retried
+	require.Error(t, h.m.RetrySector(h.ctx, sid))
+}
+
+// within fails the test if fn doesn't return in time. Sends to a sector whose
+// state machine stopped block forever
+func (h *harness) within(fn func() error) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- fn()
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(5 * time.Second):
+		h.t.Fatal("call didn't return, the sector state machine is stuck")
+		return nil
+	}
+}
+
+// waitCalls waits for a sector manager step to start n times
+func (h *harness) waitCalls(step mock.Step, n int) {
+	deadline := time.Now().Add(10 * time.Second)
+	for h.sm.Calls(step) < n {
+		if time.Now().After(deadline) {
+			h.t.Fatalf("waiting for %d calls of %s, have %d", n, step, h.sm.Calls(step))
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestPipelineRetryFinalStates(t *testing.T) {
+	h, stop := newHarness(t)
+	defer stop()
+
+	sid, err := h.m.PledgeSectorContext(h.ctx)
+	require.NoError(t, err)
+	h.waitState(sid, sealing.Proving)
+
+	// FaultedFinal doesn't accept events, a retry would stop its state machine
+	require.NoError(t, h.m.ForceSectorState(h.ctx, sid, sealing.FaultedFinal, "test"))
+	h.waitState(sid, sealing.FaultedFinal)
+	require.Error(t, h.m.RetrySector(h.ctx, sid))
+}
+
+func TestPipelineForceRunning(t *testing.T) {
+	h, stop := newHarness(t)
+	defer stop()
+
+	h.sm.Inject(mock.StepPreCommit1, mock.Fault{Times: 1, Delay: 300 * time.Millisecond})
+
+	sid, err := h.m.PledgeSectorContext(h.ctx)
+	require.NoError(t, err)
+	h.waitCalls(mock.StepPreCommit1, 1)
+
+	// the forced state goes through the state machine which runs the
+	// handler, it's applied once PreCommit1 returns
+	require.NoError(t, h.m.ForceSectorState(h.ctx, sid, sealing.SealFailed, "test"))
+	h.waitState(sid, sealing.SealFailed)
+
+	// the sector still gets events
+	require.NoError(t, h.within(func() error { return h.m.RetrySector(h.ctx, sid) }))
+	h.waitState(sid, sealing.Proving)
+	require.Equal(t, 2, h.sm.Calls(mock.StepPreCommit1))
+}
+
+func TestPipelinePause(t *testing.T) {
+	h, stop := newHarness(t)
+	defer stop()
+
+	h.sm.Inject(mock.StepPreCommit1, mock.Fault{Times: 1, Delay: 300 * time.Millisecond})
+
+	sid, err := h.m.PledgeSectorContext(h.ctx)
+	require.NoError(t, err)
+	h.waitCalls(mock.StepPreCommit1, 1)
+
+	require.Error(t, h.m.ResumeSector(h.ctx, sid))
+	require.NoError(t, h.m.PauseSector(h.ctx, sid))
+
+	// the running handler finishes, and its result is kept
+	waitSector(t, h.m, sid, "paused PreCommit2", func(si sealing.SectorInfo) bool {
+		return si.Paused && si.State == sealing.PreCommit2
+	})
+	require.Equal(t, 0, h.sm.Calls(mock.StepPreCommit2))
+
+	require.NoError(t, h.m.SetSectorPriority(h.ctx, sid, 5))
+	require.NoError(t, h.m.ResumeSector(h.ctx, sid))
+	h.waitState(sid, sealing.Proving)
+	require.Equal(t, 1, h.sm.Calls(mock.StepPreCommit1))
+
+	si, err := h.m.GetSectorInfo(sid)
+	require.NoError(t, err)
+	require.Equal(t, uint64(5), si.Priority)
+
+	// sectors which don't exist aren't created
+	require.Error(t, h.m.PauseSector(h.ctx, sid+1))
+	require.Error(t, h.m.ResumeSector(h.ctx, sid+1))
+	require.Error(t, h.m.SetSectorPriority(h.ctx, sid+1, 5))
+	_, err = h.m.GetSectorInfo(sid + 1)
+	require.Error(t, err)
+}
+
+func TestPipelineInvalidProof(t *testing.T) {
+	h, stop := newHarness(t)
+	defer stop()
+
+	// first sector allocated by a fresh counter
+	h.sm.SetInvalidProofs(0, true)
+
+	sid, err := h.m.PledgeSectorContext(h.ctx)
+	require.NoError(t, err)
+	require.Equal(t, abi.SectorNumber(0), sid)
+
+	h.waitState(sid, sealing.CommitFailed)
+
+	h.sm.SetInvalidProofs(sid, false)
+	require.NoError(t, h.m.RetrySector(h.ctx, sid))
+	h.waitState(sid, sealing.Proving)
+
+	require.Equal(t, 2, h.sm.Calls(mock.StepCommit2))
+
+	// failed proofs are counted by events, so the log has them
+	rep, err := h.m.ReplaySector(sid)
+	require.NoError(t, err)
+	require.Empty(t, rep.Diffs)
+	require.Equal(t, uint64(1), rep.Replayed.InvalidProofs)
+}
+
+func TestPipelinePreCommitMessageFails(t *testing.T) {
+	h, stop := newHarness(t)
+	defer stop()
+
+	failed := false
+	h.chain.SetMessageHook(func(msg mock.Message, height abi.ChainEpoch) exitcode.ExitCode {
+		if msg.Method == builtin.MethodsMiner.PreCommitSector && !failed {
+			failed = true
+			return exitcode.SysErrOutOfGas
+		}
+		return exitcode.Ok
+	})
+
+	sid, err := h.m.PledgeSectorContext(h.ctx)
+	require.NoError(t, err)
+
+	h.waitState(sid, sealing.PreCommitFailed)
+	require.NoError(t, h.m.RetrySector(h.ctx, sid))
+	h.waitState(sid, sealing.Proving)
+
+	var precommits int
+	for _, msg := range h.chain.Messages() {
+		if msg.Method == builtin.MethodsMiner.PreCommitSector {
+			precommits++
+		}
+	}
+	require.Equal(t, 2, precommits)
+}
+
+func TestPipelineStageQueue(t *testing.T) {
+	h, stop := newHarness(t)
+	defer stop()
+
+	h.m.SetStageLimits(sealing.StageLimits{PreCommit1: 1})
+	h.sm.Inject(mock.StepPreCommit1, mock.Fault{Times: 1, Delay: 300 * time.Millisecond})
+
+	first, err := h.m.PledgeSectorContext(h.ctx)
+	require.NoError(t, err)
+	h.waitState(first, sealing.PreCommit1)
+
+	second, err := h.m.PledgeSectorContext(h.ctx)
+	require.NoError(t, err)
+
+	// the queued sector is marked in SectorInfo
+	waitSector(t, h.m, second, "queued", func(si sealing.SectorInfo) bool {
+		return si.State == sealing.PreCommit1 && si.Queued
+	})
+	st, pos, ok := h.m.QueuePosition(second)
+	require.True(t, ok)
+	require.Equal(t, sealing.PreCommit1, st)
+	require.Equal(t, 0, pos)
+
+	h.waitState(second, sealing.Proving)
+
+	si, err := h.m.GetSectorInfo(second)
+	require.NoError(t, err)
+	require.False(t, si.Queued)
+
+	var events []string
+	for _, l := range si.Log {
+		if l.From == sealing.PreCommit1 {
+			events = append(events, l.Event)
+		}
+	}
+	require.Equal(t, []string{"SectorQueued", "SectorStageStarted", "SectorPreCommit1"}, events)
+
+	rep, err := h.m.ReplaySector(second)
+	require.NoError(t, err)
+	require.Empty(t, rep.Diffs)
+}
+
+func TestPipelineFaultDelay(t *testing.T) {
+	h, stop := newHarness(t)
+	defer stop()
+
+	h.sm.Inject(mock.StepPreCommit2, mock.Fault{Delay: time.Hour})
+
+	sid, err := h.m.PledgeSectorContext(h.ctx)
+	require.NoError(t, err)
+
+	h.waitState(sid, sealing.PreCommit2)
+
+	// the delayed step is interrupted when the sector is aborted
+	require.NoError(t, h.m.AbortSector(h.ctx, sid, "test"))
+	h.waitState(sid, sealing.Aborted)
+	require.Empty(t, h.sm.Sectors())
+}
+
+func TestPipelineAbortRetry(t *testing.T) {
+	h, stop := newHarness(t)
+	defer stop()
+
+	h.sm.Inject(mock.StepPreCommit2, mock.Fault{Delay: time.Hour})
+	h.sm.Inject(mock.StepRemove, mock.Fault{Times: 1, Err: xerrors.New("disk gone")})
+
+	sid, err := h.m.PledgeSectorContext(h.ctx)
+	require.NoError(t, err)
+
+	h.waitState(sid, sealing.PreCommit2)
+
+	require.NoError(t, h.m.AbortSector(h.ctx, sid, "test"))
+	h.waitState(sid, sealing.AbortFailed)
+
+	require.NoError(t, h.m.RetrySector(h.ctx, sid))
+	h.waitState(sid, sealing.Aborted)
+	require.Empty(t, h.sm.Sectors())
+	require.Equal(t, 2, h.sm.Calls(mock.StepRemove))
+
+	si, err := h.m.GetSectorInfo(sid)
+	require.NoError(t, err)
+	require.Nil(t, si.PreCommit1OutRef)
+	require.Empty(t, h.storedBlobs())
+}
+
+func TestPipelineImport(t *testing.T) {
+	src, stopSrc := newHarness(t)
+	defer stopSrc()
+
+	sid, err := src.m.PledgeSectorContext(src.ctx)
+	require.NoError(t, err)
+	src.waitState(sid, sealing.Proving)
+
+	var export bytes.Buffer
+	require.NoError(t, src.m.ExportSectors(&export))
+
+	res, err := src.m.ImportSectors(src.ctx, bytes.NewReader(export.Bytes()), sealing.ImportOptions{DryRun: true})
+	require.NoError(t, err)
+	require.Len(t, res.Conflicts, 1)
+	require.Equal(t, sid, res.Conflicts[0].SectorNumber)
+
+	// move the sector back to FinalizeSector, so restarting it runs a handler
+	exp, err := sealing.ReadSectorExport(&export)
+	require.NoError(t, err)
+	require.Len(t, exp.Sectors, 1)
+	exp.Sectors[0].State = sealing.FinalizeSector
+	export.Reset()
+	require.NoError(t, sealing.WriteSectorExport(&export, exp))
+
+	dst, stopDst := newHarness(t)
+	defer stopDst()
+	require.NoError(t, dst.sm.NewSector(dst.ctx, abi.SectorID{Miner: 1000, Number: sid}))
+
+	res, err = dst.m.ImportSectors(dst.ctx, &export, sealing.ImportOptions{})
+	require.NoError(t, err)
+	require.True(t, res.Imported)
+	require.Equal(t, []abi.SectorNumber{sid}, res.Sectors)
+
+	dst.waitState(sid, sealing.Proving)
+	require.Equal(t, 1, dst.sm.Calls(mock.StepFinalizeSector))
+}
+
+func TestPipelineImportBlobs(t *testing.T) {
+	src, stopSrc := newHarness(t)
+	defer stopSrc()
+	src.sm.Inject(mock.StepPreCommit2, mock.Fault{Times: 1, Delay: 300 * time.Millisecond})
+
+	sid, err := src.m.PledgeSectorContext(src.ctx)
+	require.NoError(t, err)
+	src.waitState(sid, sealing.PreCommit2)
+
+	var export bytes.Buffer
+	require.NoError(t, src.m.ExportSectors(&export))
+
+	dst, stopDst := newHarness(t)
+	defer stopDst()
+	dst.sm.Inject(mock.StepPreCommit2, mock.Fault{Times: 1, Delay: 300 * time.Millisecond})
+
+	res, err := dst.m.ImportSectors(dst.ctx, &export, sealing.ImportOptions{})
+	require.NoError(t, err)
+	require.True(t, res.Imported)
+
+	// the PreCommit1 output came with the sector
+	si, err := dst.m.GetSectorInfo(sid)
+	require.NoError(t, err)
+	require.NotNil(t, si.PreCommit1OutRef)
+	require.Equal(t, src.storedBlobs(), dst.storedBlobs())
+}
+
+// precommitSector seals a CC sector with the sector manager, and pre-commits
+// it with deals, like sealing would, without tracking it
+func (h *harness) precommitSector(num abi.SectorNumber, deals ...abi.DealID) {
+	sid := abi.SectorID{Miner: 1000, Number: num}
+	size := abi.PaddedPieceSize(testSectorSize).Unpadded()
+
+	pi, err := h.sm.AddPiece(h.ctx, sid, nil, size, bytes.NewReader(make([]byte, size)))
+	require.NoError(h.t, err)
+
+	ticket, ticketEpoch, err := sealing.NewChainTicketFn(h.chain, h.maddr)(h.ctx)
+	require.NoError(h.t, err)
+	pc1o, err := h.sm.SealPreCommit1(h.ctx, sid, ticket, []abi.PieceInfo{pi})
+	require.NoError(h.t, err)
+	cids, err := h.sm.SealPreCommit2(h.ctx, sid, pc1o)
+	require.NoError(h.t, err)
+
+	_, rt, err := ffiwrapper.ProofTypeFromSectorSize(testSectorSize)
+	require.NoError(h.t, err)
+
+	params := new(bytes.Buffer)
+	require.NoError(h.t, (&miner.SectorPreCommitInfo{
+		RegisteredProof: rt,
+		SectorNumber:    num,
+		SealedCID:       cids.Sealed,
+		SealRandEpoch:   ticketEpoch,
+		DealIDs:         deals,
+		Expiration:      10000000,
+	}).MarshalCBOR(params))
+
+	mcid, err := h.chain.SendMsg(h.ctx, h.maddr, h.maddr, builtin.MethodsMiner.PreCommitSector, big.Zero(), big.Zero(), 0, params.Bytes())
+	require.NoError(h.t, err)
+	lookup, err := h.chain.StateWaitMsg(h.ctx, mcid)
+	require.NoError(h.t, err)
+	require.Equal(h.t, exitcode.Ok, lookup.Receipt.ExitCode)
+}
+
+func TestPipelineRecover(t *testing.T) {
+	src, stopSrc := newHarness(t)
+
+	proving, err := src.m.PledgeSectorContext(src.ctx)
+	require.NoError(t, err)
+	lost, err := src.m.PledgeSectorContext(src.ctx)
+	require.NoError(t, err)
+	src.waitState(proving, sealing.Proving)
+	src.waitState(lost, sealing.Proving)
+
+	stored, err := src.m.GetSectorInfo(proving)
+	require.NoError(t, err)
+
+	// a sector which was pre-committed when the datastore was lost, and one
+	// which lost its files too
+	waiting, failed := lost+1, lost+2
+	src.precommitSector(waiting)
+	src.precommitSector(failed)
+
+	stopSrc()
+	require.NoError(t, src.sm.Remove(context.Background(), abi.SectorID{Miner: 1000, Number: lost}))
+	require.NoError(t, src.sm.Remove(context.Background(), abi.SectorID{Miner: 1000, Number: failed}))
+
+	dst, stopDst := startHarness(t, src.maddr, src.chain, src.sm)
+	defer stopDst()
+
+	res, err := dst.m.RecoverSectors(dst.ctx, sealing.RecoverOptions{DryRun: true})
+	require.NoError(t, err)
+	require.False(t, res.Recovered)
+
+	states := map[abi.SectorNumber]sealing.SectorState{}
+	for _, rs := range res.Sectors {
+		states[rs.SectorNumber] = rs.State
+	}
+	require.Equal(t, map[abi.SectorNumber]sealing.SectorState{
+		proving: sealing.Proving,
+		lost:    sealing.Faulty,
+		waiting: sealing.WaitSeed,
+		failed:  sealing.FailedUnrecoverable,
+	}, states)
+
+	sectors, err := dst.m.ListSectors()
+	require.NoError(t, err)
+	require.Empty(t, sectors)
+
+	res, err = dst.m.RecoverSectors(dst.ctx, sealing.RecoverOptions{})
+	require.NoError(t, err)
+	require.True(t, res.Recovered)
+
+	// the recovered sector matches the lost record
+	recovered, err := dst.m.GetSectorInfo(proving)
+	require.NoError(t, err)
+	require.Equal(t, sealing.Proving, recovered.State)
+	require.Equal(t, stored.SectorType, recovered.SectorType)
+	require.Equal(t, stored.Pieces, recovered.Pieces)
+	require.Equal(t, stored.TicketValue, recovered.TicketValue)
+	require.Equal(t, stored.TicketEpoch, recovered.TicketEpoch)
+	require.Equal(t, stored.CommD, recovered.CommD)
+	require.Equal(t, stored.CommR, recovered.CommR)
+	require.Len(t, recovered.Overrides, 1)
+
+	// sealing continues
+	dst.waitState(waiting, sealing.Proving)
+	require.Contains(t, dst.chain.ProvenSectors(dst.maddr), waiting)
+	dst.waitState(lost, sealing.FaultedFinal)
+	dst.waitState(failed, sealing.FailedUnrecoverable)
+
+	// the sector without sealed files was restarted, its state machine still
+	// runs
+	require.NoError(t, dst.within(func() error { return dst.m.PauseSector(dst.ctx, failed) }))
+	waitSector(t, dst.m, failed, "paused", func(si sealing.SectorInfo) bool { return si.Paused })
+
+	res, err = dst.m.RecoverSectors(dst.ctx, sealing.RecoverOptions{})
+	require.NoError(t, err)
+	require.Empty(t, res.Sectors)
+	require.Equal(t, []abi.SectorNumber{proving, lost, waiting, failed}, res.Tracked)
+
+	// new sectors don't reuse recovered numbers
+	sid, err := dst.m.PledgeSectorContext(dst.ctx)
+	require.NoError(t, err)
+	require.Equal(t, failed+1, sid)
+}
+
+func TestPipelineRecoverDeals(t *testing.T) {
+	src, stopSrc := newHarness(t)
+
+	size := abi.PaddedPieceSize(testSectorSize).Unpadded()
+	data := make([]byte, size)
+	expiredDeal := src.chain.AddDeal(market.DealProposal{
+		PieceCID:   mock.PieceCommitment(data),
+		PieceSize:  size.Padded(),
+		Provider:   src.maddr,
+		StartEpoch: 100000,
+		EndEpoch:   200000,
+	})
+
+	expired, _, err := src.m.AllocatePieceContext(src.ctx, size)
+	require.NoError(t, err)
+	require.NoError(t, src.m.SealPiece(src.ctx, size, bytes.NewReader(data), expired, expiredDeal))
+	src.waitState(expired, sealing.Proving)
+
+	addDeal := func(padded abi.PaddedPieceSize, seed byte) (abi.DealID, abi.PieceInfo) {
+		pi := abi.PieceInfo{Size: padded, PieceCID: mock.PieceCommitment([]byte{seed})}
+		return src.chain.AddDeal(market.DealProposal{
+			PieceCID:   pi.PieceCID,
+			PieceSize:  pi.Size,
+			Provider:   src.maddr,
+			StartEpoch: 100000,
+			EndEpoch:   200000,
+		}), pi
+	}
+
+	// the large deal starts at its own size, after an alignment filler
+	small, smallPiece := addDeal(256, 1)
+	large, largePiece := addDeal(512, 2)
+	slashed, _ := addDeal(512, 3)
+
+	aligned, lostDeal := expired+1, expired+2
+	src.precommitSector(aligned, small, large)
+	src.precommitSector(lostDeal, slashed)
+
+	stopSrc()
+	src.chain.RemoveDeal(expiredDeal)
+	src.chain.RemoveDeal(slashed)
+
+	dst, stopDst := startHarness(t, src.maddr, src.chain, src.sm)
+	defer stopDst()
+
+	res, err := dst.m.RecoverSectors(dst.ctx, sealing.RecoverOptions{})
+	require.NoError(t, err)
+	require.True(t, res.Recovered)
+	require.Len(t, res.Sectors, 3)
+
+	// sectors with missing deals are recovered without pieces
+	for _, rs := range res.Sectors {
+		switch rs.SectorNumber {
+		case expired:
+			require.Equal(t, sealing.Proving, rs.State)
+			require.Contains(t, rs.Reason, "pieces can't be rebuilt")
+		case lostDeal:
+			require.Equal(t, sealing.FailedUnrecoverable, rs.State)
+			require.Contains(t, rs.Reason, "pieces can't be rebuilt")
+		default:
+			require.Equal(t, aligned, rs.SectorNumber)
+			require.Equal(t, sealing.WaitSeed, rs.State)
+		}
+	}
+
+	si, err := dst.m.GetSectorInfo(expired)
+	require.NoError(t, err)
+	require.Empty(t, si.Pieces)
+	require.Nil(t, si.CommD)
+
+	si, err = dst.m.GetSectorInfo(aligned)
+	require.NoError(t, err)
+	filler := func(padded abi.PaddedPieceSize) sealing.Piece {
+		return sealing.Piece{Size: padded.Unpadded(), CommP: zerocomm.ZeroPieceCommitment(padded.Unpadded())}
+	}
+	require.Equal(t, []sealing.Piece{
+		{DealID: &small, Size: smallPiece.Size.Unpadded(), CommP: smallPiece.PieceCID},
+		filler(256),
+		{DealID: &large, Size: largePiece.Size.Unpadded(), CommP: largePiece.PieceCID},
+		filler(1024),
+	}, si.Pieces)
+	require.NotNil(t, si.CommD)
+}
@@ -0,0 +1,273 @@
+package sealing
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/sector-storage/stores"
+	"github.com/filecoin-project/sector-storage/zerocomm"
+	"github.com/filecoin-project/specs-actors/actors/abi"
+	"github.com/filecoin-project/specs-actors/actors/builtin/miner"
+)
+
+// sealedFiles are the files a sector needs to compute its seal proof, and to
+// be proven
+const sealedFiles = stores.FTSealed | stores.FTCache
+
+// RecoverOptions control RecoverSectors
+type RecoverOptions struct {
+	// DryRun only reports sectors which would be recovered, nothing is stored
+	DryRun bool
+}
+
+// RecoveredSector is a sector found on chain, which sealing didn't track
+type RecoveredSector struct {
+	SectorNumber abi.SectorNumber
+	State        SectorState
+	// Files are the files of the sector the sector manager has
+	Files stores.SectorFileType
+	// Reason explains the state, it's recorded in SectorInfo.Overrides
+	Reason string
+}
+
+func (s RecoveredSector) String() string {
+	return fmt.Sprintf("sector %d: %s, %s", s.SectorNumber, s.State, s.Reason)
+}
+
+// RecoverResult lists sectors found on chain. Sectors which sealing already
+// tracks are left as they are
+type RecoverResult struct {
+	Sectors   []RecoveredSector
+	Tracked   []abi.SectorNumber
+	Recovered bool
+}
+
+// RecoverSectors rebuilds records of sectors which are pre-committed or proven
+// on chain, but aren't tracked, e.g. after the sector datastore was lost. The
+// sector manager has to implement SectorFileIndex, records are rebuilt from
+// chain state and cross-checked with the sector files it has:
+//   - pre-committed sectors with sealed files continue in WaitSeed, without
+//     sealed files they can't be proven, and are FailedUnrecoverable
+//   - proven sectors are Proving, or Faulty without sealed files
+//
+// Pieces are rebuilt the way handlePacking fills sectors, deal pieces first,
+// each aligned with filler pieces, followed by pledge pieces. Sectors with
+// deals which aren't on chain anymore are recovered without pieces, and
+// pre-committed ones are FailedUnrecoverable. Tickets are drawn like
+// NewChainTicketFn does.
+// Pre-commit messages, PreCommit1 output and proofs aren't recovered, and
+// sectors which are still sealing before pre-commit are lost.
+//
+// Like ImportSectors, it should be called after Run; recovered sectors are
+// restarted. StoredCounter skips numbers of recovered sectors
+func (m *Sealing) RecoverSectors(ctx context.Context, opts RecoverOptions) (*RecoverResult, error) {
+	index, ok := m.sealer.(SectorFileIndex)
+	if !ok {
+		return nil, xerrors.New("sector manager doesn't implement SectorFileIndex, see WithSectorIndex")
+	}
+
+	existing, err := m.ListSectors()
+	if err != nil {
+		return nil, xerrors.Errorf("listing sectors: %w", err)
+	}
+	tracked := map[abi.SectorNumber]struct{}{}
+	for _, si := range existing {
+		tracked[si.SectorNumber] = struct{}{}
+	}
+
+	tok, _, err := m.api.ChainHead(ctx)
+	if err != nil {
+		return nil, xerrors.Errorf("getting chain head: %w", err)
+	}
+
+	precommits, err := m.api.StateMinerPreCommittedSectors(ctx, m.maddr, tok)
+	if err != nil {
+		return nil, xerrors.Errorf("getting pre-committed sectors: %w", err)
+	}
+	proven, err := m.api.StateMinerSectors(ctx, m.maddr, tok)
+	if err != nil {
+		return nil, xerrors.Errorf("getting proven sectors: %w", err)
+	}
+
+	res := &RecoverResult{}
+	var sectors []SectorInfo
+
+	add := func(info miner.SectorPreCommitInfo, provenOnChain bool) error {
+		if _, ok := tracked[info.SectorNumber]; ok {
+			res.Tracked = append(res.Tracked, info.SectorNumber)
+			return nil
+		}
+
+		files, err := index.SectorFiles(ctx, m.minerSector(info.SectorNumber))
+		if err != nil {
+			return xerrors.Errorf("getting files of sector %d: %w", info.SectorNumber, err)
+		}
+
+		rs := RecoveredSector{SectorNumber: info.SectorNumber, Files: files}
+		sealed := files&sealedFiles == sealedFiles
+		switch {
+		case provenOnChain && sealed:
+			rs.State, rs.Reason = Proving, "recovered, proven on chain"
+		case provenOnChain:
+			rs.State, rs.Reason = Faulty, "recovered, proven on chain, but sealed sector files are missing"
+		case sealed:
+			rs.State, rs.Reason = WaitSeed, "recovered, pre-committed on chain"
+		default:
+			rs.State, rs.Reason = FailedUnrecoverable, "recovered, pre-committed on chain, but sealed sector files are missing"
+		}
+
+		si, err := m.recoveredSector(ctx, tok, info)
+		if err != nil {
+			return xerrors.Errorf("recovering sector %d: %w", info.SectorNumber, err)
+		}
+
+		pieces, commD, err := m.recoveredPieces(ctx, tok, info)
+		if err != nil {
+			// deals of the sector expired or were slashed, it can still be
+			// proven, but it can't be committed
+			log.Warnf("recovering pieces of sector %d: %+v", info.SectorNumber, err)
+			if rs.State == WaitSeed {
+				rs.State = FailedUnrecoverable
+			}
+			rs.Reason = fmt.Sprintf("%s, pieces can't be rebuilt: %s", rs.Reason, err)
+		} else {
+			si.Pieces, si.CommD = pieces, &commD
+		}
+
+		si.State = rs.State
+		si.Overrides = []StateOverride{{
+			Timestamp: uint64(time.Now().Unix()),
+			From:      UndefinedSectorState,
+			To:        rs.State,
+			Reason:    rs.Reason,
+		}}
+		if rs.State == FailedUnrecoverable {
+			si.LastErr = rs.Reason
+		}
+
+		res.Sectors = append(res.Sectors, rs)
+		sectors = append(sectors, si)
+		return nil
+	}
+
+	for _, pci := range precommits {
+		if err := add(pci.Info, false); err != nil {
+			return nil, err
+		}
+	}
+	for _, sector := range proven {
+		if err := add(sector.Info, true); err != nil {
+			return nil, err
+		}
+	}
+
+	sort.Slice(res.Sectors, func(i, j int) bool { return res.Sectors[i].SectorNumber < res.Sectors[j].SectorNumber })
+	sort.Slice(res.Tracked, func(i, j int) bool { return res.Tracked[i] < res.Tracked[j] })
+	sort.Slice(sectors, func(i, j int) bool { return sectors[i].SectorNumber < sectors[j].SectorNumber })
+
+	if opts.DryRun {
+		return res, nil
+	}
+
+	for i := range sectors {
+		if err := m.sectors.Begin(uint64(sectors[i].SectorNumber), &sectors[i]); err != nil {
+			return res, xerrors.Errorf("storing recovered sector %d: %w", sectors[i].SectorNumber, err)
+		}
+	}
+	res.Recovered = true
+
+	for _, si := range sectors {
+		if err := ctx.Err(); err != nil {
+			return res, xerrors.Errorf("restarting recovered sectors: %w", err)
+		}
+		if err := m.sectors.Send(uint64(si.SectorNumber), SectorRestart{}); err != nil {
+			return res, xerrors.Errorf("restarting recovered sector %d: %w", si.SectorNumber, err)
+		}
+	}
+
+	return res, nil
+}
+
+// recoveredSector rebuilds a sector from its on-chain pre-commit info,
+// without pieces, see recoveredPieces
+func (m *Sealing) recoveredSector(ctx context.Context, tok TipSetToken, info miner.SectorPreCommitInfo) (SectorInfo, error) {
+	ticket, err := chainTicket(ctx, m.api, m.maddr, tok, info.SealRandEpoch)
+	if err != nil {
+		return SectorInfo{}, xerrors.Errorf("getting ticket: %w", err)
+	}
+
+	commR := info.SealedCID
+	return SectorInfo{
+		Version:      SectorInfoVersion,
+		SectorNumber: info.SectorNumber,
+		SectorType:   info.RegisteredProof,
+		Priority:     DefaultPriority,
+
+		TicketValue:    ticket,
+		TicketEpoch:    info.SealRandEpoch,
+		TicketDeadline: ticketDeadline(info.SealRandEpoch),
+
+		CommR: &commR,
+	}, nil
+}
+
+// recoveredPieces rebuilds pieces of a sector, and its CommD, from the deals
+// in its pre-commit info. It fails if a deal isn't on chain anymore
+func (m *Sealing) recoveredPieces(ctx context.Context, tok TipSetToken, info miner.SectorPreCommitInfo) ([]Piece, cid.Cid, error) {
+	var pieces []Piece
+	var allocated abi.UnpaddedPieceSize
+	addFillers := func(sizes []abi.UnpaddedPieceSize) {
+		for _, size := range sizes {
+			pieces = append(pieces, Piece{
+				Size:  size,
+				CommP: zerocomm.ZeroPieceCommitment(size),
+			})
+			allocated += size
+		}
+	}
+
+	for _, id := range info.DealIDs {
+		id := id
+		proposal, _, err := m.api.StateMarketStorageDeal(ctx, id, tok)
+		if err != nil {
+			return nil, cid.Undef, xerrors.Errorf("getting deal %d: %w", id, err)
+		}
+
+		size := proposal.PieceSize.Unpadded()
+		fillerSizes, err := alignmentFillers(allocated, size)
+		if err != nil {
+			return nil, cid.Undef, err
+		}
+		addFillers(fillerSizes)
+
+		pieces = append(pieces, Piece{
+			DealID: &id,
+			Size:   size,
+			CommP:  proposal.PieceCID,
+		})
+		allocated += size
+	}
+
+	ubytes := abi.PaddedPieceSize(m.sealer.SectorSize()).Unpadded()
+	if allocated > ubytes {
+		return nil, cid.Undef, xerrors.Errorf("too much data in sector: %d > %d", allocated, ubytes)
+	}
+
+	fillerSizes, err := fillersFromRem(ubytes - allocated)
+	if err != nil {
+		return nil, cid.Undef, err
+	}
+	addFillers(fillerSizes)
+
+	commD, err := m.api.StateComputeDataCommitment(ctx, m.maddr, info.RegisteredProof, info.DealIDs, tok)
+	if err != nil {
+		return nil, cid.Undef, xerrors.Errorf("computing CommD: %w", err)
+	}
+
+	return pieces, commD, nil
+}
@@ -0,0 +1,276 @@
+package sealing
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"golang.org/x/xerrors"
+
+	statemachine "github.com/filecoin-project/go-statemachine"
+	"github.com/filecoin-project/specs-actors/actors/abi"
+)
+
+// replayEvents are all event types, by name, so that they can be decoded from
+// sector logs
+var replayEvents = eventTypes(
+	SectorRestart{},
+	SectorFatalError{},
+	SectorForceState{},
+	SectorPause{},
+	SectorResume{},
+	SectorSetPriority{},
+	SectorAbort{},
+	SectorRetry{},
+
+	SectorQueued{},
+	SectorStageStarted{},
+
+	SectorStart{},
+	SectorPacked{},
+	SectorPackingFailed{},
+	SectorPreCommit1{},
+	SectorPreCommit2{},
+	SectorTicketExpiring{},
+	SectorSealPreCommitFailed{},
+	SectorChainPreCommitFailed{},
+	SectorPreCommitted{},
+	SectorSeedReady{},
+	SectorComputeProofFailed{},
+	SectorCommitFailed{},
+	SectorCommitted{},
+	SectorProving{},
+	SectorFinalized{},
+	SectorAborted{},
+	SectorAbortFailed{},
+	SectorBlobsRemoved{},
+	SectorFinalizeFailed{},
+
+	SectorRetrySeal{},
+	SectorRetryPreCommit{},
+	SectorRetryWaitSeed{},
+	SectorRetryComputeProof{},
+	SectorRetryInvalidProof{},
+	SectorRetryAbort{},
+
+	SectorFaulty{},
+	SectorFaultReported{},
+	SectorFaultedFinal{},
+)
+
+func eventTypes(evts ...interface{}) map[string]reflect.Type {
+	out := map[string]reflect.Type{}
+	for _, evt := range evts {
+		out[eventName(evt)] = reflect.TypeOf(evt)
+	}
+	return out
+}
+
+// errorEvent is the underlying type of events which only wrap an error. The
+// error isn't in the JSON encoding of the event, it's in Log.Error
+var errorEvent = reflect.TypeOf(struct{ error }{})
+
+// decodeLogEvent decodes the event recorded in a log entry by plan
+func decodeLogEvent(l Log) (interface{}, error) {
+	name := l.Event
+	if name == "" {
+		// entries written before structured logs only have the Kind
+		name = strings.TrimPrefix(l.Kind, "event;sealing.")
+	}
+
+	typ, ok := replayEvents[name]
+	if !ok {
+		return nil, xerrors.Errorf("unknown event %q", name)
+	}
+
+	if typ.ConvertibleTo(errorEvent) {
+		msg := l.Error
+		if msg == "" {
+			msg = strings.SplitN(l.Trace, "\n", 2)[0]
+		}
+		return reflect.ValueOf(struct{ error }{xerrors.New(msg)}).Convert(typ).Interface(), nil
+	}
+
+	var evt struct{ User json.RawMessage }
+	if err := json.Unmarshal([]byte(l.Message), &evt); err != nil {
+		return nil, xerrors.Errorf("decoding %s: %w", name, err)
+	}
+
+	v := reflect.New(typ)
+	if err := json.Unmarshal(evt.User, v.Interface()); err != nil {
+		return nil, xerrors.Errorf("decoding %s: %w", name, err)
+	}
+	return upgradeLogEvent(v.Elem().Interface(), evt.User)
+}
+
+// upgradeLogEvent sets fields of events logged before blobs were moved to
+// the BlobStore. The references are computed like the record migration does
+func upgradeLogEvent(evt interface{}, raw json.RawMessage) (interface{}, error) {
+	var old struct {
+		PreCommit1Out []byte
+		Proof         []byte
+	}
+
+	switch e := evt.(type) {
+	case SectorPreCommit1:
+		if e.PreCommit1OutRef.Defined() {
+			return e, nil
+		}
+		if err := json.Unmarshal(raw, &old); err != nil {
+			return nil, xerrors.Errorf("decoding SectorPreCommit1: %w", err)
+		}
+		ref, err := blobRef(old.PreCommit1Out)
+		if err != nil {
+			return nil, err
+		}
+		e.PreCommit1OutRef = ref
+		return e, nil
+	case SectorCommitted:
+		if e.ProofRef.Defined() {
+			return e, nil
+		}
+		if err := json.Unmarshal(raw, &old); err != nil {
+			return nil, xerrors.Errorf("decoding SectorCommitted: %w", err)
+		}
+		ref, err := blobRef(old.Proof)
+		if err != nil {
+			return nil, err
+		}
+		e.ProofRef = ref
+		return e, nil
+	}
+	return evt, nil
+}
+
+// SectorFieldDiff is a SectorInfo field which differs between the stored and
+// the replayed sector. Values are JSON encoded
+type SectorFieldDiff struct {
+	Field    string
+	Stored   string
+	Replayed string
+}
+
+func (d SectorFieldDiff) String() string {
+	return fmt.Sprintf("%s: stored %s, replayed %s", d.Field, d.Stored, d.Replayed)
+}
+
+// SectorReplay is a sector rebuilt from its log, see ReplaySector
+type SectorReplay struct {
+	Replayed SectorInfo
+	Diffs    []SectorFieldDiff
+}
+
+// ReplaySector rebuilds a sector by running the events in its log through the
+// planners, starting in UndefinedSectorState, and compares the result with
+// the stored sector. Replaying sector histories with changed planners shows
+// how the change would handle them.
+//
+// The replayed sector keeps the stored Version and Log. Override timestamps
+// aren't part of SectorForceState, they're taken from its log entry. Entries
+// written before batches were recorded are replayed one at a time
+func ReplaySector(stored SectorInfo) (*SectorReplay, error) {
+	state := &SectorInfo{}
+
+	entries := stored.Log
+	for start := 0; start < len(entries); {
+		end := start + 1
+		for entries[start].Batch != 0 && end < len(entries) && entries[end].Batch == entries[start].Batch {
+			end++
+		}
+
+		events := make([]statemachine.Event, 0, end-start)
+		for i, l := range entries[start:end] {
+			evt, err := decodeLogEvent(l)
+			if err != nil {
+				return nil, xerrors.Errorf("decoding log entry %d: %w", start+i, err)
+			}
+			events = append(events, statemachine.Event{User: evt})
+		}
+
+		p, ok := fsmPlanners[state.State]
+		if !ok {
+			return nil, xerrors.Errorf("replaying log entry %d: planner for state %s not found", start, state.State)
+		}
+
+		overrides := len(state.Overrides)
+		if err := p.plan(events, state); err != nil {
+			return nil, xerrors.Errorf("replaying log entries %d-%d in state %s: %w", start, end-1, state.State, err)
+		}
+		for i := overrides; i < len(state.Overrides); i++ {
+			state.Overrides[i].Timestamp = entries[start].Timestamp
+		}
+
+		start = end
+	}
+
+	state.Version = stored.Version
+	state.Log = stored.Log
+
+	diffs, err := diffSectors(stored, *state)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SectorReplay{
+		Replayed: *state,
+		Diffs:    diffs,
+	}, nil
+}
+
+func diffSectors(stored, replayed SectorInfo) ([]SectorFieldDiff, error) {
+	// decoding doesn't keep nil slices, so compare decoded copies
+	stored, err := cborCopy(stored)
+	if err != nil {
+		return nil, xerrors.Errorf("copying stored sector: %w", err)
+	}
+	replayed, err = cborCopy(replayed)
+	if err != nil {
+		return nil, xerrors.Errorf("copying replayed sector: %w", err)
+	}
+
+	sv, rv := reflect.ValueOf(stored), reflect.ValueOf(replayed)
+
+	var out []SectorFieldDiff
+	for i := 0; i < sv.NumField(); i++ {
+		s, err := json.Marshal(sv.Field(i).Interface())
+		if err != nil {
+			return nil, xerrors.Errorf("encoding stored %s: %w", sv.Type().Field(i).Name, err)
+		}
+		r, err := json.Marshal(rv.Field(i).Interface())
+		if err != nil {
+			return nil, xerrors.Errorf("encoding replayed %s: %w", sv.Type().Field(i).Name, err)
+		}
+
+		if string(s) != string(r) {
+			out = append(out, SectorFieldDiff{
+				Field:    sv.Type().Field(i).Name,
+				Stored:   string(s),
+				Replayed: string(r),
+			})
+		}
+	}
+	return out, nil
+}
+
+func cborCopy(si SectorInfo) (SectorInfo, error) {
+	var buf bytes.Buffer
+	if err := si.MarshalCBOR(&buf); err != nil {
+		return SectorInfo{}, err
+	}
+
+	var out SectorInfo
+	err := out.UnmarshalCBOR(&buf)
+	return out, err
+}
+
+// ReplaySector rebuilds a sector from its log, see ReplaySector
+func (m *Sealing) ReplaySector(sid abi.SectorNumber) (*SectorReplay, error) {
+	si, err := m.GetSectorInfo(sid)
+	if err != nil {
+		return nil, xerrors.Errorf("getting sector %d: %w", sid, err)
+	}
+
+	return ReplaySector(si)
+}
@@ -0,0 +1,135 @@
+package sealing
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-statemachine"
+	"github.com/filecoin-project/specs-actors/actors/abi"
+	"github.com/filecoin-project/specs-actors/actors/builtin"
+)
+
+func TestReplayEventsComplete(t *testing.T) {
+	for _, name := range declaredEvents(t) {
+		require.Contains(t, replayEvents, name, "event %s can't be replayed", name)
+	}
+}
+
+// storedHistory plans a sector through sealing, with a failure, a forced state
+// and a batch of events, and returns it as stored in the datastore
+func storedHistory(t *testing.T) SectorInfo {
+	m := &Sealing{}
+	state := &SectorInfo{}
+
+	plan := func(evts ...interface{}) {
+		events := make([]statemachine.Event, len(evts))
+		for i, evt := range evts {
+			events[i] = statemachine.Event{User: evt}
+		}
+		_, err := m.plan(events, state)
+		require.NoError(t, err)
+	}
+
+	d := abi.DealID(12)
+	commD := builtin.StorageMarketActorCodeID
+	commR := builtin.StorageMinerActorCodeID
+	msg := builtin.AccountActorCodeID
+
+	plan(SectorStart{
+		ID:         5,
+		SectorType: abi.RegisteredProof_StackedDRG2KiBSeal,
+		Pieces:     []Piece{{DealID: &d, Size: 1016, CommP: builtin.PaymentChannelActorCodeID}},
+		Priority:   2,
+	})
+	plan(SectorPacked{})
+	plan(SectorPreCommit1{PreCommit1OutRef: *mustBlobRef([]byte{1, 2}), TicketValue: abi.SealRandomness{3}, TicketEpoch: 10})
+	plan(SectorSealPreCommitFailed{xerrors.New("disk on fire")})
+	plan(SectorRetrySeal{})
+	plan(SectorPreCommit1{PreCommit1OutRef: *mustBlobRef([]byte{4, 5}), TicketValue: abi.SealRandomness{6}, TicketEpoch: 20})
+	plan(SectorPreCommit2{Sealed: commR, Unsealed: commD})
+	plan(SectorPause{}, SectorSetPriority{Priority: 7})
+	plan(SectorResume{})
+	plan(SectorPreCommitted{Message: msg})
+	plan(SectorForceState{State: Committing, Reason: "seed landed"}, SectorSetPriority{Priority: 9})
+	plan(SectorCommitted{Message: msg, ProofRef: *mustBlobRef([]byte{8, 9})})
+	plan(SectorProving{})
+	plan(SectorFinalized{})
+	require.Equal(t, Proving, state.State)
+
+	var buf bytes.Buffer
+	require.NoError(t, state.MarshalCBOR(&buf))
+	stored, err := DecodeSectorInfo(buf.Bytes())
+	require.NoError(t, err)
+	return stored
+}
+
+func TestReplaySector(t *testing.T) {
+	stored := storedHistory(t)
+
+	rep, err := ReplaySector(stored)
+	require.NoError(t, err)
+	require.Empty(t, rep.Diffs)
+
+	require.Equal(t, Proving, rep.Replayed.State)
+	require.Equal(t, mustBlobRef([]byte{4, 5}), rep.Replayed.PreCommit1OutRef)
+	require.Equal(t, stored.Overrides, rep.Replayed.Overrides)
+
+	// SectorForceState interrupted its batch, SectorSetPriority after it is
+	// skipped on replay too
+	require.Equal(t, uint64(7), rep.Replayed.Priority)
+	require.Equal(t, stored.Paused, rep.Replayed.Paused)
+}
+
+func TestReplaySectorDiff(t *testing.T) {
+	stored := storedHistory(t)
+	stored.State = Faulty
+	stored.SeedEpoch = 30
+
+	rep, err := ReplaySector(stored)
+	require.NoError(t, err)
+	require.Equal(t, []SectorFieldDiff{
+		{Field: "State", Stored: `"Faulty"`, Replayed: `"Proving"`},
+		{Field: "SeedEpoch", Stored: `30`, Replayed: `0`},
+	}, rep.Diffs)
+}
+
+func TestReplaySectorUnbatchedLog(t *testing.T) {
+	stored := storedHistory(t)
+	for i := range stored.Log {
+		stored.Log[i].Batch = 0
+	}
+
+	// the batch is replayed one event at a time, so priority is set
+	rep, err := ReplaySector(stored)
+	require.NoError(t, err)
+	require.Equal(t, []SectorFieldDiff{
+		{Field: "Priority", Stored: `7`, Replayed: `9`},
+	}, rep.Diffs)
+}
+
+func TestReplaySectorRejected(t *testing.T) {
+	stored := storedHistory(t)
+	stored.Log = append(stored.Log[:1], stored.Log[2:]...) // drop SectorPacked
+
+	_, err := ReplaySector(stored)
+	require.Error(t, err)
+
+	stored.Log[0].Event = "SectorNotAnEvent"
+	_, err = ReplaySector(stored)
+	require.Error(t, err)
+}
+
+func TestReplayLegacyBlobEvents(t *testing.T) {
+	// events logged before blobs were moved to the BlobStore
+	evt, err := decodeLogEvent(Log{Event: "SectorPreCommit1", Message: `{"User":{"PreCommit1Out":"AQI=","TicketValue":"Aw==","TicketEpoch":10}}`})
+	require.NoError(t, err)
+	require.Equal(t, SectorPreCommit1{PreCommit1OutRef: *mustBlobRef([]byte{1, 2}), TicketValue: abi.SealRandomness{3}, TicketEpoch: 10}, evt)
+
+	msg := builtin.AccountActorCodeID
+	evt, err = decodeLogEvent(Log{Event: "SectorCommitted", Message: `{"User":{"Message":{"/":"` + msg.String() + `"},"Proof":"CAk="}}`})
+	require.NoError(t, err)
+	require.Equal(t, SectorCommitted{Message: msg, ProofRef: *mustBlobRef([]byte{8, 9})}, evt)
+}
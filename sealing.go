@@ -3,6 +3,8 @@ package sealing
 import (
 	"context"
 	"io"
+	"sync"
+	"time"
 
 	"github.com/ipfs/go-cid"
 	"github.com/ipfs/go-datastore"
@@ -24,18 +26,24 @@ import (
 
 const SectorStorePrefix = "/sectors"
 
+// DefaultPriority is the sealing priority of sectors which don't set one
+const DefaultPriority = 0
+
 var log = logging.Logger("sectors")
 
 type SealingAPI interface {
 	StateWaitMsg(context.Context, cid.Cid) (MsgLookup, error)
 	StateComputeDataCommitment(ctx context.Context, maddr address.Address, sectorType abi.RegisteredProof, deals []abi.DealID, tok TipSetToken) (cid.Cid, error)
 	StateSectorPreCommitInfo(ctx context.Context, maddr address.Address, sectorNumber abi.SectorNumber, tok TipSetToken) (*miner.SectorPreCommitOnChainInfo, error)
+	StateMinerPreCommittedSectors(ctx context.Context, maddr address.Address, tok TipSetToken) ([]*miner.SectorPreCommitOnChainInfo, error)
+	StateMinerSectors(ctx context.Context, maddr address.Address, tok TipSetToken) ([]*miner.SectorOnChainInfo, error)
 	StateMinerSectorSize(context.Context, address.Address, TipSetToken) (abi.SectorSize, error)
 	StateMarketStorageDeal(context.Context, abi.DealID, TipSetToken) (market.DealProposal, market.DealState, error)
 	SendMsg(ctx context.Context, from, to address.Address, method abi.MethodNum, value, gasPrice big.Int, gasLimit int64, params []byte) (cid.Cid, error)
 	ChainHead(ctx context.Context) (TipSetToken, abi.ChainEpoch, error)
 	ChainGetRandomness(ctx context.Context, tok TipSetToken, personalization crypto.DomainSeparationTag, randEpoch abi.ChainEpoch, entropy []byte) (abi.Randomness, error)
 	ChainReadObj(context.Context, cid.Cid) ([]byte, error)
+	WalletBalance(context.Context, address.Address) (big.Int, error)
 }
 
 type Sealing struct {
@@ -45,32 +53,89 @@ type Sealing struct {
 	maddr  address.Address
 	worker address.Address
 
+	ds      datastore.Batching
 	sealer  sectorstorage.SectorManager
 	sectors *statemachine.StateGroup
 	sc      SectorIDCounter
 	verif   ffiwrapper.Verifier
 	tktFn   TicketFn
+	release DealReleaseFn
+	blobs   BlobStore
+
+	handlerLk sync.Mutex
+	handlers  map[abi.SectorNumber]context.CancelFunc
+
+	stages *stageLimiter
+
+	ticketLk           sync.Mutex
+	sealDuration       abi.ChainEpoch
+	preCommit2Duration abi.ChainEpoch
+
+	// lifecycle, all background work is tracked in `work`, and stops when
+	// lifeCtx is cancelled
+	lifeCtx  context.Context
+	cancel   context.CancelFunc
+	workLk   sync.Mutex
+	stopping chan struct{}
+	work     sync.WaitGroup
+	stopOnce sync.Once
+	stopErr  error
+
+	autoPledgeLk   sync.Mutex
+	autoPledgeStop chan struct{}
 }
 
-func New(api SealingAPI, events Events, maddr address.Address, worker address.Address, ds datastore.Batching, sealer sectorstorage.SectorManager, sc SectorIDCounter, verif ffiwrapper.Verifier, tktFn TicketFn) *Sealing {
+// New creates a Sealing instance. If tktFn is nil, tickets are drawn from the
+// chain with NewChainTicketFn. If blobs is nil, blobs are kept in ds, see
+// DatastoreBlobStore; miners should use an FSBlobStore
+func New(api SealingAPI, events Events, maddr address.Address, worker address.Address, ds datastore.Batching, sealer sectorstorage.SectorManager, sc SectorIDCounter, verif ffiwrapper.Verifier, tktFn TicketFn, release DealReleaseFn, blobs BlobStore) *Sealing {
 	s := &Sealing{
 		api:    api,
 		events: events,
 
 		maddr:  maddr,
 		worker: worker,
+		ds:     ds,
 		sealer: sealer,
 		sc:     sc,
 		verif:  verif,
 		tktFn:  tktFn,
+
+		release: release,
+		blobs:   blobs,
+
+		handlers: map[abi.SectorNumber]context.CancelFunc{},
+		stages:   newStageLimiter(),
+
+		sealDuration:       DefaultSealDuration,
+		preCommit2Duration: DefaultPreCommit2Duration,
+
+		stopping: make(chan struct{}),
+	}
+
+	if s.tktFn == nil {
+		s.tktFn = NewChainTicketFn(api, maddr)
+	}
+	if s.blobs == nil {
+		s.blobs = NewDatastoreBlobStore(namespace.Wrap(ds, datastore.NewKey(BlobStorePrefix)))
 	}
 
+	s.lifeCtx, s.cancel = context.WithCancel(context.Background())
 	s.sectors = statemachine.New(namespace.Wrap(ds, datastore.NewKey(SectorStorePrefix)), s, SectorInfo{})
 
 	return s
 }
 
 func (m *Sealing) Run(ctx context.Context) error {
+	if err := migrateSectors(m.ds, m.blobs); err != nil {
+		return xerrors.Errorf("migrating sector records: %w", err)
+	}
+
+	if err := m.sweepBlobs(ctx); err != nil {
+		// leftover blobs only take up space
+		log.Errorf("deleting unreferenced blobs: %+v", err)
+	}
+
 	if err := m.restartSectors(ctx); err != nil {
 		log.Errorf("%+v", err)
 		return xerrors.Errorf("failed load sector states: %w", err)
@@ -79,11 +144,92 @@ func (m *Sealing) Run(ctx context.Context) error {
 	return nil
 }
 
+// stopGrace is how long Stop waits for work to return after cancelling it
+const stopGrace = 5 * time.Second
+
+// Stop waits for running handlers, chain callbacks and pledges to finish. If
+// ctx is done before that, their context is cancelled, and events they send
+// are dropped, so sectors stay in the state they were in. Cancelled work is
+// given stopGrace to return, sector state machines are stopped either way.
+// Only the first call stops sealing, later calls return its result
 func (m *Sealing) Stop(ctx context.Context) error {
-	return m.sectors.Stop(ctx)
+	m.stopOnce.Do(func() {
+		m.stopErr = m.stop(ctx)
+	})
+	return m.stopErr
+}
+
+func (m *Sealing) stop(ctx context.Context) error {
+	m.workLk.Lock()
+	close(m.stopping)
+	m.workLk.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		m.work.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		m.cancel()
+		return m.sectors.Stop(ctx)
+	case <-ctx.Done():
+	}
+
+	log.Warn("timed out waiting for sealing work to finish, cancelling")
+	m.cancel()
+
+	graceCtx, cancel := context.WithTimeout(context.Background(), stopGrace)
+	defer cancel()
+
+	select {
+	case <-done:
+	case <-graceCtx.Done():
+		log.Error("sealing work didn't return after it was cancelled")
+	}
+
+	if err := m.sectors.Stop(graceCtx); err != nil {
+		log.Errorf("stopping sector state machines: %+v", err)
+	}
+
+	return xerrors.Errorf("waiting for sealing work: %w", ctx.Err())
+}
+
+// startWork tracks a background task, it returns false if sealing is stopping
+func (m *Sealing) startWork() (func(), bool) {
+	m.workLk.Lock()
+	defer m.workLk.Unlock()
+
+	select {
+	case <-m.stopping:
+		return nil, false
+	default:
+	}
+
+	m.work.Add(1)
+	return m.work.Done, true
+}
+
+// chainAt registers a chain callback which doesn't run once sealing is stopping
+func (m *Sealing) chainAt(hnd HeightHandler, rev RevertHandler, confidence int, h abi.ChainEpoch) error {
+	return m.events.ChainAt(func(ctx context.Context, tok TipSetToken, curH abi.ChainEpoch) error {
+		done, ok := m.startWork()
+		if !ok {
+			log.Warnf("sealing stopping, skipping chain callback at height %d", curH)
+			return nil
+		}
+		defer done()
+
+		return hnd(ctx, tok, curH)
+	}, rev, confidence, h)
 }
 
 func (m *Sealing) AllocatePiece(size abi.UnpaddedPieceSize) (sectorID abi.SectorNumber, offset uint64, err error) {
+	return m.AllocatePieceContext(context.TODO(), size)
+}
+
+func (m *Sealing) AllocatePieceContext(ctx context.Context, size abi.UnpaddedPieceSize) (sectorID abi.SectorNumber, offset uint64, err error) {
 	if (padreader.PaddedSize(uint64(size))) != size {
 		return 0, 0, xerrors.Errorf("cannot allocate unpadded piece")
 	}
@@ -93,8 +239,9 @@ func (m *Sealing) AllocatePiece(size abi.UnpaddedPieceSize) (sectorID abi.Sector
 		return 0, 0, xerrors.Errorf("getting sector number: %w", err)
 	}
 
-	err = m.sealer.NewSector(context.TODO(), m.minerSector(sid)) // TODO: Put more than one thing in a sector
+	err = m.sealer.NewSector(ctx, m.minerSector(sid)) // TODO: Put more than one thing in a sector
 	if err != nil {
+		m.burnSector(sid, err)
 		return 0, 0, xerrors.Errorf("initializing sector: %w", err)
 	}
 
@@ -103,6 +250,12 @@ func (m *Sealing) AllocatePiece(size abi.UnpaddedPieceSize) (sectorID abi.Sector
 }
 
 func (m *Sealing) SealPiece(ctx context.Context, size abi.UnpaddedPieceSize, r io.Reader, sectorID abi.SectorNumber, dealID abi.DealID) error {
+	return m.SealPieceWithPriority(ctx, size, r, sectorID, dealID, DefaultPriority)
+}
+
+// SealPieceWithPriority is like SealPiece, but sets sealing priority of the
+// sector, see SetSectorPriority
+func (m *Sealing) SealPieceWithPriority(ctx context.Context, size abi.UnpaddedPieceSize, r io.Reader, sectorID abi.SectorNumber, dealID abi.DealID, priority uint64) error {
 	log.Infof("Seal piece for deal %d", dealID)
 
 	ppi, err := m.sealer.AddPiece(ctx, m.minerSector(sectorID), []abi.UnpaddedPieceSize{}, size, r)
@@ -122,18 +275,31 @@ func (m *Sealing) SealPiece(ctx context.Context, size abi.UnpaddedPieceSize, r i
 			Size:  ppi.Size.Unpadded(),
 			CommP: ppi.PieceCID,
 		},
-	})
+	}, priority)
 }
 
-func (m *Sealing) newSector(sid abi.SectorNumber, rt abi.RegisteredProof, pieces []Piece) error {
+func (m *Sealing) newSector(sid abi.SectorNumber, rt abi.RegisteredProof, pieces []Piece, priority uint64) error {
 	log.Infof("Start sealing %d", sid)
 	return m.sectors.Send(uint64(sid), SectorStart{
 		ID:         sid,
 		Pieces:     pieces,
 		SectorType: rt,
+		Priority:   priority,
 	})
 }
 
+// burnSector records a sector number which was allocated, but won't be used
+func (m *Sealing) burnSector(sid abi.SectorNumber, cause error) {
+	b, ok := m.sc.(SectorNumberBurner)
+	if !ok {
+		return
+	}
+
+	if err := b.Burn(sid, cause.Error()); err != nil {
+		log.Errorf("recording burned sector number %d: %+v", sid, err)
+	}
+}
+
 func (m *Sealing) minerSector(num abi.SectorNumber) abi.SectorID {
 	mid, err := address.IDFromAddress(m.maddr)
 	if err != nil {
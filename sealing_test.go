@@ -0,0 +1,104 @@
+package sealing
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/go-address"
+)
+
+func TestStopWaitsForWork(t *testing.T) {
+	ds := dssync.MutexWrap(datastore.NewMapDatastore())
+	m := New(nil, nil, testMaddr(t), testMaddr(t), ds, nil, nil, nil, nil, nil, dsBlobs(ds))
+
+	done, ok := m.startWork()
+	require.True(t, ok)
+
+	stopped := make(chan error)
+	go func() {
+		stopped <- m.Stop(context.Background())
+	}()
+
+	select {
+	case <-stopped:
+		t.Fatal("stop returned before work was done")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	_, ok = m.startWork()
+	require.False(t, ok)
+
+	done()
+	require.NoError(t, <-stopped)
+	require.Error(t, m.lifeCtx.Err())
+}
+
+func TestStopDeadline(t *testing.T) {
+	ds := dssync.MutexWrap(datastore.NewMapDatastore())
+	m := New(nil, nil, testMaddr(t), testMaddr(t), ds, nil, nil, nil, nil, nil, dsBlobs(ds))
+
+	done, ok := m.startWork()
+	require.True(t, ok)
+
+	// work returns once it's cancelled
+	returned := make(chan struct{})
+	go func() {
+		<-m.lifeCtx.Done()
+		time.Sleep(20 * time.Millisecond)
+		close(returned)
+		done()
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	require.Error(t, m.Stop(ctx))
+	require.Error(t, m.lifeCtx.Err())
+
+	select {
+	case <-returned:
+	default:
+		t.Fatal("stop didn't wait for cancelled work")
+	}
+}
+
+func TestStopTwice(t *testing.T) {
+	ds := dssync.MutexWrap(datastore.NewMapDatastore())
+
+	// a running sector, so there is a state machine to stop
+	var buf bytes.Buffer
+	require.NoError(t, (&SectorInfo{Version: SectorInfoVersion, State: Proving, SectorNumber: 1}).MarshalCBOR(&buf))
+	require.NoError(t, ds.Put(sectorKey(1), buf.Bytes()))
+
+	m := New(nil, nil, testMaddr(t), testMaddr(t), ds, nil, nil, nil, nil, nil, nil)
+	require.NoError(t, m.Run(context.Background()))
+
+	_, err := m.GetSectorInfo(1)
+	require.NoError(t, err)
+
+	require.NoError(t, m.Stop(context.Background()))
+	require.NoError(t, m.Stop(context.Background()))
+}
+
+func TestStopAfterFailedRun(t *testing.T) {
+	ds := dssync.MutexWrap(datastore.NewMapDatastore())
+	require.NoError(t, ds.Put(sectorKey(1), []byte{0xff}))
+
+	m := New(nil, nil, testMaddr(t), testMaddr(t), ds, nil, nil, nil, nil, nil, nil)
+	require.Error(t, m.Run(context.Background()))
+
+	require.NoError(t, m.Stop(context.Background()))
+	require.NoError(t, m.Stop(context.Background()))
+}
+
+func testMaddr(t *testing.T) address.Address {
+	maddr, err := address.NewIDAddress(1000)
+	require.NoError(t, err)
+	return maddr
+}
@@ -0,0 +1,88 @@
+package sealing
+
+import (
+	"context"
+
+	"golang.org/x/xerrors"
+
+	sectorstorage "github.com/filecoin-project/sector-storage"
+	"github.com/filecoin-project/sector-storage/stores"
+	"github.com/filecoin-project/specs-actors/actors/abi"
+)
+
+// sectorFileTypes are the files a sector can have in storage
+var sectorFileTypes = []stores.SectorFileType{stores.FTUnsealed, stores.FTSealed, stores.FTCache}
+
+// WithSectorIndex adds a SectorFileIndex to a sector manager, backed by the
+// sector-storage index the manager declares sector files in
+func WithSectorIndex(sealer sectorstorage.SectorManager, index stores.SectorIndex) sectorstorage.SectorManager {
+	return &indexedSealer{SectorManager: sealer, index: index}
+}
+
+type indexedSealer struct {
+	sectorstorage.SectorManager
+	index stores.SectorIndex
+}
+
+// StorageLocal and FsStat are forwarded to the wrapped sector manager, so
+// auto-pledge can check free space, see AutoPledgeConfig.MinFreeSpace
+
+func (s *indexedSealer) StorageLocal(ctx context.Context) (map[stores.ID]string, error) {
+	ls, ok := s.SectorManager.(localStorage)
+	if !ok {
+		return nil, xerrors.New("sector manager can't report local storage")
+	}
+	return ls.StorageLocal(ctx)
+}
+
+func (s *indexedSealer) FsStat(ctx context.Context, id stores.ID) (stores.FsStat, error) {
+	ls, ok := s.SectorManager.(localStorage)
+	if !ok {
+		return stores.FsStat{}, xerrors.New("sector manager can't report local storage")
+	}
+	return ls.FsStat(ctx, id)
+}
+
+func (s *indexedSealer) SectorFiles(ctx context.Context, sector abi.SectorID) (stores.SectorFileType, error) {
+	var out stores.SectorFileType
+	for _, ft := range sectorFileTypes {
+		found, err := s.index.StorageFindSector(ctx, sector, ft, false)
+		if err != nil {
+			return stores.FTNone, xerrors.Errorf("finding %s of sector %d: %w", ft, sector.Number, err)
+		}
+		if len(found) > 0 {
+			out |= ft
+		}
+	}
+	return out, nil
+}
+
+// WithSectorStore adds a SectorFileIndex and a SectorRemover to a sector
+// manager. Sector files are removed from the store the manager keeps them in,
+// usually the stores.Remote it was created with
+func WithSectorStore(sealer sectorstorage.SectorManager, index stores.SectorIndex, store stores.Store) sectorstorage.SectorManager {
+	return &storeSealer{indexedSealer: indexedSealer{SectorManager: sealer, index: index}, store: store}
+}
+
+type storeSealer struct {
+	indexedSealer
+	store stores.Store
+}
+
+func (s *storeSealer) Remove(ctx context.Context, sector abi.SectorID) error {
+	files, err := s.SectorFiles(ctx, sector)
+	if err != nil {
+		return err
+	}
+
+	// stores remove one file type at a time, and fail on missing files
+	for _, ft := range sectorFileTypes {
+		if files&ft == 0 {
+			continue
+		}
+		if err := s.store.Remove(ctx, sector, ft); err != nil {
+			return xerrors.Errorf("removing %s of sector %d: %w", ft, sector.Number, err)
+		}
+	}
+	return nil
+}
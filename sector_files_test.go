@@ -0,0 +1,108 @@
+package sealing
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/go-address"
+	sectorstorage "github.com/filecoin-project/sector-storage"
+	"github.com/filecoin-project/sector-storage/stores"
+	"github.com/filecoin-project/specs-actors/actors/abi"
+	"github.com/filecoin-project/specs-actors/actors/abi/big"
+)
+
+func TestWithSectorIndex(t *testing.T) {
+	ctx := context.Background()
+
+	index := stores.NewIndex()
+	require.NoError(t, index.StorageAttach(ctx, stores.StorageInfo{ID: "seal", URLs: []string{"http://seal"}}, stores.FsStat{}))
+	require.NoError(t, index.StorageAttach(ctx, stores.StorageInfo{ID: "store", URLs: []string{"http://store"}}, stores.FsStat{}))
+
+	unsealed := abi.SectorID{Miner: 1000, Number: 1}
+	sealed := abi.SectorID{Miner: 1000, Number: 2}
+	require.NoError(t, index.StorageDeclareSector(ctx, "seal", unsealed, stores.FTUnsealed))
+	require.NoError(t, index.StorageDeclareSector(ctx, "seal", sealed, stores.FTUnsealed))
+	require.NoError(t, index.StorageDeclareSector(ctx, "store", sealed, stores.FTSealed|stores.FTCache))
+
+	files := WithSectorIndex(nil, index).(SectorFileIndex)
+
+	ft, err := files.SectorFiles(ctx, unsealed)
+	require.NoError(t, err)
+	require.Equal(t, stores.FTUnsealed, ft)
+
+	ft, err = files.SectorFiles(ctx, sealed)
+	require.NoError(t, err)
+	require.Equal(t, stores.FTUnsealed|stores.FTSealed|stores.FTCache, ft)
+
+	// other storage isn't offered for fetching
+	ft, err = files.SectorFiles(ctx, abi.SectorID{Miner: 1000, Number: 3})
+	require.NoError(t, err)
+	require.Equal(t, stores.FTNone, ft)
+}
+
+type testStore struct {
+	stores.Store
+	removed []stores.SectorFileType
+}
+
+func (s *testStore) Remove(ctx context.Context, sector abi.SectorID, ft stores.SectorFileType) error {
+	s.removed = append(s.removed, ft)
+	return nil
+}
+
+func TestWithSectorStore(t *testing.T) {
+	ctx := context.Background()
+
+	index := stores.NewIndex()
+	require.NoError(t, index.StorageAttach(ctx, stores.StorageInfo{ID: "seal", URLs: []string{"http://seal"}}, stores.FsStat{}))
+
+	sector := abi.SectorID{Miner: 1000, Number: 1}
+	require.NoError(t, index.StorageDeclareSector(ctx, "seal", sector, stores.FTUnsealed))
+	require.NoError(t, index.StorageDeclareSector(ctx, "seal", sector, stores.FTCache))
+
+	store := &testStore{}
+	sealer := WithSectorStore(nil, index, store)
+	require.Implements(t, (*SectorFileIndex)(nil), sealer)
+
+	// only files the sector has are removed, one type at a time
+	require.NoError(t, sealer.(SectorRemover).Remove(ctx, sector))
+	require.Equal(t, []stores.SectorFileType{stores.FTUnsealed, stores.FTCache}, store.removed)
+}
+
+type testLocalSealer struct {
+	sectorstorage.SectorManager
+	available uint64
+}
+
+func (s *testLocalSealer) StorageLocal(ctx context.Context) (map[stores.ID]string, error) {
+	return map[stores.ID]string{"seal": "/seal"}, nil
+}
+
+func (s *testLocalSealer) FsStat(ctx context.Context, id stores.ID) (stores.FsStat, error) {
+	return stores.FsStat{Available: s.available}, nil
+}
+
+type testBalanceAPI struct {
+	SealingAPI
+}
+
+func (testBalanceAPI) WalletBalance(context.Context, address.Address) (big.Int, error) {
+	return big.NewInt(100), nil
+}
+
+func TestSectorIndexFreeSpace(t *testing.T) {
+	ctx := context.Background()
+	index := stores.NewIndex()
+
+	// free space is reported through the wrapper
+	m := &Sealing{api: testBalanceAPI{}, sealer: WithSectorStore(&testLocalSealer{available: 10}, index, &testStore{})}
+	require.NoError(t, m.checkPledgeResources(ctx, AutoPledgeConfig{MinWorkerBalance: big.Zero(), MinFreeSpace: 10}))
+	require.Error(t, m.checkPledgeResources(ctx, AutoPledgeConfig{MinWorkerBalance: big.Zero(), MinFreeSpace: 11}))
+
+	// a sealer which can't report free space doesn't pass the check
+	m.sealer = WithSectorIndex(nil, index)
+	require.NoError(t, m.checkPledgeResources(ctx, AutoPledgeConfig{MinWorkerBalance: big.Zero()}))
+	require.Error(t, m.checkPledgeResources(ctx, AutoPledgeConfig{MinWorkerBalance: big.Zero(), MinFreeSpace: 1}))
+}
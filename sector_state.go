@@ -2,6 +2,77 @@ package sealing
 
 type SectorState string
 
+// sealingStates are states of sectors in the sealing pipeline
+var sealingStates = map[SectorState]struct{}{
+	UndefinedSectorState: {}, // started, SectorStart isn't processed yet
+
+	Packing:       {},
+	PreCommit1:    {},
+	PreCommit2:    {},
+	PreCommitting: {},
+	WaitSeed:      {},
+	Committing:    {},
+	CommitWait:    {},
+}
+
+// cooldownStates are failed states which wait a while before retrying
+var cooldownStates = map[SectorState]struct{}{
+	SealFailed:         {},
+	PreCommitFailed:    {},
+	ComputeProofFailed: {},
+	CommitFailed:       {},
+	AbortFailed:        {},
+}
+
+// failedStates are states sectors end up in when sealing or proving failed
+var failedStates = map[SectorState]struct{}{
+	FailedUnrecoverable: {},
+	SealFailed:          {},
+	PreCommitFailed:     {},
+	ComputeProofFailed:  {},
+	CommitFailed:        {},
+	PackingFailed:       {},
+	Faulty:              {},
+	FaultReported:       {},
+	FaultedFinal:        {},
+	AbortFailed:         {},
+}
+
+// abortableStates are states sectors can be aborted in, if they weren't
+// pre-committed
+var abortableStates = map[SectorState]struct{}{
+	Packing:       {},
+	PreCommit1:    {},
+	PreCommit2:    {},
+	SealFailed:    {},
+	PackingFailed: {},
+}
+
+var ExistSectorStateList = map[SectorState]struct{}{
+	Empty:               {},
+	Packing:             {},
+	PreCommit1:          {},
+	PreCommit2:          {},
+	PreCommitting:       {},
+	WaitSeed:            {},
+	Committing:          {},
+	CommitWait:          {},
+	FinalizeSector:      {},
+	Proving:             {},
+	FailedUnrecoverable: {},
+	SealFailed:          {},
+	PreCommitFailed:     {},
+	ComputeProofFailed:  {},
+	CommitFailed:        {},
+	PackingFailed:       {},
+	Faulty:              {},
+	FaultReported:       {},
+	FaultedFinal:        {},
+	Aborting:            {},
+	AbortFailed:         {},
+	Aborted:             {},
+}
+
 const (
 	UndefinedSectorState SectorState = ""
 
@@ -26,4 +97,8 @@ const (
 	Faulty              SectorState = "Faulty"        // sector is corrupted or gone for some reason
 	FaultReported       SectorState = "FaultReported" // sector has been declared as a fault on chain
 	FaultedFinal        SectorState = "FaultedFinal"  // fault declared on chain
+
+	Aborting    SectorState = "Aborting"    // releasing deals and removing sector data
+	AbortFailed SectorState = "AbortFailed" // releasing deals or removing sector data failed
+	Aborted     SectorState = "Aborted"
 )
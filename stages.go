@@ -0,0 +1,313 @@
+package sealing
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/specs-actors/actors/abi"
+)
+
+// StageLimits limits how many sectors can run a sealing stage at once. Zero
+// means no limit
+type StageLimits struct {
+	PreCommit1 int
+	PreCommit2 int
+	Committing int
+}
+
+// StageStatus describes sectors running or waiting for a sealing stage
+type StageStatus struct {
+	Limit   int
+	Running []abi.SectorNumber
+	Queued  []abi.SectorNumber // in the order they'll run
+}
+
+// stageLimiter queues sectors waiting for a sealing stage, ordered by priority,
+// and in FIFO order within the same priority
+type stageLimiter struct {
+	lk     sync.Mutex
+	stages map[SectorState]*stageQueue
+}
+
+type stageQueue struct {
+	limit   int
+	running map[abi.SectorNumber]struct{}
+	waiting []*stageWaiter
+}
+
+type stageWaiter struct {
+	sector   abi.SectorNumber
+	priority uint64
+	ready    chan struct{}
+}
+
+func newStageLimiter() *stageLimiter {
+	return &stageLimiter{
+		stages: map[SectorState]*stageQueue{},
+	}
+}
+
+// stage must be called with lk held
+func (l *stageLimiter) stage(st SectorState) *stageQueue {
+	q, ok := l.stages[st]
+	if !ok {
+		q = &stageQueue{running: map[abi.SectorNumber]struct{}{}}
+		l.stages[st] = q
+	}
+	return q
+}
+
+func (l *stageLimiter) setLimits(limits StageLimits) {
+	l.lk.Lock()
+	defer l.lk.Unlock()
+
+	for st, limit := range map[SectorState]int{
+		PreCommit1: limits.PreCommit1,
+		PreCommit2: limits.PreCommit2,
+		Committing: limits.Committing,
+	} {
+		q := l.stage(st)
+		q.limit = limit
+		q.dispatch()
+	}
+}
+
+// acquire waits for a free slot in the stage. The returned function must be
+// called to release the slot
+func (l *stageLimiter) acquire(ctx context.Context, st SectorState, sector abi.SectorNumber, priority uint64) (func(), error) {
+	if !l.reserve(st, sector, priority) {
+		if err := l.wait(ctx, st, sector); err != nil {
+			return nil, err
+		}
+	}
+
+	return func() { l.release(st, sector) }, nil
+}
+
+// reserve takes a free slot in the stage for the sector, and returns true. If
+// the stage is full, the sector is queued, unless it already is. Sectors keep
+// their slot until release is called, reserving it again returns true
+func (l *stageLimiter) reserve(st SectorState, sector abi.SectorNumber, priority uint64) bool {
+	l.lk.Lock()
+	defer l.lk.Unlock()
+
+	q := l.stage(st)
+	if _, ok := q.running[sector]; ok {
+		return true
+	}
+
+	if q.waiter(sector) == nil {
+		q.enqueue(&stageWaiter{sector: sector, priority: priority, ready: make(chan struct{})})
+		q.dispatch()
+	}
+
+	_, ok := q.running[sector]
+	return ok
+}
+
+// wait waits for a sector queued with reserve to get its slot. If ctx is
+// done first, the sector is removed from the queue
+func (l *stageLimiter) wait(ctx context.Context, st SectorState, sector abi.SectorNumber) error {
+	l.lk.Lock()
+	q := l.stage(st)
+	if _, ok := q.running[sector]; ok {
+		l.lk.Unlock()
+		return nil
+	}
+	w := q.waiter(sector)
+	l.lk.Unlock()
+
+	if w == nil {
+		return xerrors.Errorf("sector %d isn't queued for %s", sector, st)
+	}
+
+	select {
+	case <-w.ready:
+		return nil
+	case <-ctx.Done():
+	}
+
+	l.lk.Lock()
+	for i, qw := range q.waiting {
+		if qw == w {
+			q.waiting = append(q.waiting[:i], q.waiting[i+1:]...)
+			l.lk.Unlock()
+			return ctx.Err()
+		}
+	}
+	l.lk.Unlock()
+
+	// got the slot just as the context was cancelled
+	l.release(st, sector)
+	return ctx.Err()
+}
+
+func (l *stageLimiter) release(st SectorState, sector abi.SectorNumber) {
+	l.lk.Lock()
+	defer l.lk.Unlock()
+
+	q := l.stage(st)
+	delete(q.running, sector)
+	q.dispatch()
+}
+
+// leave drops slots and queue entries of the sector in all stages except
+// keep. It's called when sectors leave a stage state, or are paused, slots
+// reserved for handlers which won't run again are given to other sectors
+func (l *stageLimiter) leave(sector abi.SectorNumber, keep SectorState) {
+	l.lk.Lock()
+	defer l.lk.Unlock()
+
+	for st, q := range l.stages {
+		if st == keep {
+			continue
+		}
+
+		for i, w := range q.waiting {
+			if w.sector == sector {
+				q.waiting = append(q.waiting[:i], q.waiting[i+1:]...)
+				break
+			}
+		}
+
+		if _, ok := q.running[sector]; ok {
+			delete(q.running, sector)
+			q.dispatch()
+		}
+	}
+}
+
+// waiter returns the queue entry of a sector. Must be called with lk held
+func (q *stageQueue) waiter(sector abi.SectorNumber) *stageWaiter {
+	for _, w := range q.waiting {
+		if w.sector == sector {
+			return w
+		}
+	}
+	return nil
+}
+
+// enqueue inserts the waiter after all waiters with the same or higher
+// priority. Must be called with lk held
+func (q *stageQueue) enqueue(w *stageWaiter) {
+	i := sort.Search(len(q.waiting), func(i int) bool {
+		return q.waiting[i].priority < w.priority
+	})
+
+	q.waiting = append(q.waiting, nil)
+	copy(q.waiting[i+1:], q.waiting[i:])
+	q.waiting[i] = w
+}
+
+func (l *stageLimiter) setPriority(sector abi.SectorNumber, priority uint64) {
+	l.lk.Lock()
+	defer l.lk.Unlock()
+
+	for _, q := range l.stages {
+		for i, w := range q.waiting {
+			if w.sector != sector {
+				continue
+			}
+
+			q.waiting = append(q.waiting[:i], q.waiting[i+1:]...)
+			w.priority = priority
+			q.enqueue(w)
+			break
+		}
+	}
+}
+
+// dispatch must be called with lk held
+func (q *stageQueue) dispatch() {
+	for len(q.waiting) > 0 && (q.limit <= 0 || len(q.running) < q.limit) {
+		w := q.waiting[0]
+		q.waiting = q.waiting[1:]
+
+		q.running[w.sector] = struct{}{}
+		close(w.ready)
+	}
+}
+
+func (l *stageLimiter) status() map[SectorState]StageStatus {
+	l.lk.Lock()
+	defer l.lk.Unlock()
+
+	out := map[SectorState]StageStatus{}
+	for st, q := range l.stages {
+		ss := StageStatus{
+			Limit:   q.limit,
+			Running: make([]abi.SectorNumber, 0, len(q.running)),
+			Queued:  make([]abi.SectorNumber, len(q.waiting)),
+		}
+		for sector := range q.running {
+			ss.Running = append(ss.Running, sector)
+		}
+		for i, w := range q.waiting {
+			ss.Queued[i] = w.sector
+		}
+		out[st] = ss
+	}
+	return out
+}
+
+// enterStage gets the sector a slot in a limited stage. The handler proceeds
+// if the returned release function isn't nil, and calls it when it's done
+// with the stage.
+//
+// Sectors which have to wait are marked as queued with SectorQueued first,
+// so the wait is visible in SectorInfo and the log; the handler is then run
+// again, and waits with wait, or stageLimiter.wait if it's nil. Once a queued
+// sector has a slot, SectorStageStarted clears the mark, and the slot is kept
+// for the next run of the handler
+func (m *Sealing) enterStage(ctx Context, st SectorState, sector SectorInfo, wait func(context.Context) error) (func(), error) {
+	if wait == nil {
+		wait = func(ctx context.Context) error {
+			return m.stages.wait(ctx, st, sector.SectorNumber)
+		}
+	}
+
+	if !m.stages.reserve(st, sector.SectorNumber, sector.Priority) {
+		if !sector.Queued {
+			return nil, ctx.Send(SectorQueued{})
+		}
+
+		if err := wait(ctx.Context()); err != nil {
+			return nil, err
+		}
+	}
+
+	if sector.Queued {
+		return nil, ctx.Send(SectorStageStarted{})
+	}
+
+	return func() { m.stages.release(st, sector.SectorNumber) }, nil
+}
+
+// SetStageLimits changes the per-stage concurrency limits. Raising a limit
+// immediately starts queued sectors
+func (m *Sealing) SetStageLimits(limits StageLimits) {
+	m.stages.setLimits(limits)
+}
+
+func (m *Sealing) StageStatus() map[SectorState]StageStatus {
+	return m.stages.status()
+}
+
+// QueuePosition returns the stage a sector is waiting for, and its position
+// in the queue (0 is next to run). It returns false if the sector isn't queued
+func (m *Sealing) QueuePosition(sector abi.SectorNumber) (SectorState, int, bool) {
+	m.stages.lk.Lock()
+	defer m.stages.lk.Unlock()
+
+	for st, q := range m.stages.stages {
+		for i, w := range q.waiting {
+			if w.sector == sector {
+				return st, i, true
+			}
+		}
+	}
+	return UndefinedSectorState, 0, false
+}
@@ -0,0 +1,127 @@
+package sealing
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/specs-actors/actors/abi"
+)
+
+func TestStageLimiter(t *testing.T) {
+	l := newStageLimiter()
+	l.setLimits(StageLimits{PreCommit1: 1})
+
+	ctx := context.Background()
+
+	release1, err := l.acquire(ctx, PreCommit1, 1, DefaultPriority)
+	require.NoError(t, err)
+
+	got := make(chan func(), 2)
+	for _, sector := range []int{2, 3} {
+		sector := sector
+		go func() {
+			r, err := l.acquire(ctx, PreCommit1, abi.SectorNumber(sector), DefaultPriority)
+			assert.NoError(t, err)
+			got <- r
+		}()
+
+		// wait for the sector to be queued, so the order is deterministic
+		require.Eventually(t, func() bool {
+			return len(l.status()[PreCommit1].Queued) == sector-1
+		}, time.Second, time.Millisecond)
+	}
+
+	require.Equal(t, []abi.SectorNumber{2, 3}, l.status()[PreCommit1].Queued)
+
+	release1()
+	release2 := <-got
+	require.Equal(t, []abi.SectorNumber{2}, l.status()[PreCommit1].Running)
+	require.Equal(t, []abi.SectorNumber{3}, l.status()[PreCommit1].Queued)
+
+	// raising the limit starts queued sectors
+	l.setLimits(StageLimits{PreCommit1: 2})
+	release3 := <-got
+
+	release2()
+	release3()
+	require.Empty(t, l.status()[PreCommit1].Running)
+}
+
+func TestStageLimiterCancel(t *testing.T) {
+	l := newStageLimiter()
+	l.setLimits(StageLimits{Committing: 1})
+
+	release, err := l.acquire(context.Background(), Committing, 1, DefaultPriority)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err = l.acquire(ctx, Committing, 2, DefaultPriority)
+	require.Error(t, err)
+	require.Empty(t, l.status()[Committing].Queued)
+
+	release()
+}
+
+func TestStageLimiterReserve(t *testing.T) {
+	l := newStageLimiter()
+	l.setLimits(StageLimits{PreCommit2: 1})
+
+	require.True(t, l.reserve(PreCommit2, 1, DefaultPriority))
+	require.True(t, l.reserve(PreCommit2, 1, DefaultPriority), "reserving a held slot again")
+
+	// a full stage queues the sector once
+	require.False(t, l.reserve(PreCommit2, 2, DefaultPriority))
+	require.False(t, l.reserve(PreCommit2, 2, DefaultPriority))
+	require.Equal(t, []abi.SectorNumber{2}, l.status()[PreCommit2].Queued)
+
+	// sectors leaving the stage give up their slot
+	l.leave(1, PreCommit1)
+	require.NoError(t, l.wait(context.Background(), PreCommit2, 2))
+	require.Equal(t, []abi.SectorNumber{2}, l.status()[PreCommit2].Running)
+
+	// and their place in the queue
+	require.False(t, l.reserve(PreCommit2, 3, DefaultPriority))
+	l.leave(3, UndefinedSectorState)
+	require.Empty(t, l.status()[PreCommit2].Queued)
+	require.Error(t, l.wait(context.Background(), PreCommit2, 3))
+
+	// leaving keeps the slot of the current stage
+	l.leave(2, PreCommit2)
+	require.Equal(t, []abi.SectorNumber{2}, l.status()[PreCommit2].Running)
+	l.release(PreCommit2, 2)
+	require.Empty(t, l.status()[PreCommit2].Running)
+}
+
+func TestStageQueuePriority(t *testing.T) {
+	q := &stageQueue{running: map[abi.SectorNumber]struct{}{}}
+
+	for _, w := range []*stageWaiter{
+		{sector: 1, priority: 0},
+		{sector: 2, priority: 5},
+		{sector: 3, priority: 0},
+		{sector: 4, priority: 5},
+		{sector: 5, priority: 10},
+	} {
+		q.enqueue(w)
+	}
+
+	order := func() []abi.SectorNumber {
+		var out []abi.SectorNumber
+		for _, w := range q.waiting {
+			out = append(out, w.sector)
+		}
+		return out
+	}
+
+	require.Equal(t, []abi.SectorNumber{5, 2, 4, 1, 3}, order())
+
+	l := &stageLimiter{stages: map[SectorState]*stageQueue{PreCommit1: q}}
+	l.setPriority(3, 7)
+	require.Equal(t, []abi.SectorNumber{5, 3, 2, 4, 1}, order())
+}
@@ -6,7 +6,6 @@ import (
 
 	"golang.org/x/xerrors"
 
-	"github.com/filecoin-project/go-statemachine"
 	"github.com/filecoin-project/specs-actors/actors/abi"
 	"github.com/filecoin-project/specs-actors/actors/abi/big"
 	"github.com/filecoin-project/specs-actors/actors/builtin"
@@ -15,7 +14,7 @@ import (
 	"github.com/filecoin-project/specs-storage/storage"
 )
 
-func (m *Sealing) handlePacking(ctx statemachine.Context, sector SectorInfo) error {
+func (m *Sealing) handlePacking(ctx Context, sector SectorInfo) error {
 	log.Infow("performing filling up rest of the sector...", "sector", sector.SectorNumber)
 
 	var allocated abi.UnpaddedPieceSize
@@ -46,7 +45,7 @@ func (m *Sealing) handlePacking(ctx statemachine.Context, sector SectorInfo) err
 	return ctx.Send(SectorPacked{Pieces: pieces})
 }
 
-func (m *Sealing) handlePreCommit1(ctx statemachine.Context, sector SectorInfo) error {
+func (m *Sealing) handlePreCommit1(ctx Context, sector SectorInfo) error {
 	if err := checkPieces(ctx.Context(), sector, m.api); err != nil { // Sanity check state
 		switch err.(type) {
 		case *ErrApi:
@@ -61,8 +60,17 @@ func (m *Sealing) handlePreCommit1(ctx statemachine.Context, sector SectorInfo)
 		}
 	}
 
+	release, err := m.enterStage(ctx, PreCommit1, sector, nil)
+	if err != nil {
+		return xerrors.Errorf("waiting for PreCommit1 slot: %w", err)
+	}
+	if release == nil {
+		return nil
+	}
+	defer release()
+
 	log.Infow("performing sector replication...", "sector", sector.SectorNumber)
-	ticketValue, ticketEpoch, err := m.tktFn(ctx.Context())
+	ticketValue, ticketEpoch, err := m.sealTicket(ctx.Context(), sector)
 	if err != nil {
 		return ctx.Send(SectorSealPreCommitFailed{xerrors.Errorf("getting ticket failed: %w", err)})
 	}
@@ -72,15 +80,44 @@ func (m *Sealing) handlePreCommit1(ctx statemachine.Context, sector SectorInfo)
 		return ctx.Send(SectorSealPreCommitFailed{xerrors.Errorf("seal pre commit(1) failed: %w", err)})
 	}
 
+	ref, err := m.replaceBlob(ctx.Context(), sector.PreCommit1OutRef, pc1o)
+	if err != nil {
+		return ctx.Send(SectorSealPreCommitFailed{xerrors.Errorf("storing pre commit(1) output: %w", err)})
+	}
+
 	return ctx.Send(SectorPreCommit1{
-		PreCommit1Out: pc1o,
-		TicketValue:   ticketValue,
-		TicketEpoch:   ticketEpoch,
+		PreCommit1OutRef: ref,
+		TicketValue:      ticketValue,
+		TicketEpoch:      ticketEpoch,
 	})
 }
 
-func (m *Sealing) handlePreCommit2(ctx statemachine.Context, sector SectorInfo) error {
-	cids, err := m.sealer.SealPreCommit2(ctx.Context(), m.minerSector(sector.SectorNumber), sector.PreCommit1Out)
+func (m *Sealing) handlePreCommit2(ctx Context, sector SectorInfo) error {
+	err := m.checkTicketExpiry(ctx.Context(), sector)
+	var release func()
+	if err == nil {
+		release, err = m.enterStage(ctx, PreCommit2, sector, func(wctx context.Context) error {
+			return m.waitBeforeTicketExpiry(wctx, PreCommit2, sector)
+		})
+	}
+	if err == errTicketExpiring {
+		log.Warnw("ticket would expire while waiting for PreCommit2 slot, getting a new one", "sector", sector.SectorNumber, "deadline", sector.TicketDeadline)
+		return ctx.Send(SectorTicketExpiring{})
+	}
+	if err != nil {
+		return xerrors.Errorf("waiting for PreCommit2 slot: %w", err)
+	}
+	if release == nil {
+		return nil
+	}
+	defer release()
+
+	pc1o, err := m.loadBlob(ctx.Context(), sector.PreCommit1OutRef)
+	if err != nil {
+		return ctx.Send(SectorSealPreCommitFailed{xerrors.Errorf("loading pre commit(1) output: %w", err)})
+	}
+
+	cids, err := m.sealer.SealPreCommit2(ctx.Context(), m.minerSector(sector.SectorNumber), pc1o)
 	if err != nil {
 		return ctx.Send(SectorSealPreCommitFailed{xerrors.Errorf("seal pre commit(2) failed: %w", err)})
 	}
@@ -91,7 +128,7 @@ func (m *Sealing) handlePreCommit2(ctx statemachine.Context, sector SectorInfo)
 	})
 }
 
-func (m *Sealing) handlePreCommitting(ctx statemachine.Context, sector SectorInfo) error {
+func (m *Sealing) handlePreCommitting(ctx Context, sector SectorInfo) error {
 	if err := checkPrecommit(ctx.Context(), m.Address(), sector, m.api); err != nil {
 		switch err.(type) {
 		case *ErrApi:
@@ -130,29 +167,46 @@ func (m *Sealing) handlePreCommitting(ctx statemachine.Context, sector SectorInf
 	return ctx.Send(SectorPreCommitted{Message: mcid})
 }
 
-func (m *Sealing) handleWaitSeed(ctx statemachine.Context, sector SectorInfo) error {
+func (m *Sealing) handleWaitSeed(ctx Context, sector SectorInfo) error {
 	// would be ideal to just use the events.Called handler, but it wouldnt be able to handle individual message timeouts
 	log.Info("Sector precommitted: ", sector.SectorNumber)
-	mw, err := m.api.StateWaitMsg(ctx.Context(), *sector.PreCommitMessage)
-	if err != nil {
-		return ctx.Send(SectorChainPreCommitFailed{err})
-	}
 
-	if mw.Receipt.ExitCode != 0 {
-		log.Error("sector precommit failed: ", mw.Receipt.ExitCode)
-		err := xerrors.Errorf("sector precommit failed: %d", mw.Receipt.ExitCode)
-		return ctx.Send(SectorChainPreCommitFailed{err})
+	var tok TipSetToken
+	if sector.PreCommitMessage == nil {
+		// sectors recovered from chain don't know their precommit message,
+		// see RecoverSectors
+		var err error
+		tok, _, err = m.api.ChainHead(ctx.Context())
+		if err != nil {
+			return xerrors.Errorf("getting chain head: %w", err)
+		}
+	} else {
+		mw, err := m.api.StateWaitMsg(ctx.Context(), *sector.PreCommitMessage)
+		if err != nil {
+			return ctx.Send(SectorChainPreCommitFailed{err})
+		}
+
+		if mw.Receipt.ExitCode != 0 {
+			log.Error("sector precommit failed: ", mw.Receipt.ExitCode)
+			err := xerrors.Errorf("sector precommit failed: %d", mw.Receipt.ExitCode)
+			return ctx.Send(SectorChainPreCommitFailed{err})
+		}
+		log.Info("precommit message landed on chain: ", sector.SectorNumber)
+
+		tok = mw.TipSetTok
 	}
-	log.Info("precommit message landed on chain: ", sector.SectorNumber)
 
-	pci, err := m.api.StateSectorPreCommitInfo(ctx.Context(), m.maddr, sector.SectorNumber, mw.TipSetTok)
+	pci, err := m.api.StateSectorPreCommitInfo(ctx.Context(), m.maddr, sector.SectorNumber, tok)
 	if err != nil {
 		return xerrors.Errorf("getting precommit info: %w", err)
 	}
+	if pci == nil {
+		return ctx.Send(SectorChainPreCommitFailed{xerrors.Errorf("sector %d isn't precommitted on chain", sector.SectorNumber)})
+	}
 
 	randHeight := pci.PreCommitEpoch + miner.PreCommitChallengeDelay
 
-	err = m.events.ChainAt(func(ectx context.Context, tok TipSetToken, curH abi.ChainEpoch) error {
+	err = m.chainAt(func(ectx context.Context, tok TipSetToken, curH abi.ChainEpoch) error {
 		rand, err := m.api.ChainGetRandomness(ectx, tok, crypto.DomainSeparationTag_InteractiveSealChallengeSeed, randHeight, nil)
 		if err != nil {
 			err = xerrors.Errorf("failed to get randomness for computing seal proof: %w", err)
@@ -176,21 +230,21 @@ func (m *Sealing) handleWaitSeed(ctx statemachine.Context, sector SectorInfo) er
 	return nil
 }
 
-func (m *Sealing) handleCommitting(ctx statemachine.Context, sector SectorInfo) error {
+func (m *Sealing) handleCommitting(ctx Context, sector SectorInfo) error {
 	log.Info("scheduling seal proof computation...")
 
 	log.Infof("KOMIT %d %x(%d); %x(%d); %v; r:%x; d:%x", sector.SectorNumber, sector.TicketValue, sector.TicketEpoch, sector.SeedValue, sector.SeedEpoch, sector.pieceInfos(), sector.CommR, sector.CommD)
 
-	cids := storage.SectorCids{
-		Unsealed: *sector.CommD,
-		Sealed:   *sector.CommR,
-	}
-	c2in, err := m.sealer.SealCommit1(ctx.Context(), m.minerSector(sector.SectorNumber), sector.TicketValue, sector.SeedValue, sector.pieceInfos(), cids)
+	release, err := m.enterStage(ctx, Committing, sector, nil)
 	if err != nil {
-		return ctx.Send(SectorComputeProofFailed{xerrors.Errorf("computing seal proof failed: %w", err)})
+		return xerrors.Errorf("waiting for Committing slot: %w", err)
+	}
+	if release == nil {
+		return nil
 	}
 
-	proof, err := m.sealer.SealCommit2(ctx.Context(), m.minerSector(sector.SectorNumber), c2in)
+	proof, err := m.computeProof(ctx, sector)
+	release()
 	if err != nil {
 		return ctx.Send(SectorComputeProofFailed{xerrors.Errorf("computing seal proof failed: %w", err)})
 	}
@@ -199,7 +253,10 @@ func (m *Sealing) handleCommitting(ctx statemachine.Context, sector SectorInfo)
 		return ctx.Send(SectorCommitFailed{xerrors.Errorf("commit check error: %w", err)})
 	}
 
-	// TODO: Consider splitting states and persist proof for faster recovery
+	proofRef, err := m.replaceBlob(ctx.Context(), sector.ProofRef, proof)
+	if err != nil {
+		return ctx.Send(SectorCommitFailed{xerrors.Errorf("storing proof: %w", err)})
+	}
 
 	params := &miner.ProveCommitSectorParams{
 		SectorNumber: sector.SectorNumber,
@@ -218,12 +275,25 @@ func (m *Sealing) handleCommitting(ctx statemachine.Context, sector SectorInfo)
 	}
 
 	return ctx.Send(SectorCommitted{
-		Proof:   proof,
-		Message: mcid,
+		ProofRef: proofRef,
+		Message:  mcid,
 	})
 }
 
-func (m *Sealing) handleCommitWait(ctx statemachine.Context, sector SectorInfo) error {
+func (m *Sealing) computeProof(ctx Context, sector SectorInfo) (storage.Proof, error) {
+	cids := storage.SectorCids{
+		Unsealed: *sector.CommD,
+		Sealed:   *sector.CommR,
+	}
+	c2in, err := m.sealer.SealCommit1(ctx.Context(), m.minerSector(sector.SectorNumber), sector.TicketValue, sector.SeedValue, sector.pieceInfos(), cids)
+	if err != nil {
+		return nil, err
+	}
+
+	return m.sealer.SealCommit2(ctx.Context(), m.minerSector(sector.SectorNumber), c2in)
+}
+
+func (m *Sealing) handleCommitWait(ctx Context, sector SectorInfo) error {
 	if sector.CommitMessage == nil {
 		log.Errorf("sector %d entered commit wait state without a message cid", sector.SectorNumber)
 		return ctx.Send(SectorCommitFailed{xerrors.Errorf("entered commit wait with no commit cid")})
@@ -235,13 +305,13 @@ func (m *Sealing) handleCommitWait(ctx statemachine.Context, sector SectorInfo)
 	}
 
 	if mw.Receipt.ExitCode != 0 {
-		return ctx.Send(SectorCommitFailed{xerrors.Errorf("submitting sector proof failed (exit=%d, msg=%s) (t:%x; s:%x(%d); p:%x)", mw.Receipt.ExitCode, sector.CommitMessage, sector.TicketValue, sector.SeedValue, sector.SeedEpoch, sector.Proof)})
+		return ctx.Send(SectorCommitFailed{xerrors.Errorf("submitting sector proof failed (exit=%d, msg=%s) (t:%x; s:%x(%d); p:%s)", mw.Receipt.ExitCode, sector.CommitMessage, sector.TicketValue, sector.SeedValue, sector.SeedEpoch, sector.ProofRef)})
 	}
 
 	return ctx.Send(SectorProving{})
 }
 
-func (m *Sealing) handleFinalizeSector(ctx statemachine.Context, sector SectorInfo) error {
+func (m *Sealing) handleFinalizeSector(ctx Context, sector SectorInfo) error {
 	// TODO: Maybe wait for some finality
 
 	if err := m.sealer.FinalizeSector(ctx.Context(), m.minerSector(sector.SectorNumber)); err != nil {
@@ -251,7 +321,20 @@ func (m *Sealing) handleFinalizeSector(ctx statemachine.Context, sector SectorIn
 	return ctx.Send(SectorFinalized{})
 }
 
-func (m *Sealing) handleFaulty(ctx statemachine.Context, sector SectorInfo) error {
+func (m *Sealing) handleProving(ctx Context, sector SectorInfo) error {
+	// TODO: track sector health / expiration
+	if !sector.hasBlobs() {
+		log.Infof("Proving sector %d", sector.SectorNumber)
+		return nil
+	}
+
+	// PreCommit1 output and the proof aren't needed once the sector is proving
+	m.deleteBlobs(ctx.Context(), sector)
+
+	return ctx.Send(SectorBlobsRemoved{})
+}
+
+func (m *Sealing) handleFaulty(ctx Context, sector SectorInfo) error {
 	// TODO: check if the fault has already been reported, and that this sector is even valid
 
 	// TODO: coalesce faulty sector reporting
@@ -273,10 +356,10 @@ func (m *Sealing) handleFaulty(ctx statemachine.Context, sector SectorInfo) erro
 		return xerrors.Errorf("failed to push declare faults message to network: %w", err)
 	}
 
-	return ctx.Send(SectorFaultReported{reportMsg: mcid})
+	return ctx.Send(SectorFaultReported{ReportMsg: mcid})
 }
 
-func (m *Sealing) handleFaultReported(ctx statemachine.Context, sector SectorInfo) error {
+func (m *Sealing) handleFaultReported(ctx Context, sector SectorInfo) error {
 	if sector.FaultReportMsg == nil {
 		return xerrors.Errorf("entered fault reported state without a FaultReportMsg cid")
 	}
@@ -293,3 +376,25 @@ func (m *Sealing) handleFaultReported(ctx statemachine.Context, sector SectorInf
 
 	return ctx.Send(SectorFaultedFinal{})
 }
+
+func (m *Sealing) handleAborting(ctx Context, sector SectorInfo) error {
+	if pieces := sector.dealPieces(); len(pieces) > 0 {
+		if m.release == nil {
+			log.Warnf("no deal release callback, deals of aborted sector %d are not released: %v", sector.SectorNumber, sector.deals())
+		} else if err := m.release(ctx.Context(), sector.SectorNumber, pieces); err != nil {
+			return ctx.Send(SectorAbortFailed{xerrors.Errorf("releasing deals of aborted sector: %w", err)})
+		}
+	}
+
+	r, ok := m.sealer.(SectorRemover)
+	if !ok {
+		return ctx.Send(SectorAbortFailed{xerrors.New("sector manager can't remove sectors, see WithSectorStore")})
+	}
+	if err := r.Remove(ctx.Context(), m.minerSector(sector.SectorNumber)); err != nil {
+		return ctx.Send(SectorAbortFailed{xerrors.Errorf("removing sector data: %w", err)})
+	}
+
+	m.deleteBlobs(ctx.Context(), sector)
+
+	return ctx.Send(SectorAborted{})
+}
@@ -5,15 +5,18 @@ import (
 
 	"golang.org/x/xerrors"
 
-	"github.com/filecoin-project/go-statemachine"
 	"github.com/filecoin-project/specs-actors/actors/builtin/miner"
 )
 
 const minRetryTime = 1 * time.Minute
 
-func failedCooldown(ctx statemachine.Context, sector SectorInfo) error {
+func failedCooldown(ctx Context, sector SectorInfo) error {
 	// TODO: Exponential backoff when we see consecutive failures
 
+	if len(sector.Log) > 0 && sector.Log[len(sector.Log)-1].Event == eventName(SectorRetry{}) {
+		return nil // retry requested by the operator
+	}
+
 	retryStart := time.Unix(int64(sector.Log[len(sector.Log)-1].Timestamp), 0).Add(minRetryTime)
 	if len(sector.Log) > 0 && !time.Now().After(retryStart) {
 		log.Infof("%s(%d), waiting %s before retrying", sector.State, sector.SectorNumber, time.Until(retryStart))
@@ -27,7 +30,7 @@ func failedCooldown(ctx statemachine.Context, sector SectorInfo) error {
 	return nil
 }
 
-func (m *Sealing) checkPreCommitted(ctx statemachine.Context, sector SectorInfo) (*miner.SectorPreCommitOnChainInfo, bool) {
+func (m *Sealing) checkPreCommitted(ctx Context, sector SectorInfo) (*miner.SectorPreCommitOnChainInfo, bool) {
 	tok, _, err := m.api.ChainHead(ctx.Context())
 	if err != nil {
 		log.Errorf("handleSealFailed(%d): temp error: %+v", sector.SectorNumber, err)
@@ -43,7 +46,7 @@ func (m *Sealing) checkPreCommitted(ctx statemachine.Context, sector SectorInfo)
 	return info, false
 }
 
-func (m *Sealing) handleSealFailed(ctx statemachine.Context, sector SectorInfo) error {
+func (m *Sealing) handleSealFailed(ctx Context, sector SectorInfo) error {
 	if _, is := m.checkPreCommitted(ctx, sector); is {
 		// TODO: Remove this after we can re-precommit
 		return nil // noop, for now
@@ -56,7 +59,7 @@ func (m *Sealing) handleSealFailed(ctx statemachine.Context, sector SectorInfo)
 	return ctx.Send(SectorRetrySeal{})
 }
 
-func (m *Sealing) handlePreCommitFailed(ctx statemachine.Context, sector SectorInfo) error {
+func (m *Sealing) handlePreCommitFailed(ctx Context, sector SectorInfo) error {
 	if err := checkPrecommit(ctx.Context(), m.Address(), sector, m.api); err != nil {
 		switch err.(type) {
 		case *ErrApi:
@@ -103,7 +106,7 @@ func (m *Sealing) handlePreCommitFailed(ctx statemachine.Context, sector SectorI
 	return ctx.Send(SectorRetryPreCommit{})
 }
 
-func (m *Sealing) handleComputeProofFailed(ctx statemachine.Context, sector SectorInfo) error {
+func (m *Sealing) handleComputeProofFailed(ctx Context, sector SectorInfo) error {
 	// TODO: Check sector files
 
 	if err := failedCooldown(ctx, sector); err != nil {
@@ -113,7 +116,7 @@ func (m *Sealing) handleComputeProofFailed(ctx statemachine.Context, sector Sect
 	return ctx.Send(SectorRetryComputeProof{})
 }
 
-func (m *Sealing) handleCommitFailed(ctx statemachine.Context, sector SectorInfo) error {
+func (m *Sealing) handleCommitFailed(ctx Context, sector SectorInfo) error {
 	if err := checkPrecommit(ctx.Context(), m.maddr, sector, m.api); err != nil {
 		switch err.(type) {
 		case *ErrApi:
@@ -128,7 +131,13 @@ func (m *Sealing) handleCommitFailed(ctx statemachine.Context, sector SectorInfo
 		}
 	}
 
-	if err := m.checkCommit(ctx.Context(), sector, sector.Proof); err != nil {
+	proof, err := m.loadBlob(ctx.Context(), sector.ProofRef)
+	if err != nil {
+		// checked as an invalid proof, so it's computed again
+		log.Errorf("loading proof of sector %d: %+v", sector.SectorNumber, err)
+	}
+
+	if err := m.checkCommit(ctx.Context(), sector, proof); err != nil {
 		switch err.(type) {
 		case *ErrApi:
 			log.Errorf("handleCommitFailed: api error, not proceeding: %+v", err)
@@ -159,3 +168,11 @@ func (m *Sealing) handleCommitFailed(ctx statemachine.Context, sector SectorInfo
 
 	return ctx.Send(SectorRetryComputeProof{})
 }
+
+func (m *Sealing) handleAbortFailed(ctx Context, sector SectorInfo) error {
+	if err := failedCooldown(ctx, sector); err != nil {
+		return err
+	}
+
+	return ctx.Send(SectorRetryAbort{})
+}
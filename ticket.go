@@ -0,0 +1,188 @@
+package sealing
+
+import (
+	"bytes"
+	"context"
+	"time"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/specs-actors/actors/abi"
+	"github.com/filecoin-project/specs-actors/actors/crypto"
+)
+
+// DefaultSealDuration is the default number of epochs a sector is expected to
+// need from the start of PreCommit1 until it's pre-committed
+const DefaultSealDuration = abi.ChainEpoch(1000)
+
+// DefaultPreCommit2Duration is the default number of epochs a sector is
+// expected to need from the start of PreCommit2 until it's pre-committed
+const DefaultPreCommit2Duration = abi.ChainEpoch(300)
+
+var ticketCheckInterval = time.Minute
+
+var errTicketExpiring = xerrors.New("ticket expiring")
+
+// NewChainTicketFn returns a TicketFn drawing seal randomness from the chain
+// SealRandomnessLookback epochs behind the head, with the miner address as
+// entropy, so tickets match what checkPrecommit expects
+func NewChainTicketFn(api SealingAPI, maddr address.Address) TicketFn {
+	return func(ctx context.Context) (abi.SealRandomness, abi.ChainEpoch, error) {
+		tok, height, err := api.ChainHead(ctx)
+		if err != nil {
+			return nil, 0, xerrors.Errorf("getting chain head: %w", err)
+		}
+
+		ticketEpoch := height - SealRandomnessLookback
+		if ticketEpoch < 0 {
+			// chain is shorter than the lookback, use randomness from genesis
+			ticketEpoch = 0
+		}
+
+		rand, err := chainTicket(ctx, api, maddr, tok, ticketEpoch)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		return rand, ticketEpoch, nil
+	}
+}
+
+// chainTicket draws the seal randomness NewChainTicketFn uses at an epoch
+func chainTicket(ctx context.Context, api SealingAPI, maddr address.Address, tok TipSetToken, epoch abi.ChainEpoch) (abi.SealRandomness, error) {
+	buf := new(bytes.Buffer)
+	if err := maddr.MarshalCBOR(buf); err != nil {
+		return nil, xerrors.Errorf("marshaling miner address: %w", err)
+	}
+
+	rand, err := api.ChainGetRandomness(ctx, tok, crypto.DomainSeparationTag_SealRandomness, epoch, buf.Bytes())
+	if err != nil {
+		return nil, xerrors.Errorf("getting randomness for epoch %d: %w", epoch, err)
+	}
+
+	return abi.SealRandomness(rand), nil
+}
+
+// SetSealDuration sets the number of epochs sectors are expected to need from
+// the start of PreCommit1 until they are pre-committed. Tickets which would
+// expire sooner are replaced before sealing with them
+func (m *Sealing) SetSealDuration(epochs abi.ChainEpoch) {
+	m.ticketLk.Lock()
+	defer m.ticketLk.Unlock()
+
+	m.sealDuration = epochs
+}
+
+// SetPreCommit2Duration sets the number of epochs sectors are expected to need
+// from the start of PreCommit2 until they are pre-committed. Sectors waiting
+// for PreCommit2 get a new ticket when theirs would expire sooner
+func (m *Sealing) SetPreCommit2Duration(epochs abi.ChainEpoch) {
+	m.ticketLk.Lock()
+	defer m.ticketLk.Unlock()
+
+	m.preCommit2Duration = epochs
+}
+
+// remainingSealDuration returns the number of epochs a sector starting stage
+// st is expected to need until it's pre-committed
+func (m *Sealing) remainingSealDuration(st SectorState) abi.ChainEpoch {
+	m.ticketLk.Lock()
+	defer m.ticketLk.Unlock()
+
+	if st == PreCommit2 && m.preCommit2Duration < m.sealDuration {
+		return m.preCommit2Duration
+	}
+	return m.sealDuration
+}
+
+// ticketUsable checks if there is enough time left to seal the sector with
+// its current ticket, starting at stage st
+func (m *Sealing) ticketUsable(ctx context.Context, st SectorState, sector SectorInfo) (bool, error) {
+	if len(sector.TicketValue) == 0 {
+		return false, nil
+	}
+
+	_, height, err := m.api.ChainHead(ctx)
+	if err != nil {
+		return false, xerrors.Errorf("getting chain head: %w", err)
+	}
+
+	return sector.TicketDeadline-height >= m.remainingSealDuration(st), nil
+}
+
+// sealTicket returns the ticket to start PreCommit1 with. The ticket the
+// sector already has is reused, unless it would expire before the sector is
+// pre-committed
+func (m *Sealing) sealTicket(ctx context.Context, sector SectorInfo) (abi.SealRandomness, abi.ChainEpoch, error) {
+	ok, err := m.ticketUsable(ctx, PreCommit1, sector)
+	if err != nil {
+		return nil, 0, err
+	}
+	if ok {
+		return sector.TicketValue, sector.TicketEpoch, nil
+	}
+
+	if len(sector.TicketValue) > 0 {
+		log.Infow("drawing a new ticket", "sector", sector.SectorNumber, "deadline", sector.TicketDeadline)
+	}
+
+	return m.tktFn(ctx)
+}
+
+// checkTicketExpiry returns errTicketExpiring when the sector ticket would
+// expire before the sector, starting PreCommit2, is pre-committed
+func (m *Sealing) checkTicketExpiry(ctx context.Context, sector SectorInfo) error {
+	ok, err := m.ticketUsable(ctx, PreCommit2, sector)
+	if err != nil {
+		log.Warnf("checking ticket of sector %d: %+v", sector.SectorNumber, err)
+		return nil
+	}
+	if !ok {
+		return errTicketExpiring
+	}
+	return nil
+}
+
+// waitBeforeTicketExpiry waits for a stage slot like stageLimiter.wait, but
+// gives up with errTicketExpiring when the sector ticket would expire before
+// the sector is pre-committed
+func (m *Sealing) waitBeforeTicketExpiry(ctx context.Context, st SectorState, sector SectorInfo) error {
+	actx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	expiring := make(chan struct{})
+	go func() {
+		tick := time.NewTicker(ticketCheckInterval)
+		defer tick.Stop()
+
+		for {
+			select {
+			case <-tick.C:
+				ok, err := m.ticketUsable(actx, st, sector)
+				if err != nil {
+					log.Warnf("checking ticket of sector %d: %+v", sector.SectorNumber, err)
+					continue
+				}
+				if !ok {
+					close(expiring)
+					cancel()
+					return
+				}
+			case <-actx.Done():
+				return
+			}
+		}
+	}()
+
+	if err := m.stages.wait(actx, st, sector.SectorNumber); err != nil {
+		select {
+		case <-expiring:
+			return errTicketExpiring
+		default:
+		}
+		return err
+	}
+
+	return nil
+}
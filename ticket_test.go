@@ -0,0 +1,144 @@
+package sealing
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/specs-actors/actors/abi"
+	"github.com/filecoin-project/specs-actors/actors/crypto"
+)
+
+type ticketTestAPI struct {
+	SealingAPI
+
+	lk     sync.Mutex
+	height abi.ChainEpoch
+
+	tag     crypto.DomainSeparationTag
+	epoch   abi.ChainEpoch
+	entropy []byte
+}
+
+func (api *ticketTestAPI) setHeight(h abi.ChainEpoch) {
+	api.lk.Lock()
+	defer api.lk.Unlock()
+	api.height = h
+}
+
+func (api *ticketTestAPI) ChainHead(ctx context.Context) (TipSetToken, abi.ChainEpoch, error) {
+	api.lk.Lock()
+	defer api.lk.Unlock()
+	return TipSetToken("head"), api.height, nil
+}
+
+func (api *ticketTestAPI) ChainGetRandomness(ctx context.Context, tok TipSetToken, personalization crypto.DomainSeparationTag, randEpoch abi.ChainEpoch, entropy []byte) (abi.Randomness, error) {
+	api.lk.Lock()
+	defer api.lk.Unlock()
+	api.tag = personalization
+	api.epoch = randEpoch
+	api.entropy = entropy
+	return abi.Randomness{1, 2, 3}, nil
+}
+
+func TestChainTicketFn(t *testing.T) {
+	maddr := testMaddr(t)
+	api := &ticketTestAPI{height: 1000}
+
+	tkt, epoch, err := NewChainTicketFn(api, maddr)(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, abi.SealRandomness{1, 2, 3}, tkt)
+	require.Equal(t, abi.ChainEpoch(1000-SealRandomnessLookback), epoch)
+
+	require.Equal(t, crypto.DomainSeparationTag_SealRandomness, api.tag)
+	require.Equal(t, epoch, api.epoch)
+
+	buf := new(bytes.Buffer)
+	require.NoError(t, maddr.MarshalCBOR(buf))
+	require.Equal(t, buf.Bytes(), api.entropy)
+
+	// the ticket is fresh as far as checkPrecommit is concerned
+	require.False(t, int64(api.height)-int64(epoch+SealRandomnessLookback) > SealRandomnessLookbackLimit)
+
+	api.height = 10
+	_, epoch, err = NewChainTicketFn(api, maddr)(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, abi.ChainEpoch(0), epoch)
+}
+
+func TestSealTicket(t *testing.T) {
+	api := &ticketTestAPI{height: 1000}
+	m := &Sealing{
+		api:                api,
+		sealDuration:       100,
+		preCommit2Duration: 30,
+		tktFn: func(ctx context.Context) (abi.SealRandomness, abi.ChainEpoch, error) {
+			return abi.SealRandomness{4}, 900, nil
+		},
+	}
+
+	si := SectorInfo{TicketValue: abi.SealRandomness{1}, TicketEpoch: 500, TicketDeadline: ticketDeadline(500)}
+
+	// plenty of time left, ticket is reused
+	tkt, epoch, err := m.sealTicket(context.Background(), si)
+	require.NoError(t, err)
+	require.Equal(t, abi.SealRandomness{1}, tkt)
+	require.Equal(t, abi.ChainEpoch(500), epoch)
+
+	// less than sealDuration left, new ticket is drawn
+	api.setHeight(si.TicketDeadline - 50)
+	tkt, epoch, err = m.sealTicket(context.Background(), si)
+	require.NoError(t, err)
+	require.Equal(t, abi.SealRandomness{4}, tkt)
+	require.Equal(t, abi.ChainEpoch(900), epoch)
+
+	// a sector past PreCommit1 only needs the PreCommit2 time
+	require.NoError(t, m.checkTicketExpiry(context.Background(), si))
+	api.setHeight(si.TicketDeadline - 20)
+	require.Equal(t, errTicketExpiring, m.checkTicketExpiry(context.Background(), si))
+
+	// PreCommit2 never needs longer than sealing from the start
+	m.SetPreCommit2Duration(200)
+	require.Equal(t, abi.ChainEpoch(100), m.remainingSealDuration(PreCommit2))
+}
+
+func TestWaitBeforeTicketExpiry(t *testing.T) {
+	defer func(d time.Duration) { ticketCheckInterval = d }(ticketCheckInterval)
+	ticketCheckInterval = time.Millisecond
+
+	api := &ticketTestAPI{height: 1000}
+	m := &Sealing{
+		api:                api,
+		stages:             newStageLimiter(),
+		sealDuration:       100,
+		preCommit2Duration: 100,
+	}
+	m.SetStageLimits(StageLimits{PreCommit2: 1})
+
+	ctx := context.Background()
+	si := SectorInfo{SectorNumber: 2, TicketValue: abi.SealRandomness{1}, TicketEpoch: 500, TicketDeadline: ticketDeadline(500)}
+
+	release, err := m.stages.acquire(ctx, PreCommit2, 1, DefaultPriority)
+	require.NoError(t, err)
+	defer release()
+
+	require.NoError(t, m.checkTicketExpiry(ctx, si))
+	require.False(t, m.stages.reserve(PreCommit2, si.SectorNumber, DefaultPriority))
+	require.Len(t, m.StageStatus()[PreCommit2].Queued, 1)
+
+	res := make(chan error)
+	go func() {
+		res <- m.waitBeforeTicketExpiry(ctx, PreCommit2, si)
+	}()
+
+	api.setHeight(si.TicketDeadline - 50)
+	require.Equal(t, errTicketExpiring, <-res)
+	require.Empty(t, m.StageStatus()[PreCommit2].Queued)
+
+	// expired tickets don't wait at all
+	require.Equal(t, errTicketExpiring, m.checkTicketExpiry(ctx, si))
+}
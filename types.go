@@ -6,9 +6,9 @@ import (
 
 	"github.com/ipfs/go-cid"
 
+	"github.com/filecoin-project/sector-storage/stores"
 	"github.com/filecoin-project/specs-actors/actors/abi"
 	"github.com/filecoin-project/specs-actors/actors/runtime/exitcode"
-	"github.com/filecoin-project/specs-storage/storage"
 )
 
 type Piece struct {
@@ -26,28 +26,51 @@ type Log struct {
 
 	// additional data (Event info)
 	Kind string
+
+	// Structured event info
+	Batch uint64 // events planned together share a batch, see ReplaySector
+	Event string // event type name, e.g. SectorPreCommitted
+	From  SectorState
+	To    SectorState
+	Error string
+
+	MessageCid *cid.Cid       // chain message the event refers to
+	Epoch      abi.ChainEpoch // ticket / seed epoch carried by the event
+}
+
+// StateOverride records a manual state change made with ForceSectorState
+type StateOverride struct {
+	Timestamp uint64
+	From      SectorState
+	To        SectorState
+	Reason    string
 }
 
 type SectorInfo struct {
+	Version uint64 // record schema version, see SectorInfoVersion
+
 	State        SectorState
-	SectorNumber abi.SectorNumber // TODO: this field's name should be changed to SectorNumber
-	Nonce        uint64           // TODO: remove
+	SectorNumber abi.SectorNumber
 
 	SectorType abi.RegisteredProof
 
+	Priority uint64 // sectors with higher priority are sealed first
+	Queued   bool   // waiting for a slot in a limited stage, see SetStageLimits
+
 	// Packing
 
 	Pieces []Piece
 
 	// PreCommit1
-	TicketValue   abi.SealRandomness
-	TicketEpoch   abi.ChainEpoch
-	PreCommit1Out storage.PreCommit1Out
+	TicketValue      abi.SealRandomness
+	TicketEpoch      abi.ChainEpoch
+	TicketDeadline   abi.ChainEpoch // last epoch to pre-commit with the ticket, see ticketDeadline
+	PreCommit1OutRef *cid.Cid       // see BlobStore
 
 	// PreCommit2
-	CommD *cid.Cid
-	CommR *cid.Cid
-	Proof []byte
+	CommD    *cid.Cid
+	CommR    *cid.Cid
+	ProofRef *cid.Cid // see BlobStore
 
 	PreCommitMessage *cid.Cid
 
@@ -62,6 +85,10 @@ type SectorInfo struct {
 	// Faults
 	FaultReportMsg *cid.Cid
 
+	// Operator controls
+	Paused    bool // handlers are not run while set, see PauseSector
+	Overrides []StateOverride
+
 	// Debug
 	LastErr string
 
@@ -90,6 +117,23 @@ func (t *SectorInfo) deals() []abi.DealID {
 	return out
 }
 
+// ticketDeadline returns the last epoch at which a sector can be
+// pre-committed with a ticket drawn at epoch
+func ticketDeadline(epoch abi.ChainEpoch) abi.ChainEpoch {
+	return epoch + SealRandomnessLookback + SealRandomnessLookbackLimit
+}
+
+func (t *SectorInfo) dealPieces() []Piece {
+	out := make([]Piece, 0, len(t.Pieces))
+	for _, piece := range t.Pieces {
+		if piece.DealID == nil {
+			continue
+		}
+		out = append(out, piece)
+	}
+	return out
+}
+
 func (t *SectorInfo) existingPieces() []abi.UnpaddedPieceSize {
 	out := make([]abi.UnpaddedPieceSize, len(t.Pieces))
 	for i, piece := range t.Pieces {
@@ -98,8 +142,32 @@ func (t *SectorInfo) existingPieces() []abi.UnpaddedPieceSize {
 	return out
 }
 
+// Context is passed to sector state handlers
+type Context interface {
+	Context() context.Context
+	Send(evt interface{}) error
+}
+
 type TicketFn func(context.Context) (abi.SealRandomness, abi.ChainEpoch, error)
 
+// DealReleaseFn is called with the deal pieces of an aborted sector, so they
+// can be placed in another sector. It may be called more than once for the
+// same sector if the node restarts while the sector is being aborted
+type DealReleaseFn func(ctx context.Context, sector abi.SectorNumber, pieces []Piece) error
+
+// SectorRemover is implemented by sector managers which can remove sector
+// files from storage, see WithSectorStore. Aborting sectors requires it
+type SectorRemover interface {
+	Remove(ctx context.Context, sector abi.SectorID) error
+}
+
+// SectorFileIndex is implemented by sector managers which can tell which
+// files of a sector they store. RecoverSectors uses it to check that sectors
+// found on chain can still be proven, see WithSectorIndex
+type SectorFileIndex interface {
+	SectorFiles(ctx context.Context, sector abi.SectorID) (stores.SectorFileType, error)
+}
+
 type SectorIDCounter interface {
 	Next() (abi.SectorNumber, error)
 }
@@ -2,61 +2,217 @@ package sealing
 
 import (
 	"bytes"
+	"flag"
+	"io/ioutil"
+	"math/rand"
+	"path/filepath"
 	"testing"
 
-	"gotest.tools/assert"
+	"github.com/ipfs/go-cid"
+	"github.com/stretchr/testify/require"
+	cbg "github.com/whyrusleeping/cbor-gen"
 
 	cborutil "github.com/filecoin-project/go-cbor-util"
 	"github.com/filecoin-project/specs-actors/actors/abi"
 	"github.com/filecoin-project/specs-actors/actors/builtin"
 )
 
-func TestSectorInfoSelialization(t *testing.T) {
+var updateGolden = flag.Bool("update-golden", false, "rewrite testdata golden CBOR fixtures")
+
+type cborValue interface {
+	cbg.CBORMarshaler
+	cbg.CBORUnmarshaler
+}
+
+func mustBlobRef(data []byte) *cid.Cid {
+	ref, err := blobRef(data)
+	if err != nil {
+		panic(err)
+	}
+	return &ref
+}
+
+func goldenPiece() Piece {
 	d := abi.DealID(1234)
+	return Piece{DealID: &d, Size: 1016, CommP: builtin.PaymentChannelActorCodeID}
+}
 
-	dummyCid := builtin.AccountActorCodeID
+func goldenLog() Log {
+	msg := builtin.AccountActorCodeID
+	return Log{
+		Timestamp:  1588000000,
+		Trace:      "trace",
+		Message:    "{\"Message\":{}}",
+		Kind:       "event;sealing.SectorPreCommitted",
+		Batch:      3,
+		Event:      "SectorPreCommitted",
+		From:       PreCommitting,
+		To:         WaitSeed,
+		Error:      "err",
+		MessageCid: &msg,
+		Epoch:      345,
+	}
+}
 
-	si := &SectorInfo{
-		State:        "stateful",
+// goldenSector sets every SectorInfo field, so that a field added without
+// updating the fixture fails TestGoldenCBOR
+func goldenSector() SectorInfo {
+	commD := builtin.StorageMarketActorCodeID
+	commR := builtin.StorageMinerActorCodeID
+	pcMsg := builtin.AccountActorCodeID
+	cMsg := builtin.CronActorCodeID
+	fMsg := builtin.InitActorCodeID
+
+	return SectorInfo{
+		Version:      SectorInfoVersion,
+		State:        FaultReported,
 		SectorNumber: 234,
-		Nonce:        345,
-		Pieces: []Piece{{
-			DealID: &d,
-			Size:   5,
-			CommP:  dummyCid,
-		}},
-		CommD:            &dummyCid,
-		CommR:            nil,
-		Proof:            nil,
-		TicketValue:      []byte{87, 78, 7, 87},
+		SectorType:   abi.RegisteredProof_StackedDRG2KiBSeal,
+		Priority:     5,
+		Queued:       true,
+		Pieces: []Piece{
+			goldenPiece(),
+			{Size: 1016, CommP: builtin.PaymentChannelActorCodeID},
+		},
+		TicketValue:      abi.SealRandomness{87, 78, 7, 87},
 		TicketEpoch:      345,
-		PreCommitMessage: nil,
-		SeedValue:        []byte{},
-		SeedEpoch:        0,
-		CommitMessage:    nil,
-		FaultReportMsg:   nil,
+		TicketDeadline:   ticketDeadline(345),
+		PreCommit1OutRef: mustBlobRef([]byte{1, 2, 3, 4}),
+		CommD:            &commD,
+		CommR:            &commR,
+		ProofRef:         mustBlobRef([]byte{5, 6, 7}),
+		PreCommitMessage: &pcMsg,
+		SeedValue:        abi.InteractiveSealRandomness{8, 9},
+		SeedEpoch:        400,
+		CommitMessage:    &cMsg,
+		InvalidProofs:    1,
+		FaultReportMsg:   &fMsg,
+		Paused:           true,
+		Overrides:        []StateOverride{{Timestamp: 1588000001, From: PreCommitFailed, To: WaitSeed, Reason: "message landed"}},
 		LastErr:          "hi",
+		Log:              []Log{goldenLog()},
 	}
+}
 
-	b, err := cborutil.Dump(si)
-	if err != nil {
-		t.Fatal(err)
+func goldenValues() map[string]struct {
+	value cborValue
+	fresh func() cborValue
+} {
+	p, l, si := goldenPiece(), goldenLog(), goldenSector()
+	return map[string]struct {
+		value cborValue
+		fresh func() cborValue
+	}{
+		"piece.cbor":         {&p, func() cborValue { return new(Piece) }},
+		"log-v2.cbor":        {&l, func() cborValue { return new(Log) }},
+		"sectorinfo-v3.cbor": {&si, func() cborValue { return new(SectorInfo) }},
 	}
+}
 
-	var si2 SectorInfo
-	if err := cborutil.ReadCborRPC(bytes.NewReader(b), &si); err != nil {
-		return
+// TestGoldenCBOR checks that the encoding of stored types doesn't change.
+// Changing it is a schema change, which needs a migration, see migrations.go;
+// fixtures are then rewritten with -update-golden
+func TestGoldenCBOR(t *testing.T) {
+	for file, v := range goldenValues() {
+		v := v
+		t.Run(file, func(t *testing.T) {
+			path := filepath.Join("testdata", file)
+
+			var buf bytes.Buffer
+			require.NoError(t, v.value.MarshalCBOR(&buf))
+
+			if *updateGolden {
+				require.NoError(t, ioutil.WriteFile(path, buf.Bytes(), 0644))
+			}
+
+			golden, err := ioutil.ReadFile(path)
+			require.NoError(t, err)
+			require.Equal(t, golden, buf.Bytes(), "encoding differs from %s", path)
+
+			decoded := v.fresh()
+			require.NoError(t, decoded.UnmarshalCBOR(bytes.NewReader(golden)))
+			require.Equal(t, v.value, decoded)
+
+			buf.Reset()
+			require.NoError(t, decoded.MarshalCBOR(&buf))
+			require.Equal(t, golden, buf.Bytes())
+		})
 	}
+}
+
+func TestSectorInfoSerialization(t *testing.T) {
+	si := goldenSector()
+
+	b, err := cborutil.Dump(&si)
+	require.NoError(t, err)
+
+	var si2 SectorInfo
+	require.NoError(t, cborutil.ReadCborRPC(bytes.NewReader(b), &si2))
+	require.Equal(t, si, si2)
+
+	decoded, err := DecodeSectorInfo(b)
+	require.NoError(t, err)
+	require.Equal(t, si, decoded)
+}
+
+// TestFuzzUnmarshalCBOR feeds truncated and corrupted golden fixtures, and
+// random bytes to the generated unmarshallers. They may return errors, but
+// must not panic. Records with missing fields may not encode again (e.g. an
+// undefined CommP), but if they do, the encoding must decode
+func TestFuzzUnmarshalCBOR(t *testing.T) {
+	rnd := rand.New(rand.NewSource(4242))
 
-	assert.Equal(t, si.State, si2.State)
-	assert.Equal(t, si.Nonce, si2.Nonce)
-	assert.Equal(t, si.SectorNumber, si2.SectorNumber)
+	for file, v := range goldenValues() {
+		v := v
+		t.Run(file, func(t *testing.T) {
+			golden, err := ioutil.ReadFile(filepath.Join("testdata", file))
+			require.NoError(t, err)
 
-	assert.Equal(t, si.Pieces, si2.Pieces)
-	assert.Equal(t, si.CommD, si2.CommD)
-	assert.Equal(t, si.TicketValue, si2.TicketValue)
-	assert.Equal(t, si.TicketEpoch, si2.TicketEpoch)
+			check := func(input []byte) {
+				out := v.fresh()
+				defer func() {
+					if r := recover(); r != nil {
+						t.Fatalf("unmarshalling %x panicked: %v", input, r)
+					}
+				}()
 
-	assert.Equal(t, si, si2)
+				if err := out.UnmarshalCBOR(bytes.NewReader(input)); err != nil {
+					return
+				}
 
+				var buf bytes.Buffer
+				if err := out.MarshalCBOR(&buf); err != nil {
+					return
+				}
+				require.NoError(t, v.fresh().UnmarshalCBOR(&buf), "decoding re-encoded %x", input)
+			}
+
+			for i := 0; i < len(golden); i++ {
+				check(golden[:i])
+			}
+
+			for i := 0; i < 20000; i++ {
+				input := append([]byte{}, golden...)
+				for n := rnd.Intn(4) + 1; n > 0; n-- {
+					switch rnd.Intn(3) {
+					case 0: // flip a byte
+						input[rnd.Intn(len(input))] = byte(rnd.Intn(256))
+					case 1: // drop a byte
+						at := rnd.Intn(len(input))
+						input = append(input[:at], input[at+1:]...)
+					case 2: // insert a byte
+						at := rnd.Intn(len(input) + 1)
+						input = append(input[:at], append([]byte{byte(rnd.Intn(256))}, input[at:]...)...)
+					}
+				}
+				check(input)
+			}
+
+			for i := 0; i < 2000; i++ {
+				input := make([]byte, rnd.Intn(64))
+				rnd.Read(input)
+				check(input)
+			}
+		})
+	}
 }
@@ -42,6 +42,18 @@ func fillersFromRem(in abi.UnpaddedPieceSize) ([]abi.UnpaddedPieceSize, error) {
 	return out, nil
 }
 
+// alignmentFillers returns sizes of filler pieces which align a piece written
+// after pieces taking up offset bytes. Pieces start at a multiple of their
+// padded size, ffi.WriteWithAlignment pads the space before them with zeros
+func alignmentFillers(offset abi.UnpaddedPieceSize, size abi.UnpaddedPieceSize) ([]abi.UnpaddedPieceSize, error) {
+	poff, psize := offset.Padded(), size.Padded()
+	pad := (psize - poff%psize) % psize
+	if pad == 0 {
+		return nil, nil
+	}
+	return fillersFromRem(pad.Unpadded())
+}
+
 func (m *Sealing) ListSectors() ([]SectorInfo, error) {
 	var sectors []SectorInfo
 	if err := m.sectors.List(&sectors); err != nil {
@@ -43,3 +43,33 @@ func TestFillersFromRem(t *testing.T) {
 		testFill(t, ub, []abi.UnpaddedPieceSize{ub1, ub4})
 	}
 }
+
+func TestAlignmentFillers(t *testing.T) {
+	u := func(padded uint64) abi.UnpaddedPieceSize {
+		return abi.PaddedPieceSize(padded).Unpadded()
+	}
+
+	for _, tc := range []struct {
+		offset, size abi.UnpaddedPieceSize
+		exp          []abi.UnpaddedPieceSize
+	}{
+		{0, u(1024), nil},
+		{u(1024), u(1024), nil},
+		{u(1024), u(512), nil},
+		{u(128), u(512), []abi.UnpaddedPieceSize{u(128), u(256)}},
+		{u(512), u(2048), []abi.UnpaddedPieceSize{u(512), u(1024)}},
+		{u(1536), u(1024), []abi.UnpaddedPieceSize{u(512)}},
+	} {
+		f, err := alignmentFillers(tc.offset, tc.size)
+		assert.NoError(t, err)
+		assert.Equal(t, tc.exp, f)
+
+		// the filled offset is aligned, and each filler is aligned too
+		off := tc.offset.Padded()
+		for _, fs := range f {
+			assert.Zero(t, off%fs.Padded())
+			off += fs.Padded()
+		}
+		assert.Zero(t, off%tc.size.Padded())
+	}
+}